@@ -3,6 +3,7 @@ package main
 import (
 	"context"
 
+	"github.com/jmsilvadev/tx-parser/internal/handlers"
 	server "github.com/jmsilvadev/tx-parser/internal/server"
 	"github.com/jmsilvadev/tx-parser/pkg/config"
 )
@@ -15,9 +16,46 @@ func main() {
 func run(conf *config.Config) error {
 	serverOptions := []server.ServerOption{
 		server.WithPort(conf.ServerPort),
+		server.WithGRPCPort(conf.GRPCPort),
 		server.WithEnvironment(conf.Env),
 		server.WithLogger(conf.Logger),
 		server.WithParser(conf.Parser),
+		server.WithJsonRpc(conf.JsonRpc),
+		server.WithWebhooks(conf.Webhooks),
+		server.WithBus(conf.Bus),
+		server.WithPublisher(conf.Publisher),
+		server.WithMetrics(conf.Metrics),
+		server.WithHealth(conf.Health),
+		server.WithTracing(conf.Tracing),
+		server.WithLogLevel(conf.LogLevel),
+		server.WithCompression(conf.Compression),
+		server.WithAccessLog(conf.AccessLog),
+		server.WithTimeouts(server.Timeouts{
+			ReadHeader: conf.ReadHeaderTimeout,
+			Read:       conf.ReadTimeout,
+			Write:      conf.WriteTimeout,
+			Idle:       conf.IdleTimeout,
+		}),
+		server.WithMaxHeaderBytes(conf.MaxHeaderBytes),
+		server.WithAPIKeys(conf.APIKeys),
+		server.WithHTTP2(conf.HTTP2),
+	}
+	if conf.TLS != nil {
+		serverOptions = append(serverOptions, server.WithTLS(conf.TLS))
+	}
+	if len(conf.CORSOrigins) > 0 {
+		serverOptions = append(serverOptions, server.WithCORS(handlers.CORSConfig{
+			AllowedOrigins: conf.CORSOrigins,
+			AllowedHeaders: conf.CORSHeaders,
+		}))
+	}
+
+	if conf.RateLimit.Rate > 0 || len(conf.RouteRateLimits) > 0 || conf.DailyQuota > 0 {
+		serverOptions = append(serverOptions, server.WithRateLimit(handlers.RateLimitConfig{
+			Default:    conf.RateLimit,
+			Routes:     conf.RouteRateLimits,
+			DailyQuota: conf.DailyQuota,
+		}))
 	}
 
 	s := server.NewServer(serverOptions...)
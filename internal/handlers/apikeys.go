@@ -0,0 +1,299 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/jmsilvadev/tx-parser/pkg/apikey"
+	"github.com/jmsilvadev/tx-parser/pkg/logger"
+	"github.com/jmsilvadev/tx-parser/pkg/parser"
+	"github.com/jmsilvadev/tx-parser/pkg/ratelimit"
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// Default Values
+var (
+	authFailureLimit = ratelimit.Limit{Rate: 0.2, Burst: 10}
+)
+
+// Authenticate puts the API key of the caller, sent in the Authorization
+// header as a bearer token or in X-API-Key, along with its tenant in the
+// context of the request. Requests without a key go on anonymously, as the
+// default tenant, RequireScope turning them away from the routes that
+// need one. Addresses failing to authenticate too often get 429 without
+// their keys being looked up, so guessing keys cannot load the store.
+func Authenticate(keys *apikey.Store, next http.Handler) http.Handler {
+	failures := ratelimit.NewLimiter(authFailureLimit)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		secret := r.Header.Get("X-API-Key")
+		if v, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok {
+			secret = v
+		}
+		if secret == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		ip := remoteIP(r)
+		if wait := failures.Delay(ip); wait > 0 {
+			tooManyRequests(w, "too many failed authentications", wait)
+			return
+		}
+		key, err := keys.Authenticate(secret)
+		if err != nil {
+			failures.Allow(ip)
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			response := Response{
+				Status:  "error",
+				Message: err.Error(),
+			}
+			writeJSONResponse(w, http.StatusUnauthorized, response)
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(withKey(r.Context(), key)))
+	})
+}
+
+// RequireScope only lets callers whose key grants scope through.
+func RequireScope(scope string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		key, ok := apikey.FromContext(r.Context())
+		if !ok {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			response := Response{
+				Status:  "error",
+				Message: "api key required",
+			}
+			writeJSONResponse(w, http.StatusUnauthorized, response)
+			return
+		}
+		if !key.Has(scope) {
+			response := Response{
+				Status:  "error",
+				Message: "api key lacks the " + scope + " scope",
+			}
+			writeJSONResponse(w, http.StatusForbidden, response)
+			return
+		}
+		next(w, r)
+	}
+}
+
+// withKey returns a copy of ctx carrying the key, its tenant and a logger
+// adding them to the entries.
+func withKey(ctx context.Context, key apikey.Key) context.Context {
+	ctx = parser.NewTenantContext(apikey.NewContext(ctx, key), key.Tenant)
+	log := logger.With(logger.FromContext(ctx, nil), zap.String("api_key", key.ID), zap.String("tenant", key.Tenant))
+	return logger.NewContext(ctx, log)
+}
+
+// authorized tells whether the caller may do what scope covers. Without a
+// key in the context authentication is disabled, the routes requiring one
+// having turned away the anonymous callers.
+func authorized(ctx context.Context, scope string) bool {
+	key, ok := apikey.FromContext(ctx)
+	return !ok || key.Has(scope)
+}
+
+// manages tells whether the caller may manage the keys and the dead
+// letters of tenant: operators those of every tenant, admins their own.
+// Without a key authentication is disabled, leaving a single tenant.
+func manages(ctx context.Context, tenant string) bool {
+	key, ok := apikey.FromContext(ctx)
+	return !ok || key.Has(apikey.ScopeOperator) || key.Tenant == tenant
+}
+
+// CreateAPIKeyHandler creates a key from
+// {"name":"...","tenant":"...","scopes":["read"]}, the tenant defaulting to
+// the default one. Its secret is only ever returned here.
+func CreateAPIKeyHandler(keys *apikey.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var reqBody struct {
+			Name   string   `json:"name"`
+			Tenant string   `json:"tenant"`
+			Scopes []string `json:"scopes"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&reqBody); err != nil {
+			response := Response{
+				Status:  "error",
+				Message: "invalid request body",
+			}
+			writeJSONResponse(w, http.StatusBadRequest, response)
+			return
+		}
+
+		if reqBody.Tenant == "" {
+			reqBody.Tenant = parser.TenantFromContext(r.Context())
+		}
+		if !manages(r.Context(), reqBody.Tenant) || (slices.Contains(reqBody.Scopes, apikey.ScopeOperator) && !authorized(r.Context(), apikey.ScopeOperator)) {
+			response := Response{
+				Status:  "error",
+				Message: "api key lacks the " + apikey.ScopeOperator + " scope",
+			}
+			writeJSONResponse(w, http.StatusForbidden, response)
+			return
+		}
+
+		key, secret, err := keys.Create(reqBody.Name, reqBody.Tenant, reqBody.Scopes)
+		if err != nil {
+			code := http.StatusInternalServerError
+			if errors.Is(err, apikey.ErrInvalidScope) || errors.Is(err, apikey.ErrInvalidTenant) {
+				code = http.StatusBadRequest
+			}
+			response := Response{
+				Status:  "error",
+				Message: err.Error(),
+			}
+			writeJSONResponse(w, code, response)
+			return
+		}
+		logger.FromContext(r.Context(), nil).Warn("api key created", zap.String("id", key.ID), zap.String("key_tenant", key.Tenant), zap.Strings("scopes", key.Scopes))
+
+		response := Response{
+			Status: "success",
+			Data: struct {
+				apikey.Key
+				Secret string `json:"secret"`
+			}{key, secret},
+		}
+		writeJSONResponse(w, http.StatusCreated, response)
+	}
+}
+
+// ListAPIKeysHandler lists the keys the caller manages, without their
+// secrets.
+func ListAPIKeysHandler(keys *apikey.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		all, err := keys.List()
+		if err != nil {
+			response := Response{
+				Status:  "error",
+				Message: err.Error(),
+			}
+			writeJSONResponse(w, http.StatusInternalServerError, response)
+			return
+		}
+		list := []apikey.Key{}
+		for _, key := range all {
+			if manages(r.Context(), key.Tenant) {
+				list = append(list, key)
+			}
+		}
+		response := Response{
+			Status: "success",
+			Data:   list,
+		}
+		writeJSONResponse(w, http.StatusOK, response)
+	}
+}
+
+// RevokeAPIKeyHandler revokes the key of the id query param, the keys of
+// the tenants the caller does not manage being not found.
+func RevokeAPIKeyHandler(keys *apikey.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id := r.URL.Query().Get("id")
+		key, err := keys.Get(id)
+		if err == nil && !manages(r.Context(), key.Tenant) {
+			err = apikey.ErrNotFound
+		}
+		if err == nil {
+			key, err = keys.Revoke(id)
+		}
+		if err != nil {
+			code := http.StatusInternalServerError
+			if errors.Is(err, apikey.ErrNotFound) {
+				code = http.StatusNotFound
+			}
+			response := Response{
+				Status:  "error",
+				Message: err.Error(),
+			}
+			writeJSONResponse(w, code, response)
+			return
+		}
+		logger.FromContext(r.Context(), nil).Warn("api key revoked", zap.String("id", key.ID))
+
+		response := Response{
+			Status: "success",
+			Data:   key,
+		}
+		writeJSONResponse(w, http.StatusOK, response)
+	}
+}
+
+// grpcScopes are the scopes of the gRPC methods, by name.
+var grpcScopes = map[string]string{
+	"GetCurrentBlock":   apikey.ScopeRead,
+	"GetTransactions":   apikey.ScopeRead,
+	"WatchTransactions": apikey.ScopeRead,
+	"Subscribe":         apikey.ScopeSubscribe,
+	"Unsubscribe":       apikey.ScopeSubscribe,
+}
+
+// GRPCAuth returns the interceptors checking the API key of the gRPC
+// calls, sent in the authorization metadata as a bearer token or in
+// x-api-key.
+func GRPCAuth(keys *apikey.Store) (grpc.UnaryServerInterceptor, grpc.StreamServerInterceptor) {
+	authenticate := func(ctx context.Context, fullMethod string) (context.Context, error) {
+		md, _ := metadata.FromIncomingContext(ctx)
+		var secret string
+		if v := md.Get("x-api-key"); len(v) > 0 {
+			secret = v[0]
+		}
+		if v := md.Get("authorization"); len(v) > 0 {
+			if token, ok := strings.CutPrefix(v[0], "Bearer "); ok {
+				secret = token
+			}
+		}
+		if secret == "" {
+			return nil, status.Error(codes.Unauthenticated, "api key required")
+		}
+		key, err := keys.Authenticate(secret)
+		if err != nil {
+			return nil, status.Error(codes.Unauthenticated, err.Error())
+		}
+
+		scope, ok := grpcScopes[fullMethod[strings.LastIndex(fullMethod, "/")+1:]]
+		if !ok {
+			scope = apikey.ScopeAdmin
+		}
+		if !key.Has(scope) {
+			return nil, status.Error(codes.PermissionDenied, "api key lacks the "+scope+" scope")
+		}
+		return withKey(ctx, key), nil
+	}
+
+	unary := func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		ctx, err := authenticate(ctx, info.FullMethod)
+		if err != nil {
+			return nil, err
+		}
+		return handler(ctx, req)
+	}
+	stream := func(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		ctx, err := authenticate(ss.Context(), info.FullMethod)
+		if err != nil {
+			return err
+		}
+		return handler(srv, &authStream{ServerStream: ss, ctx: ctx})
+	}
+	return unary, stream
+}
+
+// authStream hands the authenticated context to the stream handlers.
+type authStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *authStream) Context() context.Context {
+	return s.ctx
+}
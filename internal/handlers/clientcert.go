@@ -0,0 +1,29 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/jmsilvadev/tx-parser/pkg/response"
+)
+
+// RequireClientCert turns away the requests made without a verified client
+// certificate, but to the exempt routes, e.g. the probes of the kubelet,
+// which cannot present one. The TLS handshake only verifies the
+// certificates given, so those routes stay reachable.
+func RequireClientCert(mux *http.ServeMux, exempt []string, next http.Handler) http.Handler {
+	routes := make(map[string]bool, len(exempt))
+	for _, route := range exempt {
+		routes[route] = true
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if (r.TLS == nil || len(r.TLS.VerifiedChains) == 0) && !routes[response.Route(mux, r)] {
+			response := Response{
+				Status:  "error",
+				Message: "client certificate required",
+			}
+			writeJSONResponse(w, http.StatusUnauthorized, response)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
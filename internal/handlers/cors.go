@@ -0,0 +1,79 @@
+package handlers
+
+import (
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Default Values
+var (
+	corsMethods = []string{http.MethodGet, http.MethodPost, http.MethodPut, http.MethodDelete}
+	corsHeaders = []string{"Content-Type", "Authorization", "X-Request-ID", "X-API-Key"}
+	corsExposed = []string{"X-Request-ID", "Retry-After"}
+	corsMaxAge  = 10 * time.Minute
+)
+
+// CORSConfig lists what the browsers of other origins may do. Empty
+// fields get the defaults, but for AllowedOrigins, where "*" allows them
+// all.
+type CORSConfig struct {
+	AllowedOrigins   []string
+	AllowedMethods   []string
+	AllowedHeaders   []string
+	ExposedHeaders   []string
+	AllowCredentials bool
+	MaxAge           time.Duration
+}
+
+// CORS lets browsers of the allowed origins call the API, answering their
+// preflight requests itself.
+func CORS(c CORSConfig, next http.Handler) http.Handler {
+	if len(c.AllowedMethods) == 0 {
+		c.AllowedMethods = corsMethods
+	}
+	if len(c.AllowedHeaders) == 0 {
+		c.AllowedHeaders = corsHeaders
+	}
+	if len(c.ExposedHeaders) == 0 {
+		c.ExposedHeaders = corsExposed
+	}
+	if c.MaxAge == 0 {
+		c.MaxAge = corsMaxAge
+	}
+	anyOrigin := slices.Contains(c.AllowedOrigins, "*")
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		origin := r.Header.Get("Origin")
+		w.Header().Add("Vary", "Origin")
+		if origin == "" || !(anyOrigin || slices.Contains(c.AllowedOrigins, origin)) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		// a wildcard cannot be sent along with credentials
+		if anyOrigin && !c.AllowCredentials {
+			w.Header().Set("Access-Control-Allow-Origin", "*")
+		} else {
+			w.Header().Set("Access-Control-Allow-Origin", origin)
+		}
+		if c.AllowCredentials {
+			w.Header().Set("Access-Control-Allow-Credentials", "true")
+		}
+
+		if r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != "" {
+			w.Header().Add("Vary", "Access-Control-Request-Method")
+			w.Header().Add("Vary", "Access-Control-Request-Headers")
+			w.Header().Set("Access-Control-Allow-Methods", strings.Join(c.AllowedMethods, ", "))
+			w.Header().Set("Access-Control-Allow-Headers", strings.Join(c.AllowedHeaders, ", "))
+			w.Header().Set("Access-Control-Max-Age", strconv.Itoa(int(c.MaxAge.Seconds())))
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+
+		w.Header().Set("Access-Control-Expose-Headers", strings.Join(c.ExposedHeaders, ", "))
+		next.ServeHTTP(w, r)
+	})
+}
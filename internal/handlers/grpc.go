@@ -0,0 +1,166 @@
+package handlers
+
+import (
+	"context"
+	"strings"
+
+	"github.com/jmsilvadev/tx-parser/internal/stream"
+	parserv1 "github.com/jmsilvadev/tx-parser/pkg/api/parser/v1"
+	"github.com/jmsilvadev/tx-parser/pkg/parser"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+type grpcService struct {
+	parserv1.UnimplementedParserServiceServer
+	h *handler
+}
+
+// GRPCService serves the parser over gRPC, with the same validation and
+// behaviour as the REST handlers.
+func (h *handler) GRPCService() parserv1.ParserServiceServer {
+	return &grpcService{h: h}
+}
+
+func (s *grpcService) GetCurrentBlock(ctx context.Context, req *parserv1.GetCurrentBlockRequest) (*parserv1.GetCurrentBlockResponse, error) {
+	return &parserv1.GetCurrentBlockResponse{
+		BlockNumber: int64(s.h.parser.GetCurrentBlock(ctx)),
+	}, nil
+}
+
+func (s *grpcService) Subscribe(ctx context.Context, req *parserv1.SubscribeRequest) (*parserv1.SubscribeResponse, error) {
+	if err := ValidateAddress(req.Address); err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	if req.Webhook != "" && s.h.webhooks == nil {
+		return nil, status.Error(codes.FailedPrecondition, "webhooks are not enabled")
+	}
+
+	// registering a webhook for an address already subscribed is fine
+	subscribed := s.h.parser.Subscribe(ctx, req.Address)
+	if !subscribed && (req.Webhook == "" || !s.h.subscribed(ctx, req.Address)) {
+		return nil, status.Error(codes.AlreadyExists, "address already subscribed")
+	}
+
+	// the webhook is only registered once the address is subscribed, the
+	// subscription being rolled back when it is refused
+	if req.Webhook != "" {
+		if err := s.h.webhooks.Register(ctx, req.Address, req.Webhook); err != nil {
+			if subscribed {
+				s.h.parser.Unsubscribe(ctx, req.Address)
+			}
+			return nil, status.Error(codes.InvalidArgument, err.Error())
+		}
+	}
+	return &parserv1.SubscribeResponse{Subscribed: subscribed}, nil
+}
+
+func (s *grpcService) Unsubscribe(ctx context.Context, req *parserv1.UnsubscribeRequest) (*parserv1.UnsubscribeResponse, error) {
+	if err := ValidateAddress(req.Address); err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	if !s.h.unsubscribe(ctx, req.Address) {
+		return nil, status.Error(codes.NotFound, "address not subscribed")
+	}
+	return &parserv1.UnsubscribeResponse{Unsubscribed: true}, nil
+}
+
+func (s *grpcService) GetTransactions(ctx context.Context, req *parserv1.GetTransactionsRequest) (*parserv1.GetTransactionsResponse, error) {
+	if err := ValidateAddress(req.Address); err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	page, next, err := Paginate(s.h.parser.GetTransactions(ctx, req.Address), int(req.PageSize), req.PageToken)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	resp := &parserv1.GetTransactionsResponse{NextPageToken: next}
+	for _, tx := range page {
+		resp.Transactions = append(resp.Transactions, toProto(tx))
+	}
+	return resp, nil
+}
+
+// WatchTransactions works as the Stream handler: the history after the
+// cursor is replayed first, then the transactions come as they are
+// ingested. A client too slow to keep up gets Unavailable and is expected
+// to resume from its last cursor.
+func (s *grpcService) WatchTransactions(req *parserv1.WatchTransactionsRequest, srv grpc.ServerStreamingServer[parserv1.WatchTransactionsResponse]) error {
+	if len(req.Addresses) == 0 {
+		return status.Error(codes.InvalidArgument, ErrAddressRequired.Error())
+	}
+	for _, address := range req.Addresses {
+		if err := ValidateAddress(address); err != nil {
+			return status.Error(codes.InvalidArgument, err.Error())
+		}
+	}
+
+	var cursor *stream.Cursor
+	if req.Cursor != "" {
+		c, err := stream.ParseCursor(req.Cursor)
+		if err != nil {
+			return status.Error(codes.InvalidArgument, err.Error())
+		}
+		cursor = &c
+	}
+
+	ctx := srv.Context()
+	for _, address := range req.Addresses {
+		if !s.h.subscribed(ctx, address) {
+			return status.Error(codes.NotFound, "address not subscribed")
+		}
+	}
+
+	if s.h.hub == nil {
+		return status.Error(codes.Unimplemented, "streaming not supported")
+	}
+
+	sub := s.h.hub.Subscribe(req.Addresses...)
+	defer s.h.hub.Unsubscribe(sub)
+
+	sent := make(map[string]bool)
+	if cursor != nil {
+		for _, address := range req.Addresses {
+			for _, tx := range stream.After(s.h.parser.GetTransactions(ctx, address), *cursor) {
+				id := stream.Cursor{BlockNumber: tx.BlockNumber, Hash: strings.ToLower(tx.Hash)}.String()
+				if sent[id] {
+					continue
+				}
+				sent[id] = true
+				if err := srv.Send(&parserv1.WatchTransactionsResponse{Transaction: toProto(tx), Cursor: id}); err != nil {
+					return err
+				}
+			}
+		}
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return status.FromContextError(ctx.Err()).Err()
+		case event, ok := <-sub.C:
+			if !ok {
+				return status.Error(codes.Unavailable, "too slow, resume from the last cursor")
+			}
+			if sent[event.ID] {
+				continue
+			}
+			if err := srv.Send(&parserv1.WatchTransactionsResponse{Transaction: toProto(event.Transaction), Cursor: event.ID}); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+func toProto(tx parser.Transaction) *parserv1.Transaction {
+	return &parserv1.Transaction{
+		Hash:        tx.Hash,
+		From:        tx.From,
+		To:          tx.To,
+		Value:       tx.Value,
+		BlockNumber: int64(tx.BlockNumber),
+	}
+}
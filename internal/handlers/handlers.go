@@ -1,10 +1,19 @@
 package handlers
 
 import (
+	"context"
 	"encoding/json"
+	"fmt"
 	"net/http"
+	"strings"
+	"time"
 
+	"github.com/jmsilvadev/tx-parser/internal/stream"
+	"github.com/jmsilvadev/tx-parser/pkg/health"
+	"github.com/jmsilvadev/tx-parser/pkg/jsonrpc"
+	"github.com/jmsilvadev/tx-parser/pkg/logger"
 	"github.com/jmsilvadev/tx-parser/pkg/parser"
+	"github.com/jmsilvadev/tx-parser/pkg/webhook"
 )
 
 type Response struct {
@@ -13,31 +22,92 @@ type Response struct {
 	Data    interface{} `json:"data,omitempty"`
 }
 
+// Default Values
+var (
+	heartbeatInterval = 15 * time.Second
+)
+
 type handler struct {
-	parser parser.Parser
+	parser   parser.Parser
+	webhooks *webhook.Dispatcher
+	hub      *stream.Hub
+	health   *health.Checker
+	// origins are the browser origins, besides the API's own, allowed to
+	// open WebSockets
+	origins []string
+}
+
+type HandlerOption func(*handler)
+
+func WithWebhooks(v *webhook.Dispatcher) HandlerOption {
+	return func(h *handler) {
+		h.webhooks = v
+	}
+}
+
+func WithHub(v *stream.Hub) HandlerOption {
+	return func(h *handler) {
+		h.hub = v
+	}
+}
+
+func WithHealth(v *health.Checker) HandlerOption {
+	return func(h *handler) {
+		h.health = v
+	}
+}
+
+// WithAllowedOrigins lets browsers of these origins open WebSockets, "*"
+// allowing them all, usually the origins allowed by CORS.
+func WithAllowedOrigins(v []string) HandlerOption {
+	return func(h *handler) {
+		h.origins = v
+	}
 }
 
-func New(p parser.Parser) *handler {
-	return &handler{parser: p}
+func New(p parser.Parser, options ...HandlerOption) *handler {
+	h := &handler{parser: p}
+	for _, opt := range options {
+		opt(h)
+	}
+	return h
 }
 
-func (h *handler) HealthHandler(w http.ResponseWriter, r *http.Request) {
+// Livez only tells the process is up and serving, it must not depend on
+// anything that a restart would not fix.
+func (h *handler) Livez(w http.ResponseWriter, r *http.Request) {
 	response := Response{
 		Status: "success",
 	}
 	writeJSONResponse(w, http.StatusOK, response)
 }
 
-func (h *handler) GetCurrentBlock(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodGet {
+// Readyz answers 503 while the parser cannot be trusted to serve current
+// data, with the state of each check in the body.
+func (h *handler) Readyz(w http.ResponseWriter, r *http.Request) {
+	if h.health == nil {
+		h.Livez(w, r)
+		return
+	}
+
+	report := h.health.Ready(r.Context())
+	if !report.Ready {
 		response := Response{
 			Status:  "error",
-			Message: "method not allowed",
+			Message: "not ready",
+			Data:    report,
 		}
-		writeJSONResponse(w, http.StatusMethodNotAllowed, response)
+		writeJSONResponse(w, http.StatusServiceUnavailable, response)
 		return
 	}
+	response := Response{
+		Status: "success",
+		Data:   report,
+	}
+	writeJSONResponse(w, http.StatusOK, response)
+}
 
+func (h *handler) GetCurrentBlock(w http.ResponseWriter, r *http.Request) {
 	block := h.parser.GetCurrentBlock(r.Context())
 	response := Response{
 		Status: "success",
@@ -47,77 +117,287 @@ func (h *handler) GetCurrentBlock(w http.ResponseWriter, r *http.Request) {
 }
 
 func (h *handler) Subscribe(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodPost {
+	var reqBody struct {
+		Address string `json:"address"`
+		Webhook string `json:"webhook,omitempty"`
+	}
+
+	// the address may also come in the query string
+	if r.Body != nil && r.Body != http.NoBody {
+		if err := json.NewDecoder(r.Body).Decode(&reqBody); err != nil {
+			response := Response{
+				Status:  "error",
+				Message: "invalid request body",
+			}
+			writeJSONResponse(w, http.StatusBadRequest, response)
+			return
+		}
+	}
+	if reqBody.Address == "" {
+		reqBody.Address = r.URL.Query().Get("address")
+	}
+	if err := ValidateAddress(reqBody.Address); err != nil {
 		response := Response{
 			Status:  "error",
-			Message: "method not allowed",
+			Message: err.Error(),
 		}
-		writeJSONResponse(w, http.StatusMethodNotAllowed, response)
+		writeJSONResponse(w, http.StatusBadRequest, response)
 		return
 	}
 
-	var reqBody struct {
-		Address string `json:"address"`
+	if reqBody.Webhook != "" && h.webhooks == nil {
+		response := Response{
+			Status:  "error",
+			Message: "webhooks are not enabled",
+		}
+		writeJSONResponse(w, http.StatusBadRequest, response)
+		return
 	}
 
-	err := json.NewDecoder(r.Body).Decode(&reqBody)
-	if err != nil || reqBody.Address == "" {
+	// registering a webhook for an address already subscribed is fine
+	success := h.parser.Subscribe(r.Context(), reqBody.Address)
+	if !success && (reqBody.Webhook == "" || !h.subscribed(r.Context(), reqBody.Address)) {
 		response := Response{
 			Status:  "error",
-			Message: "address is required",
+			Message: "Address already subscribed or invalid",
+			Data:    map[string]interface{}{"subscribed": false},
 		}
 		writeJSONResponse(w, http.StatusBadRequest, response)
 		return
 	}
 
-	success := h.parser.Subscribe(r.Context(), reqBody.Address)
+	// the webhook is only registered once the address is subscribed, the
+	// subscription being rolled back when it is refused
+	if reqBody.Webhook != "" {
+		if err := h.webhooks.Register(r.Context(), reqBody.Address, reqBody.Webhook); err != nil {
+			if success {
+				h.parser.Unsubscribe(r.Context(), reqBody.Address)
+			}
+			response := Response{
+				Status:  "error",
+				Message: err.Error(),
+			}
+			writeJSONResponse(w, http.StatusBadRequest, response)
+			return
+		}
+	}
+
+	data := map[string]interface{}{"subscribed": success}
+	if reqBody.Webhook != "" {
+		data["webhook"] = reqBody.Webhook
+	}
 	response := Response{
 		Status: "success",
-		Data:   map[string]bool{"subscribed": success},
+		Data:   data,
 	}
+	writeJSONResponse(w, http.StatusOK, response)
+}
 
-	if !success {
-		response.Status = "error"
-		response.Message = "Address already subscribed or invalid"
+func (h *handler) GetTransactions(w http.ResponseWriter, r *http.Request) {
+	address := r.URL.Query().Get("address")
+	if err := ValidateAddress(address); err != nil {
+		response := Response{
+			Status:  "error",
+			Message: err.Error(),
+		}
 		writeJSONResponse(w, http.StatusBadRequest, response)
-	} else {
-		writeJSONResponse(w, http.StatusOK, response)
+		return
+	}
+
+	transactions := h.parser.GetTransactions(r.Context(), address)
+	if transactions == nil {
+		transactions = []parser.Transaction{}
 	}
 
+	response := Response{
+		Status: "success",
+		Data:   transactions,
+	}
+	writeJSONResponse(w, http.StatusOK, response)
 }
 
-func (h *handler) GetTransactions(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodGet {
+// Stream sends the transactions of the requested addresses as Server-Sent
+// Events as they are ingested. Each event id is a cursor: a client sending
+// it back in Last-Event-ID first receives what it missed from the history.
+func (h *handler) Stream(w http.ResponseWriter, r *http.Request) {
+	addresses := r.URL.Query()["address"]
+	if len(addresses) == 0 {
+		addresses = []string{""}
+	}
+	for _, address := range addresses {
+		if err := ValidateAddress(address); err != nil {
+			response := Response{
+				Status:  "error",
+				Message: err.Error(),
+			}
+			writeJSONResponse(w, http.StatusBadRequest, response)
+			return
+		}
+	}
+
+	var cursor *stream.Cursor
+	if lastEventID := r.Header.Get("Last-Event-ID"); lastEventID != "" {
+		c, err := stream.ParseCursor(lastEventID)
+		if err != nil {
+			response := Response{
+				Status:  "error",
+				Message: err.Error(),
+			}
+			writeJSONResponse(w, http.StatusBadRequest, response)
+			return
+		}
+		cursor = &c
+	}
+
+	for _, address := range addresses {
+		if !h.subscribed(r.Context(), address) {
+			response := Response{
+				Status:  "error",
+				Message: "address not subscribed",
+			}
+			writeJSONResponse(w, http.StatusNotFound, response)
+			return
+		}
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok || h.hub == nil {
 		response := Response{
 			Status:  "error",
-			Message: "method not allowed",
+			Message: "streaming not supported",
 		}
-		writeJSONResponse(w, http.StatusMethodNotAllowed, response)
+		writeJSONResponse(w, http.StatusInternalServerError, response)
 		return
 	}
 
-	address := r.URL.Query().Get("address")
-	if address == "" {
+	// subscribe before reading the history, so nothing ingested in between
+	// is lost; the replayed transactions are skipped when they come live
+	sub := h.hub.Subscribe(addresses...)
+	defer h.hub.Unsubscribe(sub)
+
+	keepOpen(w)
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+
+	sent := make(map[string]bool)
+	if cursor != nil {
+		for _, address := range addresses {
+			for _, tx := range stream.After(h.parser.GetTransactions(r.Context(), address), *cursor) {
+				id := stream.Cursor{BlockNumber: tx.BlockNumber, Hash: strings.ToLower(tx.Hash)}.String()
+				if sent[id] {
+					continue
+				}
+				sent[id] = true
+				writeEvent(w, id, tx)
+			}
+		}
+	}
+	flusher.Flush()
+
+	heartbeat := time.NewTicker(heartbeatInterval)
+	defer heartbeat.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-heartbeat.C:
+			fmt.Fprint(w, ": heartbeat\n\n")
+			flusher.Flush()
+		case event, ok := <-sub.C:
+			if !ok {
+				// dropped for being too slow, the client reconnects
+				return
+			}
+			if sent[event.ID] {
+				continue
+			}
+			writeEvent(w, event.ID, event.Transaction)
+			flusher.Flush()
+		}
+	}
+}
+
+// subscribed tells whether the tenant of ctx subscribed the address, so
+// the streams only carry its own transactions. The parsers unaware of the
+// tenants let every address through.
+func (h *handler) subscribed(ctx context.Context, address string) bool {
+	checker, ok := h.parser.(parser.SubscriptionChecker)
+	return !ok || checker.Subscribed(ctx, address)
+}
+
+// watched tells whether the tenant of ctx is known to have subscribed the
+// address, which the parsers unaware of the tenants cannot tell.
+func (h *handler) watched(ctx context.Context, address string) bool {
+	checker, ok := h.parser.(parser.SubscriptionChecker)
+	return ok && checker.Subscribed(ctx, address)
+}
+
+// unsubscribe removes the subscription of the tenant of ctx along with its
+// webhook for the address.
+func (h *handler) unsubscribe(ctx context.Context, address string) bool {
+	if !h.parser.Unsubscribe(ctx, address) {
+		return false
+	}
+	if h.webhooks != nil {
+		if err := h.webhooks.Unregister(ctx, address); err != nil {
+			logger.FromContext(ctx, nil).Error("failed to remove the webhook", logger.Address(address), logger.Err(err))
+		}
+	}
+	return true
+}
+
+// GetDeadLetters lists the dead letters of the tenants the caller manages.
+func (h *handler) GetDeadLetters(w http.ResponseWriter, r *http.Request) {
+	if h.webhooks == nil {
 		response := Response{
 			Status:  "error",
-			Message: "address is required",
+			Message: "webhooks are not enabled",
 		}
-		writeJSONResponse(w, http.StatusBadRequest, response)
+		writeJSONResponse(w, http.StatusNotFound, response)
 		return
 	}
 
-	transactions := h.parser.GetTransactions(r.Context(), address)
-	if transactions == nil {
-		transactions = []parser.Transaction{}
+	all, err := h.webhooks.DeadLetters()
+	if err != nil {
+		response := Response{
+			Status:  "error",
+			Message: "failed to read dead letters",
+		}
+		writeJSONResponse(w, http.StatusInternalServerError, response)
+		return
+	}
+	deliveries := []webhook.Delivery{}
+	for _, delivery := range all {
+		tenant := delivery.Tenant
+		if tenant == "" {
+			tenant = parser.DefaultTenant
+		}
+		if manages(r.Context(), tenant) {
+			deliveries = append(deliveries, delivery)
+		}
 	}
 
 	response := Response{
 		Status: "success",
-		Data:   transactions,
+		Data:   deliveries,
 	}
 	writeJSONResponse(w, http.StatusOK, response)
 }
 
+// UsageHandler reports the calls sent to each JSON-RPC provider, per method.
+func UsageHandler(u jsonrpc.UsageReporter) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		response := Response{
+			Status: "success",
+			Data:   u.Usage(),
+		}
+		writeJSONResponse(w, http.StatusOK, response)
+	}
+}
+
 func NotFoundHandler(w http.ResponseWriter, r *http.Request) {
 	response := Response{
 		Status:  "error",
@@ -126,6 +406,19 @@ func NotFoundHandler(w http.ResponseWriter, r *http.Request) {
 	writeJSONResponse(w, http.StatusNotFound, response)
 }
 
+func writeEvent(w http.ResponseWriter, id string, tx parser.Transaction) {
+	data, _ := json.Marshal(tx)
+	fmt.Fprintf(w, "id: %s\nevent: transaction\ndata: %s\n\n", id, data)
+}
+
+// keepOpen lifts the read and write timeouts of the server off streams,
+// which would otherwise be cut once they expire.
+func keepOpen(w http.ResponseWriter) {
+	rc := http.NewResponseController(w)
+	rc.SetReadDeadline(time.Time{})
+	rc.SetWriteDeadline(time.Time{})
+}
+
 func writeJSONResponse(w http.ResponseWriter, statusCode int, response Response) {
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(statusCode)
@@ -0,0 +1,44 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/jmsilvadev/tx-parser/pkg/logger"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// LogLevelHandler returns the level of the logger on GET and changes it,
+// without a restart, on PUT with {"level":"debug"}.
+func LogLevelHandler(level logger.Level) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPut {
+			var reqBody struct {
+				Level string `json:"level"`
+			}
+			var l zapcore.Level
+			if err := json.NewDecoder(r.Body).Decode(&reqBody); err != nil || l.UnmarshalText([]byte(reqBody.Level)) != nil {
+				response := Response{
+					Status:  "error",
+					Message: "invalid level",
+				}
+				writeJSONResponse(w, http.StatusBadRequest, response)
+				return
+			}
+
+			previous := level.Level()
+			level.SetLevel(l)
+			logger.FromContext(r.Context(), nil).Warn("log level changed",
+				zap.Stringer("from", previous),
+				zap.Stringer("to", l),
+			)
+		}
+
+		response := Response{
+			Status: "success",
+			Data:   map[string]string{"level": level.Level().String()},
+		}
+		writeJSONResponse(w, http.StatusOK, response)
+	}
+}
@@ -0,0 +1,74 @@
+package handlers
+
+import (
+	"net/http"
+	"runtime/debug"
+	"time"
+
+	"github.com/jmsilvadev/tx-parser/pkg/logger"
+	"github.com/jmsilvadev/tx-parser/pkg/response"
+	"go.uber.org/zap"
+)
+
+// Middleware wraps a handler with some behaviour of its own.
+type Middleware func(next http.Handler) http.Handler
+
+// Chain wraps h in the middlewares, the first one seeing the requests
+// first.
+func Chain(h http.Handler, middlewares ...Middleware) http.Handler {
+	for i := len(middlewares) - 1; i >= 0; i-- {
+		h = middlewares[i](h)
+	}
+	return h
+}
+
+// Recover turns the panics of next into a 500 with the usual error body,
+// unless next already answered, and logs them with their stack.
+func Recover(l logger.Logger, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rec := response.NewRecorder(w)
+		defer func() {
+			v := recover()
+			if v == nil {
+				return
+			}
+			if v == http.ErrAbortHandler {
+				// the handler asks for the connection to be dropped
+				panic(v)
+			}
+			logger.FromContext(r.Context(), l).Error("handler panicked",
+				zap.Any("panic", v),
+				zap.ByteString("stack", debug.Stack()),
+			)
+			if rec.Written() {
+				return
+			}
+			response := Response{
+				Status:  "error",
+				Message: "internal server error",
+			}
+			writeJSONResponse(rec, http.StatusInternalServerError, response)
+		}()
+		next.ServeHTTP(rec, r)
+	})
+}
+
+// AccessLog logs every request once served, with the fields of the logger
+// of its context, the request id among them.
+func AccessLog(l logger.Logger, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		rec := response.NewRecorder(w)
+		next.ServeHTTP(rec, r)
+
+		logger.FromContext(r.Context(), l).Info("request",
+			zap.String("method", r.Method),
+			zap.String("path", r.URL.Path),
+			zap.Int("status", rec.Status()),
+			zap.Int("bytes", rec.Bytes()),
+			zap.Duration("duration", time.Since(start)),
+			zap.String("remote_addr", r.RemoteAddr),
+			zap.String("user_agent", r.UserAgent()),
+		)
+	})
+}
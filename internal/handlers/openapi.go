@@ -0,0 +1,407 @@
+package handlers
+
+import (
+	"bytes"
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Default Values
+var (
+	maxRequestBody = int64(1 << 20)
+)
+
+//go:embed openapi.json
+var openAPIDocument []byte
+
+// openAPI is the subset of an OpenAPI 3 document the validator needs.
+type openAPI struct {
+	Paths      map[string]map[string]json.RawMessage `json:"paths"`
+	Components struct {
+		Parameters map[string]parameter `json:"parameters"`
+		Schemas    map[string]*schema   `json:"schemas"`
+	} `json:"components"`
+}
+
+type operation struct {
+	Parameters  []parameter  `json:"parameters"`
+	RequestBody *requestBody `json:"requestBody"`
+}
+
+type parameter struct {
+	Ref      string  `json:"$ref"`
+	Name     string  `json:"name"`
+	In       string  `json:"in"`
+	Required bool    `json:"required"`
+	Schema   *schema `json:"schema"`
+}
+
+type requestBody struct {
+	Required bool `json:"required"`
+	Content  map[string]struct {
+		Schema *schema `json:"schema"`
+	} `json:"content"`
+}
+
+type schema struct {
+	Ref                  string             `json:"$ref"`
+	Type                 string             `json:"type"`
+	Pattern              string             `json:"pattern"`
+	Enum                 []interface{}      `json:"enum"`
+	Properties           map[string]*schema `json:"properties"`
+	Required             []string           `json:"required"`
+	AdditionalProperties interface{}        `json:"additionalProperties"`
+	Items                *schema            `json:"items"`
+	AllOf                []*schema          `json:"allOf"`
+
+	pattern *regexp.Regexp
+}
+
+// route is an operation of the document, with its references resolved.
+type route struct {
+	query   []parameter
+	headers []parameter
+	body    *requestBody
+}
+
+type validator struct {
+	spec   *openAPI
+	routes map[string]map[string]*route
+}
+
+var loadValidator = sync.OnceValues(func() (*validator, error) {
+	return newValidator(openAPIDocument)
+})
+
+// OpenAPIHandler serves the OpenAPI document of the API.
+func OpenAPIHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(openAPIDocument)
+}
+
+// ValidateRequests checks the method, query params, headers and body of
+// the requests against the OpenAPI document before calling next, so the
+// handlers only see requests the document allows. Paths the document does
+// not describe are passed through.
+func ValidateRequests(next http.Handler) http.Handler {
+	v, err := loadValidator()
+	if err != nil {
+		panic(fmt.Sprintf("invalid openapi document: %v", err))
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		methods, ok := v.routes[r.URL.Path]
+		if !ok {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		rt, ok := methods[strings.ToLower(r.Method)]
+		if !ok {
+			allowed := make([]string, 0, len(methods))
+			for m := range methods {
+				allowed = append(allowed, strings.ToUpper(m))
+			}
+			sort.Strings(allowed)
+			w.Header().Set("Allow", strings.Join(allowed, ", "))
+			response := Response{
+				Status:  "error",
+				Message: "method not allowed",
+			}
+			writeJSONResponse(w, http.StatusMethodNotAllowed, response)
+			return
+		}
+
+		if err := v.validate(rt, r); err != nil {
+			response := Response{
+				Status:  "error",
+				Message: err.Error(),
+			}
+			writeJSONResponse(w, http.StatusBadRequest, response)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func newValidator(doc []byte) (*validator, error) {
+	spec := &openAPI{}
+	if err := json.Unmarshal(doc, spec); err != nil {
+		return nil, err
+	}
+	v := &validator{spec: spec, routes: make(map[string]map[string]*route)}
+	for name, s := range spec.Components.Schemas {
+		if err := compile(s); err != nil {
+			return nil, fmt.Errorf("schema %s: %w", name, err)
+		}
+	}
+
+	for path, item := range spec.Paths {
+		v.routes[path] = make(map[string]*route)
+		for method, raw := range item {
+			switch method {
+			case "get", "put", "post", "delete", "options", "head", "patch", "trace":
+			default:
+				continue
+			}
+
+			var op operation
+			if err := json.Unmarshal(raw, &op); err != nil {
+				return nil, fmt.Errorf("%s %s: %w", method, path, err)
+			}
+			rt := &route{body: op.RequestBody}
+			if op.RequestBody != nil {
+				for _, content := range op.RequestBody.Content {
+					if err := compile(content.Schema); err != nil {
+						return nil, fmt.Errorf("%s %s: %w", method, path, err)
+					}
+				}
+			}
+			for _, p := range op.Parameters {
+				if p.Ref != "" {
+					resolved, ok := spec.Components.Parameters[strings.TrimPrefix(p.Ref, "#/components/parameters/")]
+					if !ok {
+						return nil, fmt.Errorf("%s %s: unknown parameter %s", method, path, p.Ref)
+					}
+					p = resolved
+				}
+				if err := compile(p.Schema); err != nil {
+					return nil, fmt.Errorf("%s %s: %w", method, path, err)
+				}
+				switch p.In {
+				case "query":
+					rt.query = append(rt.query, p)
+				case "header":
+					rt.headers = append(rt.headers, p)
+				}
+			}
+			v.routes[path][method] = rt
+		}
+	}
+	return v, nil
+}
+
+func (v *validator) validate(rt *route, r *http.Request) error {
+	query := r.URL.Query()
+	for _, p := range rt.query {
+		values := query[p.Name]
+		if len(values) == 0 || (len(values) == 1 && values[0] == "") {
+			if p.Required {
+				return fmt.Errorf("%s is required", p.Name)
+			}
+			continue
+		}
+
+		s := v.resolve(p.Schema)
+		if s != nil && s.Type == "array" {
+			for _, value := range values {
+				if err := v.validateParam(s.Items, value); err != nil {
+					return fmt.Errorf("invalid %s: %w", p.Name, err)
+				}
+			}
+			continue
+		}
+		if err := v.validateParam(s, values[0]); err != nil {
+			return fmt.Errorf("invalid %s: %w", p.Name, err)
+		}
+	}
+
+	for _, p := range rt.headers {
+		if p.Required && r.Header.Get(p.Name) == "" {
+			return fmt.Errorf("%s header is required", p.Name)
+		}
+	}
+
+	if rt.body == nil {
+		return nil
+	}
+	var body []byte
+	if r.Body != nil {
+		var err error
+		body, err = io.ReadAll(io.LimitReader(r.Body, maxRequestBody+1))
+		if err != nil {
+			return fmt.Errorf("invalid request body")
+		}
+		if int64(len(body)) > maxRequestBody {
+			return fmt.Errorf("request body too large")
+		}
+		r.Body.Close()
+		// handlers tell a missing body by http.NoBody
+		r.Body = http.NoBody
+		if len(body) > 0 {
+			r.Body = io.NopCloser(bytes.NewReader(body))
+		}
+	}
+	if len(bytes.TrimSpace(body)) == 0 {
+		if rt.body.Required {
+			return fmt.Errorf("request body is required")
+		}
+		return nil
+	}
+
+	content, ok := rt.body.Content["application/json"]
+	if !ok || content.Schema == nil || isEmptySchema(content.Schema) {
+		return nil
+	}
+	var value interface{}
+	if err := json.Unmarshal(body, &value); err != nil {
+		return fmt.Errorf("invalid request body")
+	}
+	if err := v.validateValue(content.Schema, value, "body"); err != nil {
+		return fmt.Errorf("invalid request body: %w", err)
+	}
+	return nil
+}
+
+// validateParam checks a query or header value, which is always a string
+// on the wire.
+func (v *validator) validateParam(s *schema, value string) error {
+	s = v.resolve(s)
+	if s == nil {
+		return nil
+	}
+	switch s.Type {
+	case "integer":
+		n, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return fmt.Errorf("must be an integer")
+		}
+		return v.validateValue(s, float64(n), "")
+	case "boolean":
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return fmt.Errorf("must be a boolean")
+		}
+		return v.validateValue(s, b, "")
+	}
+	return v.validateValue(s, value, "")
+}
+
+// validateValue checks a decoded JSON value against the subset of JSON
+// Schema used by the document.
+func (v *validator) validateValue(s *schema, value interface{}, path string) error {
+	s = v.resolve(s)
+	if s == nil {
+		return nil
+	}
+	at := ""
+	if path != "" {
+		at = path + " "
+	}
+
+	for _, sub := range s.AllOf {
+		if err := v.validateValue(sub, value, path); err != nil {
+			return err
+		}
+	}
+
+	switch s.Type {
+	case "string":
+		str, ok := value.(string)
+		if !ok {
+			return fmt.Errorf("%smust be a string", at)
+		}
+		if s.pattern != nil && !s.pattern.MatchString(str) {
+			return fmt.Errorf("%smust match %s", at, s.Pattern)
+		}
+	case "integer":
+		n, ok := value.(float64)
+		if !ok || n != float64(int64(n)) {
+			return fmt.Errorf("%smust be an integer", at)
+		}
+	case "number":
+		if _, ok := value.(float64); !ok {
+			return fmt.Errorf("%smust be a number", at)
+		}
+	case "boolean":
+		if _, ok := value.(bool); !ok {
+			return fmt.Errorf("%smust be a boolean", at)
+		}
+	case "array":
+		items, ok := value.([]interface{})
+		if !ok {
+			return fmt.Errorf("%smust be an array", at)
+		}
+		for i, item := range items {
+			if err := v.validateValue(s.Items, item, fmt.Sprintf("%s[%d]", path, i)); err != nil {
+				return err
+			}
+		}
+	case "object":
+		obj, ok := value.(map[string]interface{})
+		if !ok {
+			return fmt.Errorf("%smust be an object", at)
+		}
+		for _, name := range s.Required {
+			if _, ok := obj[name]; !ok {
+				return fmt.Errorf("%s is required", name)
+			}
+		}
+		for name, field := range obj {
+			if prop, ok := s.Properties[name]; ok {
+				if err := v.validateValue(prop, field, name); err != nil {
+					return err
+				}
+				continue
+			}
+			if allowed, ok := s.AdditionalProperties.(bool); ok && !allowed {
+				return fmt.Errorf("unknown field %s", name)
+			}
+		}
+	}
+
+	if len(s.Enum) > 0 {
+		for _, e := range s.Enum {
+			if e == value {
+				return nil
+			}
+		}
+		return fmt.Errorf("%smust be one of %v", at, s.Enum)
+	}
+	return nil
+}
+
+func (v *validator) resolve(s *schema) *schema {
+	for s != nil && s.Ref != "" {
+		s = v.spec.Components.Schemas[strings.TrimPrefix(s.Ref, "#/components/schemas/")]
+	}
+	return s
+}
+
+// compile prepares the patterns of the schema once, at load time, as
+// schemas are shared by concurrent requests afterwards.
+func compile(s *schema) error {
+	if s == nil {
+		return nil
+	}
+	if s.Pattern != "" && s.pattern == nil {
+		re, err := regexp.Compile(s.Pattern)
+		if err != nil {
+			return err
+		}
+		s.pattern = re
+	}
+	for _, sub := range s.Properties {
+		if err := compile(sub); err != nil {
+			return err
+		}
+	}
+	for _, sub := range s.AllOf {
+		if err := compile(sub); err != nil {
+			return err
+		}
+	}
+	return compile(s.Items)
+}
+
+func isEmptySchema(s *schema) bool {
+	return s.Ref == "" && s.Type == "" && len(s.AllOf) == 0 && len(s.Properties) == 0 && len(s.Enum) == 0
+}
@@ -0,0 +1,110 @@
+package handlers
+
+import (
+	"context"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/jmsilvadev/tx-parser/pkg/apikey"
+	"github.com/jmsilvadev/tx-parser/pkg/parser"
+	"github.com/jmsilvadev/tx-parser/pkg/ratelimit"
+	"github.com/jmsilvadev/tx-parser/pkg/response"
+)
+
+// RateLimitConfig limits the requests of every client, identified by its
+// API key or else by its IP address. Routes override the Default limit by
+// mux pattern without the method, e.g. /v1/get-transactions, a route with
+// a zero Rate being neither limited nor counted in the quota. DailyQuota
+// caps the requests of a client per UTC day, none when zero.
+type RateLimitConfig struct {
+	Default    ratelimit.Limit
+	Routes     map[string]ratelimit.Limit
+	DailyQuota int
+}
+
+// RateLimiter answers 429 to the clients over their limit or their quota.
+type RateLimiter struct {
+	fallback *ratelimit.Limiter
+	routes   map[string]*ratelimit.Limiter
+	exempt   map[string]bool
+	quota    *ratelimit.Quota
+}
+
+// NewRateLimiter persists the daily quotas in store, if not nil.
+func NewRateLimiter(c RateLimitConfig, store parser.Store) *RateLimiter {
+	l := &RateLimiter{
+		fallback: ratelimit.NewLimiter(c.Default),
+		routes:   make(map[string]*ratelimit.Limiter),
+		exempt:   make(map[string]bool),
+		quota:    ratelimit.NewQuota(store, c.DailyQuota),
+	}
+	for route, limit := range c.Routes {
+		if limit.Rate <= 0 {
+			l.exempt[route] = true
+			continue
+		}
+		l.routes[route] = ratelimit.NewLimiter(limit)
+	}
+	return l
+}
+
+// Run persists the quotas until ctx is done.
+func (l *RateLimiter) Run(ctx context.Context) {
+	l.quota.Run(ctx)
+}
+
+// Handler limits the requests served by next, by the mux pattern they
+// match.
+func (l *RateLimiter) Handler(mux *http.ServeMux, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		route := response.Route(mux, r)
+		if l.exempt[route] {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		limiter, found := l.routes[route]
+		if !found {
+			limiter = l.fallback
+		}
+		client := clientOf(r)
+		if ok, wait := limiter.Allow(client); !ok {
+			tooManyRequests(w, "rate limit exceeded", wait)
+			return
+		}
+		if ok, wait := l.quota.Allow(client); !ok {
+			tooManyRequests(w, "daily quota exceeded", wait)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// clientOf identifies the caller by its API key, or by its IP address
+// when anonymous.
+func clientOf(r *http.Request) string {
+	if key, ok := apikey.FromContext(r.Context()); ok {
+		return "key:" + key.ID
+	}
+	return "ip:" + remoteIP(r)
+}
+
+func remoteIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+func tooManyRequests(w http.ResponseWriter, message string, wait time.Duration) {
+	w.Header().Set("Retry-After", strconv.Itoa(max(1, int(math.Ceil(wait.Seconds())))))
+	response := Response{
+		Status:  "error",
+		Message: message,
+	}
+	writeJSONResponse(w, http.StatusTooManyRequests, response)
+}
@@ -0,0 +1,189 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+
+	"github.com/jmsilvadev/tx-parser/pkg/apikey"
+	"github.com/jmsilvadev/tx-parser/pkg/jsonrpc"
+	"github.com/jmsilvadev/tx-parser/pkg/parser"
+)
+
+// Default Values
+var (
+	maxRPCBody  = int64(1 << 20)
+	maxRPCBatch = 100
+)
+
+// codeForbidden answers the methods the API key of the caller does not
+// grant, in the range left to servers.
+const codeForbidden = -32003
+
+type rpcMethod func(h *handler, ctx context.Context, params json.RawMessage) (interface{}, *jsonrpc.Error)
+
+var rpcMethods = map[string]rpcMethod{
+	"parser_getCurrentBlock": rpcGetCurrentBlock,
+	"parser_subscribe":       rpcSubscribe,
+	"parser_getTransactions": rpcGetTransactions,
+}
+
+// rpcScopes are the scopes of the methods beyond read, which /rpc itself
+// requires.
+var rpcScopes = map[string]string{
+	"parser_subscribe": apikey.ScopeSubscribe,
+}
+
+// RPC serves the parser as JSON-RPC 2.0 methods, single or batched.
+// Notifications, requests without an id, are run but get no response.
+func (h *handler) RPC(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxRPCBody))
+	if err != nil {
+		writeRPCResponse(w, rpcError(nil, jsonrpc.CodeParseError, "request too large or unreadable"))
+		return
+	}
+
+	body = bytes.TrimSpace(body)
+	if len(body) == 0 || body[0] != '[' {
+		response, ok := h.rpcCall(r.Context(), body)
+		if !ok {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		writeRPCResponse(w, response)
+		return
+	}
+
+	var batch []json.RawMessage
+	if err := json.Unmarshal(body, &batch); err != nil {
+		writeRPCResponse(w, rpcError(nil, jsonrpc.CodeParseError, "parse error"))
+		return
+	}
+	if len(batch) == 0 {
+		writeRPCResponse(w, rpcError(nil, jsonrpc.CodeInvalidRequest, "empty batch"))
+		return
+	}
+	if len(batch) > maxRPCBatch {
+		writeRPCResponse(w, rpcError(nil, jsonrpc.CodeInvalidRequest, "batch too large"))
+		return
+	}
+
+	responses := []jsonrpc.Response{}
+	for _, raw := range batch {
+		if response, ok := h.rpcCall(r.Context(), raw); ok {
+			responses = append(responses, response)
+		}
+	}
+	if len(responses) == 0 {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	writeRPCResponse(w, responses)
+}
+
+// rpcCall runs a single request. It returns false for notifications,
+// which must not be answered.
+func (h *handler) rpcCall(ctx context.Context, raw json.RawMessage) (jsonrpc.Response, bool) {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(raw, &fields); err != nil {
+		if json.Valid(raw) {
+			return rpcError(nil, jsonrpc.CodeInvalidRequest, "invalid request"), true
+		}
+		return rpcError(nil, jsonrpc.CodeParseError, "parse error"), true
+	}
+
+	var req jsonrpc.Request
+	if err := json.Unmarshal(raw, &req); err != nil || req.JsonRpc != jsonrpc.Version || req.Method == "" {
+		return rpcError(req.ID, jsonrpc.CodeInvalidRequest, "invalid request"), true
+	}
+	switch req.ID.(type) {
+	case nil, string, float64:
+	default:
+		return rpcError(nil, jsonrpc.CodeInvalidRequest, "invalid request id"), true
+	}
+	_, hasID := fields["id"]
+
+	method, ok := rpcMethods[req.Method]
+	if !ok {
+		return rpcError(req.ID, jsonrpc.CodeMethodNotFound, "method not found"), hasID
+	}
+	if scope, ok := rpcScopes[req.Method]; ok && !authorized(ctx, scope) {
+		return rpcError(req.ID, codeForbidden, "api key lacks the "+scope+" scope"), hasID
+	}
+	result, rpcErr := method(h, ctx, req.Params)
+	if rpcErr != nil {
+		return jsonrpc.Response{JsonRpc: jsonrpc.Version, Error: rpcErr, ID: req.ID}, hasID
+	}
+
+	data, err := json.Marshal(result)
+	if err != nil {
+		return rpcError(req.ID, jsonrpc.CodeInternalError, "internal error"), hasID
+	}
+	return jsonrpc.Response{JsonRpc: jsonrpc.Version, Result: data, ID: req.ID}, hasID
+}
+
+func rpcGetCurrentBlock(h *handler, ctx context.Context, params json.RawMessage) (interface{}, *jsonrpc.Error) {
+	return h.parser.GetCurrentBlock(ctx), nil
+}
+
+func rpcSubscribe(h *handler, ctx context.Context, params json.RawMessage) (interface{}, *jsonrpc.Error) {
+	address, rpcErr := addressParam(params)
+	if rpcErr != nil {
+		return nil, rpcErr
+	}
+	return h.parser.Subscribe(ctx, address), nil
+}
+
+func rpcGetTransactions(h *handler, ctx context.Context, params json.RawMessage) (interface{}, *jsonrpc.Error) {
+	address, rpcErr := addressParam(params)
+	if rpcErr != nil {
+		return nil, rpcErr
+	}
+	transactions := h.parser.GetTransactions(ctx, address)
+	if transactions == nil {
+		transactions = []parser.Transaction{}
+	}
+	return transactions, nil
+}
+
+// addressParam reads the address from positional, ["0x..."], or named,
+// {"address":"0x..."}, params.
+func addressParam(params json.RawMessage) (string, *jsonrpc.Error) {
+	var positional []string
+	var named struct {
+		Address string `json:"address"`
+	}
+
+	var address string
+	if err := json.Unmarshal(params, &positional); err == nil {
+		if len(positional) != 1 {
+			return "", &jsonrpc.Error{Code: jsonrpc.CodeInvalidParams, Message: "expected a single address param"}
+		}
+		address = positional[0]
+	} else if err := json.Unmarshal(params, &named); err == nil {
+		address = named.Address
+	} else {
+		return "", &jsonrpc.Error{Code: jsonrpc.CodeInvalidParams, Message: "invalid params"}
+	}
+
+	if err := ValidateAddress(address); err != nil {
+		return "", &jsonrpc.Error{Code: jsonrpc.CodeInvalidParams, Message: err.Error()}
+	}
+	return address, nil
+}
+
+func rpcError(id interface{}, code int, message string) jsonrpc.Response {
+	return jsonrpc.Response{
+		JsonRpc: jsonrpc.Version,
+		Error:   &jsonrpc.Error{Code: code, Message: message},
+		ID:      id,
+	}
+}
+
+func writeRPCResponse(w http.ResponseWriter, response interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(response)
+}
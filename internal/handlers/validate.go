@@ -0,0 +1,69 @@
+package handlers
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+
+	"github.com/jmsilvadev/tx-parser/pkg/parser"
+)
+
+// Default Values
+var (
+	defaultPageSize = 100
+	maxPageSize     = 1000
+)
+
+var (
+	ErrAddressRequired  = errors.New("address is required")
+	ErrInvalidAddress   = errors.New("address must be 0x followed by 40 hex digits")
+	ErrInvalidPageSize  = errors.New("page size must not be negative")
+	ErrInvalidPageToken = errors.New("invalid page token")
+)
+
+// ValidateAddress checks an address sent by a client, whatever the API it
+// came through.
+func ValidateAddress(address string) error {
+	if address == "" {
+		return ErrAddressRequired
+	}
+	digits, ok := strings.CutPrefix(strings.ToLower(address), "0x")
+	if !ok || len(digits) != 40 {
+		return ErrInvalidAddress
+	}
+	for _, c := range digits {
+		if (c < '0' || c > '9') && (c < 'a' || c > 'f') {
+			return ErrInvalidAddress
+		}
+	}
+	return nil
+}
+
+// Paginate returns the page of transactions starting at the token, and the
+// token of the next page, empty on the last one.
+func Paginate(transactions []parser.Transaction, pageSize int, pageToken string) ([]parser.Transaction, string, error) {
+	if pageSize < 0 {
+		return nil, "", ErrInvalidPageSize
+	}
+	if pageSize == 0 {
+		pageSize = defaultPageSize
+	}
+	if pageSize > maxPageSize {
+		pageSize = maxPageSize
+	}
+
+	start := 0
+	if pageToken != "" {
+		var err error
+		start, err = strconv.Atoi(pageToken)
+		if err != nil || start < 0 || start > len(transactions) {
+			return nil, "", ErrInvalidPageToken
+		}
+	}
+
+	end := start + pageSize
+	if end >= len(transactions) {
+		return transactions[start:], "", nil
+	}
+	return transactions[start:end], strconv.Itoa(end), nil
+}
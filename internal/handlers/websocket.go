@@ -0,0 +1,208 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/jmsilvadev/tx-parser/internal/stream"
+	"github.com/jmsilvadev/tx-parser/pkg/apikey"
+	"github.com/jmsilvadev/tx-parser/pkg/parser"
+)
+
+// Default Values
+var (
+	pongWait     = 60 * time.Second
+	pingInterval = 25 * time.Second
+	writeWait    = 10 * time.Second
+	maxMessage   = int64(4096)
+)
+
+var upgrader = websocket.Upgrader{
+	ReadBufferSize:  1024,
+	WriteBufferSize: 1024,
+}
+
+// wsRequest is a message sent by a WebSocket client.
+type wsRequest struct {
+	Type    string `json:"type"`
+	Address string `json:"address,omitempty"`
+}
+
+// wsMessage is a message sent to a WebSocket client.
+type wsMessage struct {
+	Type        string              `json:"type"`
+	ID          string              `json:"id,omitempty"`
+	Address     string              `json:"address,omitempty"`
+	BlockNumber int                 `json:"block_number,omitempty"`
+	Transaction *parser.Transaction `json:"transaction,omitempty"`
+	Message     string              `json:"message,omitempty"`
+}
+
+// WebSocket lets clients subscribe and unsubscribe addresses with
+// {"type":"subscribe","address":"0x..."} and {"type":"unsubscribe",...}
+// messages, and pushes them their transactions and every new block. The
+// addresses the connection subscribed first are unsubscribed when it
+// unsubscribes them or disconnects. A
+// client that does not keep up with the events is disconnected rather
+// than slowing down ingestion.
+func (h *handler) WebSocket(w http.ResponseWriter, r *http.Request) {
+	if h.hub == nil {
+		response := Response{
+			Status:  "error",
+			Message: "streaming not supported",
+		}
+		writeJSONResponse(w, http.StatusInternalServerError, response)
+		return
+	}
+
+	// the connection deadlines are managed below once upgraded
+	keepOpen(w)
+	u := upgrader
+	u.CheckOrigin = h.checkOrigin
+	conn, err := u.Upgrade(w, r, nil)
+	if err != nil {
+		// the upgrader already answered the client
+		return
+	}
+	defer conn.Close()
+
+	sub := h.hub.Subscribe()
+	defer h.hub.Unsubscribe(sub)
+	h.hub.WatchBlocks(sub)
+
+	// every write goes through the writer loop below
+	replies := make(chan wsMessage, 16)
+	done := make(chan struct{})
+	quit := make(chan struct{})
+	defer close(quit)
+	go h.readWebSocket(r, conn, sub, replies, done, quit)
+
+	ping := time.NewTicker(pingInterval)
+	defer ping.Stop()
+	for {
+		var msg wsMessage
+		select {
+		case <-done:
+			return
+		case <-ping.C:
+			conn.SetWriteDeadline(time.Now().Add(writeWait))
+			if err := conn.WriteMessage(websocket.PingMessage, nil); err != nil {
+				return
+			}
+			continue
+		case msg = <-replies:
+		case event, ok := <-sub.C:
+			if !ok {
+				conn.WriteControl(websocket.CloseMessage,
+					websocket.FormatCloseMessage(websocket.CloseTryAgainLater, "too slow"),
+					time.Now().Add(writeWait))
+				return
+			}
+			msg = wsMessage{Type: event.Type, ID: event.ID, BlockNumber: event.BlockNumber}
+			if event.Type == stream.EventTransaction {
+				tx := event.Transaction
+				msg.Transaction = &tx
+			}
+		}
+
+		conn.SetWriteDeadline(time.Now().Add(writeWait))
+		if err := conn.WriteJSON(msg); err != nil {
+			return
+		}
+	}
+}
+
+// checkOrigin lets through the clients that are not browsers, which send
+// no Origin, the pages of the API's own origin and the allowed origins.
+func (h *handler) checkOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	if u, err := url.Parse(origin); err == nil && strings.EqualFold(u.Host, r.Host) {
+		return true
+	}
+	return slices.Contains(h.origins, "*") || slices.Contains(h.origins, origin)
+}
+
+func (h *handler) readWebSocket(r *http.Request, conn *websocket.Conn, sub *stream.Subscriber, replies chan<- wsMessage, done, quit chan struct{}) {
+	defer close(done)
+
+	reply := func(msg wsMessage) {
+		select {
+		case replies <- msg:
+		case <-quit:
+		}
+	}
+
+	// the parser subscriptions made for this connection end with it, the
+	// others being left to whoever made them
+	owned := make(map[string]bool)
+	defer func() {
+		ctx := context.WithoutCancel(r.Context())
+		for address := range owned {
+			h.unsubscribe(ctx, address)
+		}
+	}()
+
+	conn.SetReadLimit(maxMessage)
+	conn.SetReadDeadline(time.Now().Add(pongWait))
+	conn.SetPongHandler(func(string) error {
+		return conn.SetReadDeadline(time.Now().Add(pongWait))
+	})
+
+	for {
+		_, data, err := conn.ReadMessage()
+		if err != nil {
+			return
+		}
+
+		var req wsRequest
+		if err := json.Unmarshal(data, &req); err != nil {
+			reply(wsMessage{Type: "error", Message: "invalid message"})
+			continue
+		}
+		if err := ValidateAddress(req.Address); err != nil {
+			reply(wsMessage{Type: "error", Message: err.Error()})
+			continue
+		}
+
+		switch req.Type {
+		case "subscribe":
+			// following an address the tenant watches only takes the read
+			// scope, subscribing a new one the subscribe scope
+			if !h.watched(r.Context(), req.Address) {
+				if !authorized(r.Context(), apikey.ScopeSubscribe) {
+					reply(wsMessage{Type: "error", Address: req.Address, Message: "api key lacks the " + apikey.ScopeSubscribe + " scope"})
+					continue
+				}
+				if h.parser.Subscribe(r.Context(), req.Address) {
+					owned[strings.ToLower(req.Address)] = true
+				} else if !h.subscribed(r.Context(), req.Address) {
+					reply(wsMessage{Type: "error", Address: req.Address, Message: "failed to subscribe"})
+					continue
+				}
+			}
+			h.hub.Add(sub, req.Address)
+			reply(wsMessage{Type: "subscribed", Address: req.Address})
+		case "unsubscribe":
+			h.hub.Remove(sub, req.Address)
+			if address := strings.ToLower(req.Address); owned[address] {
+				delete(owned, address)
+				if !h.unsubscribe(r.Context(), address) {
+					reply(wsMessage{Type: "error", Address: req.Address, Message: "failed to unsubscribe"})
+					continue
+				}
+			}
+			reply(wsMessage{Type: "unsubscribed", Address: req.Address})
+		default:
+			reply(wsMessage{Type: "error", Message: "unknown message type"})
+		}
+	}
+}
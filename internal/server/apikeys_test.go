@@ -0,0 +1,250 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/jmsilvadev/tx-parser/internal/handlers"
+	parserv1 "github.com/jmsilvadev/tx-parser/pkg/api/parser/v1"
+	"github.com/jmsilvadev/tx-parser/pkg/apikey"
+	"github.com/jmsilvadev/tx-parser/pkg/jsonrpc"
+	"github.com/jmsilvadev/tx-parser/pkg/jsonrpc/jsonrpctest"
+	"github.com/jmsilvadev/tx-parser/pkg/logger"
+	"github.com/jmsilvadev/tx-parser/pkg/parser/memorydb"
+	"github.com/jmsilvadev/tx-parser/pkg/webhook"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap/zapcore"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+func TestAPIKeys(t *testing.T) {
+	l := logger.New(zapcore.DebugLevel)
+	db := memorydb.New(jsonrpc.NewEthereum(l, "http://localhost:8545"), l)
+	s := NewServer(WithLogger(l), WithParser(db), WithAPIKeys(apikey.New(db, apikey.WithBootstrapKey("boot"))))
+	h := s.Handler()
+
+	do := func(method, target, secret, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, target, strings.NewReader(body))
+		if secret != "" {
+			req.Header.Set("Authorization", "Bearer "+secret)
+		}
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, req)
+		return rr
+	}
+
+	assert.Equal(t, http.StatusOK, do("GET", "/livez", "", "").Code)
+	assert.Equal(t, http.StatusOK, do("GET", "/openapi.json", "", "").Code)
+	assert.Equal(t, http.StatusUnauthorized, do("GET", "/v1/get-current-block", "", "").Code)
+	assert.Equal(t, http.StatusUnauthorized, do("GET", "/v1/get-current-block", "wrong", "").Code)
+	assert.Equal(t, http.StatusBadRequest, do("POST", "/admin/api-keys", "boot", `{"scopes":["root"]}`).Code)
+
+	rr := do("POST", "/admin/api-keys", "boot", `{"name":"dashboard","scopes":["read"]}`)
+	require.Equal(t, http.StatusCreated, rr.Code)
+	var created struct {
+		Data struct {
+			ID     string `json:"id"`
+			Secret string `json:"secret"`
+		} `json:"data"`
+	}
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &created))
+	read := created.Data.Secret
+
+	assert.Equal(t, http.StatusOK, do("GET", "/v1/get-current-block", read, "").Code)
+	assert.Equal(t, http.StatusForbidden, do("POST", "/v1/subscribe?address=0x1111111111111111111111111111111111111111", read, "").Code)
+	assert.Equal(t, http.StatusForbidden, do("GET", "/admin/api-keys", read, "").Code)
+
+	req := httptest.NewRequest("GET", "/v1/get-transactions?address=0x1111111111111111111111111111111111111111", nil)
+	req.Header.Set("X-API-Key", read)
+	rr = httptest.NewRecorder()
+	h.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusOK, rr.Code)
+
+	// the JSON-RPC methods have scopes of their own
+	rr = do("POST", "/rpc", read, `[{"jsonrpc":"2.0","method":"parser_getCurrentBlock","id":1},{"jsonrpc":"2.0","method":"parser_subscribe","params":["0x1111111111111111111111111111111111111111"],"id":2}]`)
+	require.Equal(t, http.StatusOK, rr.Code)
+	var responses []jsonrpc.Response
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &responses))
+	require.Len(t, responses, 2)
+	assert.Nil(t, responses[0].Error)
+	assert.Equal(t, -32003, responses[1].Error.Code)
+
+	// and so do the WebSocket messages
+	srv := httptest.NewServer(h)
+	defer srv.Close()
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http")+"/v1/ws", http.Header{"X-Api-Key": {read}})
+	require.NoError(t, err)
+	defer conn.Close()
+	var msg map[string]interface{}
+	require.NoError(t, conn.WriteJSON(map[string]string{"type": "subscribe", "address": "0x1111111111111111111111111111111111111111"}))
+	require.NoError(t, conn.ReadJSON(&msg))
+	assert.Equal(t, "error", msg["type"])
+	assert.Equal(t, "api key lacks the subscribe scope", msg["message"])
+	assert.False(t, db.Subscribed(context.Background(), "0x1111111111111111111111111111111111111111"))
+
+	// but may follow the addresses its tenant subscribed
+	db.Subscribe(context.Background(), "0x2222222222222222222222222222222222222222")
+	require.NoError(t, conn.WriteJSON(map[string]string{"type": "subscribe", "address": "0x2222222222222222222222222222222222222222"}))
+	require.NoError(t, conn.ReadJSON(&msg))
+	assert.Equal(t, "subscribed", msg["type"])
+
+	rr = do("GET", "/admin/api-keys", "boot", "")
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Contains(t, rr.Body.String(), created.Data.ID)
+	assert.NotContains(t, rr.Body.String(), read)
+
+	assert.Equal(t, http.StatusNotFound, do("DELETE", "/admin/api-keys?id=unknown", "boot", "").Code)
+	assert.Equal(t, http.StatusOK, do("DELETE", "/admin/api-keys?id="+created.Data.ID, "boot", "").Code)
+	assert.Equal(t, http.StatusUnauthorized, do("GET", "/v1/get-current-block", read, "").Code)
+}
+
+func TestGRPCAuth(t *testing.T) {
+	l := logger.New(zapcore.DebugLevel)
+	db := memorydb.New(jsonrpc.NewEthereum(l, "http://localhost:8545"), l)
+	keys := apikey.New(db)
+	_, read, err := keys.Create("reader", "", []string{apikey.ScopeRead})
+	require.NoError(t, err)
+
+	unary, stream := handlers.GRPCAuth(keys)
+	lis := bufconn.Listen(1 << 20)
+	srv := grpc.NewServer(grpc.UnaryInterceptor(unary), grpc.StreamInterceptor(stream))
+	parserv1.RegisterParserServiceServer(srv, handlers.New(db).GRPCService())
+	go srv.Serve(lis)
+	defer srv.Stop()
+
+	conn, err := grpc.NewClient("passthrough:///bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return lis.DialContext(ctx) }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	require.NoError(t, err)
+	defer conn.Close()
+	cli := parserv1.NewParserServiceClient(conn)
+
+	_, err = cli.GetCurrentBlock(context.Background(), &parserv1.GetCurrentBlockRequest{})
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+
+	ctx := metadata.AppendToOutgoingContext(context.Background(), "authorization", "Bearer "+read)
+	_, err = cli.GetCurrentBlock(ctx, &parserv1.GetCurrentBlockRequest{})
+	assert.NoError(t, err)
+	_, err = cli.Subscribe(ctx, &parserv1.SubscribeRequest{Address: "0x1111111111111111111111111111111111111111"})
+	assert.Equal(t, codes.PermissionDenied, status.Code(err))
+
+	watch, err := cli.WatchTransactions(metadata.AppendToOutgoingContext(context.Background(), "x-api-key", "wrong"), &parserv1.WatchTransactionsRequest{Addresses: []string{"0x1111111111111111111111111111111111111111"}})
+	require.NoError(t, err)
+	_, err = watch.Recv()
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+
+	// only the addresses subscribed by the tenant of the key can be watched
+	watch, err = cli.WatchTransactions(ctx, &parserv1.WatchTransactionsRequest{Addresses: []string{"0x1111111111111111111111111111111111111111"}})
+	require.NoError(t, err)
+	_, err = watch.Recv()
+	assert.Equal(t, codes.NotFound, status.Code(err))
+}
+
+func TestTenants(t *testing.T) {
+	l := logger.New(zapcore.DebugLevel)
+	node := jsonrpctest.NewServer(jsonrpctest.DefaultChain())
+	defer node.Close()
+
+	db := memorydb.New(jsonrpc.NewEthereum(l, node.URL), l)
+	keys := apikey.New(db)
+	_, acme, err := keys.Create("acme", "acme", []string{apikey.ScopeRead, apikey.ScopeSubscribe})
+	require.NoError(t, err)
+	_, globex, err := keys.Create("globex", "globex", []string{apikey.ScopeRead, apikey.ScopeSubscribe})
+	require.NoError(t, err)
+	h := NewServer(WithLogger(l), WithParser(db), WithAPIKeys(keys)).Handler()
+
+	do := func(method, target, secret string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, target, nil)
+		req.Header.Set("X-API-Key", secret)
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, req)
+		return rr
+	}
+	transactions := func(secret string) int {
+		var body struct {
+			Data []json.RawMessage `json:"data"`
+		}
+		require.NoError(t, json.Unmarshal(do("GET", "/v1/get-transactions?address=0xa1a1a1a1a1a1a1a1a1a1a1a1a1a1a1a1a1a1a1a1", secret).Body.Bytes(), &body))
+		return len(body.Data)
+	}
+
+	assert.Equal(t, http.StatusOK, do("POST", "/v1/subscribe?address=0xa1a1a1a1a1a1a1a1a1a1a1a1a1a1a1a1a1a1a1a1", acme).Code)
+	assert.Equal(t, http.StatusOK, do("POST", "/v1/subscribe?address=0xc3c3c3c3c3c3c3c3c3c3c3c3c3c3c3c3c3c3c3c3", globex).Code)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go db.UpdateBlockNumber(ctx)
+	require.Eventually(t, func() bool { return transactions(acme) == 1 }, time.Second, 10*time.Millisecond)
+
+	assert.Equal(t, 0, transactions(globex))
+	assert.Equal(t, http.StatusNotFound, do("GET", "/v1/stream?address=0xa1a1a1a1a1a1a1a1a1a1a1a1a1a1a1a1a1a1a1a1", globex).Code)
+}
+
+func TestTenantAdmin(t *testing.T) {
+	l := logger.New(zapcore.DebugLevel)
+	db := memorydb.New(jsonrpc.NewEthereum(l, "http://localhost:8545"), l)
+	keys := apikey.New(db, apikey.WithBootstrapKey("boot"))
+	globexKey, _, err := keys.Create("globex", "globex", []string{apikey.ScopeRead})
+	require.NoError(t, err)
+	_, acme, err := keys.Create("acme", "acme", []string{apikey.ScopeAdmin})
+	require.NoError(t, err)
+	for _, delivery := range []webhook.Delivery{{ID: "1", Tenant: "acme"}, {ID: "2", Tenant: "globex"}, {ID: "3"}} {
+		data, err := json.Marshal(delivery)
+		require.NoError(t, err)
+		require.NoError(t, db.Put("webhook-dead:"+delivery.ID, data))
+	}
+	h := NewServer(WithLogger(l), WithParser(db), WithAPIKeys(keys), WithWebhooks(webhook.New(db, l, []byte("secret")))).Handler()
+
+	do := func(method, target, secret, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, target, strings.NewReader(body))
+		req.Header.Set("X-API-Key", secret)
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, req)
+		return rr
+	}
+	tenants := func(target, secret string) []string {
+		var body struct {
+			Data []struct {
+				Tenant string `json:"tenant"`
+			} `json:"data"`
+		}
+		rr := do("GET", target, secret, "")
+		require.Equal(t, http.StatusOK, rr.Code)
+		require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &body))
+		var tenants []string
+		for _, item := range body.Data {
+			tenants = append(tenants, item.Tenant)
+		}
+		return tenants
+	}
+
+	// an admin only manages its own tenant
+	assert.Equal(t, []string{"acme"}, tenants("/admin/api-keys", acme))
+	assert.Equal(t, []string{"acme"}, tenants("/v1/webhooks/dead-letters", acme))
+	assert.Equal(t, http.StatusNotFound, do("DELETE", "/admin/api-keys?id="+globexKey.ID, acme, "").Code)
+	assert.Equal(t, http.StatusForbidden, do("POST", "/admin/api-keys", acme, `{"tenant":"globex","scopes":["read"]}`).Code)
+	assert.Equal(t, http.StatusForbidden, do("POST", "/admin/api-keys", acme, `{"scopes":["operator"]}`).Code)
+
+	rr := do("POST", "/admin/api-keys", acme, `{"name":"dashboard","scopes":["read"]}`)
+	require.Equal(t, http.StatusCreated, rr.Code)
+	assert.Contains(t, rr.Body.String(), `"tenant":"acme"`)
+
+	// while an operator manages them all
+	assert.ElementsMatch(t, []string{"globex", "acme", "acme"}, tenants("/admin/api-keys", "boot"))
+	assert.ElementsMatch(t, []string{"acme", "globex", ""}, tenants("/v1/webhooks/dead-letters", "boot"))
+	assert.Equal(t, http.StatusOK, do("DELETE", "/admin/api-keys?id="+globexKey.ID, "boot", "").Code)
+}
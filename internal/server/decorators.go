@@ -1,8 +1,19 @@
 package server
 
 import (
+	"crypto/tls"
+
+	"github.com/jmsilvadev/tx-parser/internal/handlers"
+	"github.com/jmsilvadev/tx-parser/pkg/apikey"
+	"github.com/jmsilvadev/tx-parser/pkg/events"
+	"github.com/jmsilvadev/tx-parser/pkg/health"
+	"github.com/jmsilvadev/tx-parser/pkg/jsonrpc"
 	"github.com/jmsilvadev/tx-parser/pkg/logger"
+	"github.com/jmsilvadev/tx-parser/pkg/metrics"
 	"github.com/jmsilvadev/tx-parser/pkg/parser"
+	"github.com/jmsilvadev/tx-parser/pkg/publisher"
+	"github.com/jmsilvadev/tx-parser/pkg/tracing"
+	"github.com/jmsilvadev/tx-parser/pkg/webhook"
 )
 
 func WithPort(v string) ServerOption {
@@ -11,6 +22,14 @@ func WithPort(v string) ServerOption {
 	}
 }
 
+// WithGRPCPort serves the gRPC API on its own port, an empty port
+// disables it.
+func WithGRPCPort(v string) ServerOption {
+	return func(s *Server) {
+		s.grpcPort = v
+	}
+}
+
 func WithEnvironment(v string) ServerOption {
 	return func(s *Server) {
 		s.environment = v
@@ -28,3 +47,135 @@ func WithLogger(v logger.Logger) ServerOption {
 		s.logger = v
 	}
 }
+
+func WithJsonRpc(v jsonrpc.JsonRpcClient) ServerOption {
+	return func(s *Server) {
+		s.jsonrpc = v
+	}
+}
+
+func WithWebhooks(v *webhook.Dispatcher) ServerOption {
+	return func(s *Server) {
+		s.webhooks = v
+	}
+}
+
+func WithBus(v *events.Bus) ServerOption {
+	return func(s *Server) {
+		s.bus = v
+	}
+}
+
+func WithPublisher(v *publisher.Forwarder) ServerOption {
+	return func(s *Server) {
+		s.publisher = v
+	}
+}
+
+// WithMetrics serves the metrics on /metrics and records the latency of
+// the HTTP requests.
+func WithMetrics(v *metrics.Metrics) ServerOption {
+	return func(s *Server) {
+		s.metrics = v
+	}
+}
+
+// WithHealth backs /readyz with the checker, fed by the bus events.
+func WithHealth(v *health.Checker) ServerOption {
+	return func(s *Server) {
+		s.health = v
+	}
+}
+
+// WithTracing traces the HTTP requests, and flushes the spans on shutdown.
+func WithTracing(v *tracing.Provider) ServerOption {
+	return func(s *Server) {
+		s.tracing = v
+	}
+}
+
+// WithLogLevel lets /admin/log-level read and change the level of the
+// logger while the server runs.
+func WithLogLevel(v logger.Level) ServerOption {
+	return func(s *Server) {
+		s.logLevel = &v
+	}
+}
+
+// WithCORS lets browsers of other origins call the API.
+func WithCORS(v handlers.CORSConfig) ServerOption {
+	return func(s *Server) {
+		s.cors = &v
+	}
+}
+
+// WithCompression encodes the responses with br or gzip when the clients
+// accept them.
+func WithCompression(v bool) ServerOption {
+	return func(s *Server) {
+		s.compression = v
+	}
+}
+
+// WithAccessLog logs every request served.
+func WithAccessLog(v bool) ServerOption {
+	return func(s *Server) {
+		s.accessLog = v
+	}
+}
+
+// WithMiddleware adds middlewares after the ones of the server, so they
+// run recovered and see the request id, in the order given.
+func WithMiddleware(v ...handlers.Middleware) ServerOption {
+	return func(s *Server) {
+		s.middlewares = append(s.middlewares, v...)
+	}
+}
+
+// WithTimeouts bounds the HTTP connections, the defaults being used when
+// not given.
+func WithTimeouts(v Timeouts) ServerOption {
+	return func(s *Server) {
+		s.timeouts = v
+	}
+}
+
+// WithMaxHeaderBytes bounds the size of the request headers.
+func WithMaxHeaderBytes(v int) ServerOption {
+	return func(s *Server) {
+		s.maxHeaderBytes = v
+	}
+}
+
+// WithAPIKeys requires an API key with the right scope on every route but
+// the probes, the metrics and the OpenAPI document, and serves the keys
+// on /admin/api-keys.
+func WithAPIKeys(v *apikey.Store) ServerOption {
+	return func(s *Server) {
+		s.apiKeys = v
+	}
+}
+
+// WithRateLimit limits the requests of every client, by API key or by IP
+// address, and counts them against a daily quota persisted in the parser
+// store. The probes and the metrics are not limited.
+func WithRateLimit(v handlers.RateLimitConfig) ServerOption {
+	return func(s *Server) {
+		s.rateLimit = &v
+	}
+}
+
+// WithTLS serves the HTTP and gRPC APIs over TLS, the certificates coming
+// from the config, e.g. through certs.ServerConfig.
+func WithTLS(v *tls.Config) ServerOption {
+	return func(s *Server) {
+		s.tls = v
+	}
+}
+
+// WithHTTP2 lets TLS clients negotiate HTTP/2, which they do by default.
+func WithHTTP2(v bool) ServerOption {
+	return func(s *Server) {
+		s.http2 = v
+	}
+}
@@ -1,9 +1,20 @@
 package server
 
 import (
+	"net/http"
 	"testing"
+	"time"
 
+	"github.com/jmsilvadev/tx-parser/internal/handlers"
+	"github.com/jmsilvadev/tx-parser/pkg/apikey"
+	"github.com/jmsilvadev/tx-parser/pkg/events"
+	"github.com/jmsilvadev/tx-parser/pkg/health"
+	"github.com/jmsilvadev/tx-parser/pkg/jsonrpc"
 	"github.com/jmsilvadev/tx-parser/pkg/logger"
+	"github.com/jmsilvadev/tx-parser/pkg/metrics"
+	"github.com/jmsilvadev/tx-parser/pkg/publisher"
+	"github.com/jmsilvadev/tx-parser/pkg/tracing"
+	"github.com/jmsilvadev/tx-parser/pkg/webhook"
 	"github.com/stretchr/testify/assert"
 	"go.uber.org/zap/zapcore"
 )
@@ -37,3 +48,129 @@ func TestWithLogger(t *testing.T) {
 	opt(s)
 	assert.Equal(t, mockLogger, s.logger)
 }
+
+func TestWithJsonRpc(t *testing.T) {
+	s := &Server{}
+	cli := jsonrpc.NewEthereum(logger.New(zapcore.DebugLevel), "http://localhost:8545")
+	opt := WithJsonRpc(cli)
+	opt(s)
+	assert.Equal(t, cli, s.jsonrpc)
+}
+
+func TestWithWebhooks(t *testing.T) {
+	s := &Server{}
+	d := &webhook.Dispatcher{}
+	opt := WithWebhooks(d)
+	opt(s)
+	assert.Equal(t, d, s.webhooks)
+}
+
+func TestWithBus(t *testing.T) {
+	s := &Server{}
+	b := events.New()
+	opt := WithBus(b)
+	opt(s)
+	assert.Equal(t, b, s.bus)
+}
+
+func TestWithPublisher(t *testing.T) {
+	s := &Server{}
+	p := &publisher.Forwarder{}
+	opt := WithPublisher(p)
+	opt(s)
+	assert.Equal(t, p, s.publisher)
+}
+
+func TestWithGRPCPort(t *testing.T) {
+	s := &Server{}
+	opt := WithGRPCPort(":9090")
+	opt(s)
+	assert.Equal(t, ":9090", s.grpcPort)
+}
+
+func TestWithMetrics(t *testing.T) {
+	s := &Server{}
+	m := metrics.New()
+	opt := WithMetrics(m)
+	opt(s)
+	assert.Equal(t, m, s.metrics)
+}
+
+func TestWithHealth(t *testing.T) {
+	s := &Server{}
+	c := health.New(nil)
+	opt := WithHealth(c)
+	opt(s)
+	assert.Equal(t, c, s.health)
+}
+
+func TestWithTracing(t *testing.T) {
+	s := &Server{}
+	p := &tracing.Provider{}
+	opt := WithTracing(p)
+	opt(s)
+	assert.Equal(t, p, s.tracing)
+}
+
+func TestWithLogLevel(t *testing.T) {
+	s := &Server{}
+	l := logger.NewLevel(zapcore.InfoLevel)
+	opt := WithLogLevel(l)
+	opt(s)
+	assert.Equal(t, l, *s.logLevel)
+}
+
+func TestWithCORS(t *testing.T) {
+	s := &Server{}
+	c := handlers.CORSConfig{AllowedOrigins: []string{"*"}}
+	opt := WithCORS(c)
+	opt(s)
+	assert.Equal(t, c, *s.cors)
+}
+
+func TestWithCompression(t *testing.T) {
+	s := &Server{}
+	opt := WithCompression(true)
+	opt(s)
+	assert.True(t, s.compression)
+}
+
+func TestWithAccessLog(t *testing.T) {
+	s := &Server{}
+	opt := WithAccessLog(true)
+	opt(s)
+	assert.True(t, s.accessLog)
+}
+
+func TestWithMiddleware(t *testing.T) {
+	s := &Server{}
+	m := func(next http.Handler) http.Handler { return next }
+	opt := WithMiddleware(m, m)
+	opt(s)
+	assert.Len(t, s.middlewares, 2)
+}
+
+func TestWithTimeouts(t *testing.T) {
+	s := NewServer()
+	assert.Equal(t, defaultTimeouts, s.timeouts)
+	v := Timeouts{Read: time.Second, Write: 2 * time.Second}
+	opt := WithTimeouts(v)
+	opt(s)
+	assert.Equal(t, v, s.timeouts)
+}
+
+func TestWithMaxHeaderBytes(t *testing.T) {
+	s := NewServer()
+	assert.Equal(t, 1<<20, s.maxHeaderBytes)
+	opt := WithMaxHeaderBytes(4096)
+	opt(s)
+	assert.Equal(t, 4096, s.maxHeaderBytes)
+}
+
+func TestWithAPIKeys(t *testing.T) {
+	s := &Server{}
+	k := apikey.New(nil)
+	opt := WithAPIKeys(k)
+	opt(s)
+	assert.Equal(t, k, s.apiKeys)
+}
@@ -0,0 +1,181 @@
+package server
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/jmsilvadev/tx-parser/internal/handlers"
+	"github.com/jmsilvadev/tx-parser/internal/stream"
+	parserv1 "github.com/jmsilvadev/tx-parser/pkg/api/parser/v1"
+	"github.com/jmsilvadev/tx-parser/pkg/events"
+	"github.com/jmsilvadev/tx-parser/pkg/jsonrpc"
+	"github.com/jmsilvadev/tx-parser/pkg/jsonrpc/jsonrpctest"
+	"github.com/jmsilvadev/tx-parser/pkg/logger"
+	"github.com/jmsilvadev/tx-parser/pkg/parser"
+	"github.com/jmsilvadev/tx-parser/pkg/parser/memorydb"
+	"github.com/jmsilvadev/tx-parser/pkg/webhook"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap/zapcore"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+func newGRPCClient(t *testing.T, p parser.Parser, options ...handlers.HandlerOption) parserv1.ParserServiceClient {
+	lis := bufconn.Listen(1 << 20)
+	srv := grpc.NewServer()
+	parserv1.RegisterParserServiceServer(srv, handlers.New(p, options...).GRPCService())
+	go srv.Serve(lis)
+	t.Cleanup(srv.Stop)
+
+	conn, err := grpc.NewClient("passthrough:///bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return lis.DialContext(ctx) }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+	return parserv1.NewParserServiceClient(conn)
+}
+
+func TestGRPC(t *testing.T) {
+	l := logger.New(zapcore.DebugLevel)
+	db := memorydb.New(jsonrpc.NewEthereum(l, "http://localhost:8545"), l)
+	cli := newGRPCClient(t, db)
+	ctx := context.Background()
+
+	block, err := cli.GetCurrentBlock(ctx, &parserv1.GetCurrentBlockRequest{})
+	require.NoError(t, err)
+	assert.Equal(t, int64(0), block.BlockNumber)
+
+	sub, err := cli.Subscribe(ctx, &parserv1.SubscribeRequest{Address: "0xaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa"})
+	require.NoError(t, err)
+	assert.True(t, sub.Subscribed)
+
+	_, err = cli.Subscribe(ctx, &parserv1.SubscribeRequest{Address: "0xAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAA"})
+	assert.Equal(t, codes.AlreadyExists, status.Code(err))
+	_, err = cli.Subscribe(ctx, &parserv1.SubscribeRequest{Address: "nope"})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+	_, err = cli.Subscribe(ctx, &parserv1.SubscribeRequest{Address: "0xaaa"})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+	_, err = cli.Subscribe(ctx, &parserv1.SubscribeRequest{Address: "0xbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbb", Webhook: "http://example.com"})
+	assert.Equal(t, codes.FailedPrecondition, status.Code(err))
+
+	unsub, err := cli.Unsubscribe(ctx, &parserv1.UnsubscribeRequest{Address: "0xaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa"})
+	require.NoError(t, err)
+	assert.True(t, unsub.Unsubscribed)
+	_, err = cli.Unsubscribe(ctx, &parserv1.UnsubscribeRequest{Address: "0xaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa"})
+	assert.Equal(t, codes.NotFound, status.Code(err))
+
+	_, err = cli.GetTransactions(ctx, &parserv1.GetTransactionsRequest{})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+	txs, err := cli.GetTransactions(ctx, &parserv1.GetTransactionsRequest{Address: "0xaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa"})
+	require.NoError(t, err)
+	assert.Empty(t, txs.Transactions)
+	assert.Empty(t, txs.NextPageToken)
+}
+
+func TestGRPCUnsubscribeWebhook(t *testing.T) {
+	l := logger.New(zapcore.DebugLevel)
+	db := memorydb.New(jsonrpc.NewEthereum(l, "http://localhost:8545"), l)
+	d := webhook.New(db, l, []byte("secret"))
+	cli := newGRPCClient(t, db, handlers.WithWebhooks(d))
+	ctx := context.Background()
+
+	_, err := cli.Subscribe(ctx, &parserv1.SubscribeRequest{Address: "0xaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa", Webhook: "https://203.0.113.10/hook"})
+	require.NoError(t, err)
+	_, ok := d.Webhook(ctx, "0xaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa")
+	assert.True(t, ok)
+
+	_, err = cli.Unsubscribe(ctx, &parserv1.UnsubscribeRequest{Address: "0xaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa"})
+	require.NoError(t, err)
+	_, ok = d.Webhook(ctx, "0xaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa")
+	assert.False(t, ok)
+
+	// a refused webhook does not leave a new subscription behind
+	_, err = cli.Subscribe(ctx, &parserv1.SubscribeRequest{Address: "0xbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbb", Webhook: "not a url"})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+	assert.False(t, db.Subscribed(ctx, "0xbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbb"))
+}
+
+type historyParser struct {
+	MockParser
+	transactions []parser.Transaction
+}
+
+func (p *historyParser) GetTransactions(ctx context.Context, address string) []parser.Transaction {
+	return p.transactions
+}
+
+func TestGRPCPaging(t *testing.T) {
+	p := &historyParser{transactions: []parser.Transaction{
+		{Hash: "0x1", BlockNumber: 1},
+		{Hash: "0x2", BlockNumber: 2},
+		{Hash: "0x3", BlockNumber: 3},
+	}}
+	cli := newGRPCClient(t, p)
+	ctx := context.Background()
+
+	page, err := cli.GetTransactions(ctx, &parserv1.GetTransactionsRequest{Address: "0xaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa", PageSize: 2})
+	require.NoError(t, err)
+	assert.Len(t, page.Transactions, 2)
+	assert.Equal(t, "0x1", page.Transactions[0].Hash)
+	require.NotEmpty(t, page.NextPageToken)
+
+	page, err = cli.GetTransactions(ctx, &parserv1.GetTransactionsRequest{Address: "0xaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa", PageSize: 2, PageToken: page.NextPageToken})
+	require.NoError(t, err)
+	assert.Len(t, page.Transactions, 1)
+	assert.Equal(t, int64(3), page.Transactions[0].BlockNumber)
+	assert.Empty(t, page.NextPageToken)
+
+	page, err = cli.GetTransactions(ctx, &parserv1.GetTransactionsRequest{Address: "0xaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa"})
+	require.NoError(t, err)
+	assert.Len(t, page.Transactions, 3)
+
+	_, err = cli.GetTransactions(ctx, &parserv1.GetTransactionsRequest{Address: "0xaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa", PageToken: "x"})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+	_, err = cli.GetTransactions(ctx, &parserv1.GetTransactionsRequest{Address: "0xaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa", PageSize: -1})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+}
+
+func TestGRPCWatchTransactions(t *testing.T) {
+	l := logger.New(zapcore.DebugLevel)
+	node := jsonrpctest.NewServer(jsonrpctest.DefaultChain())
+	defer node.Close()
+
+	address := "0xa1a1a1a1a1a1a1a1a1a1a1a1a1a1a1a1a1a1a1a1"
+	bus := events.New()
+	db := memorydb.New(jsonrpc.NewEthereum(l, node.URL), l, memorydb.WithBus(bus))
+	db.Subscribe(context.Background(), address)
+	hub := stream.NewHub(0)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go events.Consume(ctx, bus.Subscribe(64, events.Block), hub.Handle)
+
+	cli := newGRPCClient(t, db, handlers.WithHub(hub))
+	watch, err := cli.WatchTransactions(ctx, &parserv1.WatchTransactionsRequest{Addresses: []string{address}})
+	require.NoError(t, err)
+	require.Eventually(t, func() bool { return hub.Subscribers() == 1 }, time.Second, 10*time.Millisecond)
+
+	go db.UpdateBlockNumber(ctx)
+	first, err := watch.Recv()
+	require.NoError(t, err)
+	assert.Equal(t, int64(104), first.Transaction.BlockNumber)
+
+	// resuming from an older cursor replays the history
+	resumed, err := cli.WatchTransactions(ctx, &parserv1.WatchTransactionsRequest{Addresses: []string{address}, Cursor: "100:0xunknown"})
+	require.NoError(t, err)
+	replayed, err := resumed.Recv()
+	require.NoError(t, err)
+	assert.Equal(t, first.Cursor, replayed.Cursor)
+
+	invalid, err := cli.WatchTransactions(ctx, &parserv1.WatchTransactionsRequest{})
+	require.NoError(t, err)
+	_, err = invalid.Recv()
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+}
@@ -0,0 +1,79 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jmsilvadev/tx-parser/pkg/events"
+	"github.com/jmsilvadev/tx-parser/pkg/jsonrpc"
+	"github.com/jmsilvadev/tx-parser/pkg/jsonrpc/jsonrpctest"
+	"github.com/jmsilvadev/tx-parser/pkg/logger"
+	"github.com/jmsilvadev/tx-parser/pkg/parser/memorydb"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap/zapcore"
+)
+
+func TestHandler(t *testing.T) {
+	l := logger.New(zapcore.DebugLevel)
+	a := NewServer(WithLogger(l), WithParser(&MockParser{}))
+	b := NewServer(WithLogger(l), WithParser(&MockParser{}), WithLogLevel(logger.NewLevel(zapcore.InfoLevel)))
+
+	tests := []struct {
+		server *Server
+		method string
+		target string
+		code   int
+	}{
+		{a, "GET", "/v1/get-current-block", http.StatusOK},
+		{a, "POST", "/v1/get-current-block", http.StatusMethodNotAllowed},
+		{a, "POST", "/v1/subscribe?address=0x1111111111111111111111111111111111111111", http.StatusOK},
+		{a, "GET", "/v1/subscribe?address=0x1111111111111111111111111111111111111111", http.StatusMethodNotAllowed},
+		{a, "GET", "/nowhere", http.StatusNotFound},
+		// each server has its own routes
+		{a, "GET", "/admin/log-level", http.StatusNotFound},
+		{b, "GET", "/admin/log-level", http.StatusOK},
+	}
+	for _, tt := range tests {
+		rr := httptest.NewRecorder()
+		tt.server.Handler().ServeHTTP(rr, httptest.NewRequest(tt.method, tt.target, nil))
+		assert.Equal(t, tt.code, rr.Code, tt.method+" "+tt.target)
+	}
+}
+
+func TestStreamWriteTimeout(t *testing.T) {
+	l := logger.New(zapcore.DebugLevel)
+	node := jsonrpctest.NewServer(jsonrpctest.DefaultChain())
+	defer node.Close()
+
+	address := "0xa1a1a1a1a1a1a1a1a1a1a1a1a1a1a1a1a1a1a1a1"
+	bus := events.New()
+	db := memorydb.New(jsonrpc.NewEthereum(l, node.URL), l, memorydb.WithBus(bus))
+	db.Subscribe(context.Background(), address)
+	s := NewServer(WithLogger(l), WithParser(db), WithBus(bus))
+
+	srv := httptest.NewUnstartedServer(s.Handler())
+	srv.Config.WriteTimeout = 100 * time.Millisecond
+	srv.Start()
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL + "/v1/stream?address=" + address)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	assert.Equal(t, "text/event-stream", resp.Header.Get("Content-Type"))
+
+	// the first event comes long after the write timeout
+	time.Sleep(300 * time.Millisecond)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	s.Run(ctx)
+
+	id, data := readEvent(t, bufio.NewReader(resp.Body))
+	assert.True(t, strings.HasPrefix(id, "104:0x"))
+	assert.Contains(t, data, address)
+}
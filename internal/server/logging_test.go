@@ -0,0 +1,81 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/jmsilvadev/tx-parser/internal/handlers"
+	"github.com/jmsilvadev/tx-parser/pkg/logger"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap/zapcore"
+)
+
+type entries struct {
+	logger.Logger
+	fields []logger.Field
+}
+
+func (e *entries) Info(msg string, fields ...logger.Field) {
+	e.fields = fields
+}
+
+func TestRequestID(t *testing.T) {
+	l := &entries{}
+	h := handlers.RequestID(l, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		logger.FromContext(r.Context(), nil).Info("handled")
+	}))
+
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, httptest.NewRequest("GET", "/livez", nil))
+	id := rr.Header().Get("X-Request-ID")
+	assert.Len(t, id, 32)
+	assert.Equal(t, []logger.Field{logger.RequestID(id)}, l.fields)
+
+	// ids from the clients are kept when sane
+	req := httptest.NewRequest("GET", "/livez", nil)
+	req.Header.Set("X-Request-ID", "client-id.1")
+	rr = httptest.NewRecorder()
+	h.ServeHTTP(rr, req)
+	assert.Equal(t, "client-id.1", rr.Header().Get("X-Request-ID"))
+	assert.Equal(t, []logger.Field{logger.RequestID("client-id.1")}, l.fields)
+
+	for _, v := range []string{"has space", "new\nline", strings.Repeat("a", 129)} {
+		req := httptest.NewRequest("GET", "/livez", nil)
+		req.Header.Set("X-Request-ID", v)
+		rr = httptest.NewRecorder()
+		h.ServeHTTP(rr, req)
+		assert.NotEqual(t, v, rr.Header().Get("X-Request-ID"))
+		assert.Len(t, rr.Header().Get("X-Request-ID"), 32)
+	}
+}
+
+func TestLogLevelHandler(t *testing.T) {
+	level := logger.NewLevel(zapcore.InfoLevel)
+	h := handlers.LogLevelHandler(level)
+
+	var resp struct {
+		Status string            `json:"status"`
+		Data   map[string]string `json:"data"`
+	}
+	rr := httptest.NewRecorder()
+	h(rr, httptest.NewRequest("GET", "/admin/log-level", nil))
+	require.Equal(t, http.StatusOK, rr.Code)
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &resp))
+	assert.Equal(t, "info", resp.Data["level"])
+
+	rr = httptest.NewRecorder()
+	h(rr, httptest.NewRequest("PUT", "/admin/log-level", strings.NewReader(`{"level":"debug"}`)))
+	require.Equal(t, http.StatusOK, rr.Code)
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &resp))
+	assert.Equal(t, "debug", resp.Data["level"])
+	assert.Equal(t, zapcore.DebugLevel, level.Level())
+
+	rr = httptest.NewRecorder()
+	h(rr, httptest.NewRequest("PUT", "/admin/log-level", strings.NewReader(`{"level":"loud"}`)))
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+	assert.Equal(t, zapcore.DebugLevel, level.Level())
+}
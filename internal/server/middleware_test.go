@@ -0,0 +1,260 @@
+package server
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/andybalholm/brotli"
+	"github.com/jmsilvadev/tx-parser/internal/handlers"
+	"github.com/jmsilvadev/tx-parser/pkg/logger"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func TestChain(t *testing.T) {
+	var order []string
+	mark := func(name string) handlers.Middleware {
+		return func(next http.Handler) http.Handler {
+			return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				order = append(order, name)
+				next.ServeHTTP(w, r)
+			})
+		}
+	}
+	h := handlers.Chain(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		order = append(order, "handler")
+	}), mark("a"), mark("b"))
+
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+	assert.Equal(t, []string{"a", "b", "handler"}, order)
+}
+
+type errorEntries struct {
+	logger.Logger
+	msg string
+}
+
+func (e *errorEntries) Error(msg string, fields ...logger.Field) {
+	e.msg = msg
+}
+
+func TestRecover(t *testing.T) {
+	l := &errorEntries{}
+	h := handlers.Recover(l, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		panic("boom")
+	}))
+
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, httptest.NewRequest("GET", "/", nil))
+	assert.Equal(t, http.StatusInternalServerError, rr.Code)
+	assert.Equal(t, "handler panicked", l.msg)
+
+	var resp handlers.Response
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &resp))
+	assert.Equal(t, handlers.Response{Status: "error", Message: "internal server error"}, resp)
+
+	// too late to change the answer
+	h = handlers.Recover(l, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusAccepted)
+		panic("boom")
+	}))
+	rr = httptest.NewRecorder()
+	h.ServeHTTP(rr, httptest.NewRequest("GET", "/", nil))
+	assert.Equal(t, http.StatusAccepted, rr.Code)
+	assert.Empty(t, rr.Body.String())
+
+	h = handlers.Recover(l, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		panic(http.ErrAbortHandler)
+	}))
+	assert.PanicsWithValue(t, http.ErrAbortHandler, func() {
+		h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+	})
+}
+
+type infoEntries struct {
+	logger.Logger
+	msg    string
+	fields []logger.Field
+}
+
+func (e *infoEntries) Info(msg string, fields ...logger.Field) {
+	e.msg, e.fields = msg, fields
+}
+
+func TestAccessLog(t *testing.T) {
+	l := &infoEntries{}
+	h := handlers.AccessLog(l, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte("created"))
+	}))
+
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("POST", "/v1/subscribe", nil))
+	assert.Equal(t, "request", l.msg)
+	assert.Contains(t, l.fields, zap.String("method", "POST"))
+	assert.Contains(t, l.fields, zap.String("path", "/v1/subscribe"))
+	assert.Contains(t, l.fields, zap.Int("status", http.StatusCreated))
+	assert.Contains(t, l.fields, zap.Int("bytes", 7))
+}
+
+func TestCORS(t *testing.T) {
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusTeapot)
+	})
+	h := handlers.CORS(handlers.CORSConfig{AllowedOrigins: []string{"https://app.example"}}, next)
+
+	req := httptest.NewRequest("GET", "/v1/get-current-block", nil)
+	req.Header.Set("Origin", "https://app.example")
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusTeapot, rr.Code)
+	assert.Equal(t, "https://app.example", rr.Header().Get("Access-Control-Allow-Origin"))
+	assert.Equal(t, "X-Request-ID, Retry-After", rr.Header().Get("Access-Control-Expose-Headers"))
+
+	req = httptest.NewRequest("OPTIONS", "/v1/subscribe", nil)
+	req.Header.Set("Origin", "https://app.example")
+	req.Header.Set("Access-Control-Request-Method", "POST")
+	rr = httptest.NewRecorder()
+	h.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusNoContent, rr.Code)
+	assert.Equal(t, "GET, POST, PUT, DELETE", rr.Header().Get("Access-Control-Allow-Methods"))
+	assert.Equal(t, "Content-Type, Authorization, X-Request-ID, X-API-Key", rr.Header().Get("Access-Control-Allow-Headers"))
+	assert.Equal(t, "600", rr.Header().Get("Access-Control-Max-Age"))
+
+	req = httptest.NewRequest("GET", "/v1/get-current-block", nil)
+	req.Header.Set("Origin", "https://other.example")
+	rr = httptest.NewRecorder()
+	h.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusTeapot, rr.Code)
+	assert.Empty(t, rr.Header().Get("Access-Control-Allow-Origin"))
+
+	h = handlers.CORS(handlers.CORSConfig{AllowedOrigins: []string{"*"}}, next)
+	req = httptest.NewRequest("GET", "/v1/get-current-block", nil)
+	req.Header.Set("Origin", "https://other.example")
+	rr = httptest.NewRecorder()
+	h.ServeHTTP(rr, req)
+	assert.Equal(t, "*", rr.Header().Get("Access-Control-Allow-Origin"))
+
+	h = handlers.CORS(handlers.CORSConfig{AllowedOrigins: []string{"*"}, AllowCredentials: true}, next)
+	rr = httptest.NewRecorder()
+	h.ServeHTTP(rr, req)
+	assert.Equal(t, "https://other.example", rr.Header().Get("Access-Control-Allow-Origin"))
+	assert.Equal(t, "true", rr.Header().Get("Access-Control-Allow-Credentials"))
+}
+
+func TestCompress(t *testing.T) {
+	body := strings.Repeat(`{"hash":"0xabc"}`, 100)
+	h := handlers.Compress(0, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(r.URL.Query().Get("prefix")))
+		w.Write([]byte(body))
+	}))
+
+	decoders := map[string]func(io.Reader) io.Reader{
+		"gzip": func(r io.Reader) io.Reader {
+			zr, err := gzip.NewReader(r)
+			require.NoError(t, err)
+			return zr
+		},
+		"br": func(r io.Reader) io.Reader {
+			return brotli.NewReader(r)
+		},
+	}
+	tests := []struct {
+		accept   string
+		encoding string
+	}{
+		{"gzip", "gzip"},
+		{"gzip, br", "br"},
+		{"br;q=0.5, gzip", "gzip"},
+		{"br;q=0, gzip;q=0", ""},
+		{"identity", ""},
+		{"", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.accept, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/", nil)
+			req.Header.Set("Accept-Encoding", tt.accept)
+			rr := httptest.NewRecorder()
+			h.ServeHTTP(rr, req)
+
+			assert.Equal(t, http.StatusOK, rr.Code)
+			assert.Equal(t, "Accept-Encoding", rr.Header().Get("Vary"))
+			assert.Equal(t, tt.encoding, rr.Header().Get("Content-Encoding"))
+			var r io.Reader = rr.Body
+			if tt.encoding != "" {
+				r = decoders[tt.encoding](r)
+			}
+			got, err := io.ReadAll(r)
+			require.NoError(t, err)
+			assert.Equal(t, body, string(got))
+		})
+	}
+
+	// small bodies and other types are sent as they are
+	h = handlers.Compress(0, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", r.URL.Query().Get("type"))
+		w.WriteHeader(http.StatusCreated)
+		w.Write(bytes.Repeat([]byte("a"), 2048)[:len(r.URL.Query().Get("size"))*1024])
+	}))
+	for _, target := range []string{"/?type=application/json&size=", "/?type=image/png&size=xx"} {
+		req := httptest.NewRequest("GET", target, nil)
+		req.Header.Set("Accept-Encoding", "gzip")
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, req)
+		assert.Equal(t, http.StatusCreated, rr.Code)
+		assert.Empty(t, rr.Header().Get("Content-Encoding"))
+	}
+}
+
+func TestCompressStream(t *testing.T) {
+	h := handlers.Compress(0, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Write([]byte("data: {}\n\n"))
+		w.(http.Flusher).Flush()
+	}))
+
+	req := httptest.NewRequest("GET", "/v1/stream", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, req)
+	assert.True(t, rr.Flushed)
+	assert.Empty(t, rr.Header().Get("Content-Encoding"))
+	assert.Equal(t, "data: {}\n\n", rr.Body.String())
+}
+
+func TestServerHandler(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/v1/get-current-block", func(w http.ResponseWriter, r *http.Request) {
+		panic("boom")
+	})
+	s := NewServer(
+		WithLogger(zap.NewNop()),
+		WithCompression(true),
+		WithAccessLog(true),
+		WithCORS(handlers.CORSConfig{AllowedOrigins: []string{"*"}}),
+	)
+	srv := httptest.NewServer(s.wrap(mux))
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL + "/v1/get-current-block")
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusInternalServerError, resp.StatusCode)
+	assert.NotEmpty(t, resp.Header.Get("X-Request-ID"))
+
+	// preflight requests are answered before the validation
+	req, _ := http.NewRequest("OPTIONS", srv.URL+"/v1/subscribe", nil)
+	req.Header.Set("Origin", "https://app.example")
+	req.Header.Set("Access-Control-Request-Method", "POST")
+	resp, err = http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusNoContent, resp.StatusCode)
+}
@@ -0,0 +1,104 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/jmsilvadev/tx-parser/internal/handlers"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestOpenAPIHandler(t *testing.T) {
+	rr := httptest.NewRecorder()
+	handlers.OpenAPIHandler(rr, httptest.NewRequest("GET", "/openapi.json", nil))
+	require.Equal(t, http.StatusOK, rr.Code)
+
+	var doc struct {
+		OpenAPI string                     `json:"openapi"`
+		Paths   map[string]json.RawMessage `json:"paths"`
+	}
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &doc))
+	assert.Equal(t, "3.0.3", doc.OpenAPI)
+	for _, path := range []string{
+		"/livez",
+		"/readyz",
+		"/v1/get-current-block",
+		"/v1/subscribe",
+		"/v1/get-transactions",
+		"/v1/stream",
+		"/v1/ws",
+		"/v1/webhooks/dead-letters",
+		"/v1/rpc-usage",
+		"/rpc",
+		"/metrics",
+		"/admin/log-level",
+		"/admin/api-keys",
+		"/openapi.json",
+	} {
+		assert.Contains(t, doc.Paths, path)
+	}
+}
+
+func TestValidateRequests(t *testing.T) {
+	var body string
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data := new(strings.Builder)
+		if r.Body != nil {
+			buf := make([]byte, 1024)
+			n, _ := r.Body.Read(buf)
+			data.Write(buf[:n])
+		}
+		body = data.String()
+		w.WriteHeader(http.StatusTeapot)
+	})
+	h := handlers.ValidateRequests(next)
+
+	tests := []struct {
+		name    string
+		method  string
+		target  string
+		body    string
+		code    int
+		message string
+	}{
+		{"valid get", "GET", "/v1/get-transactions?address=0xabcabcabcabcabcabcabcabcabcabcabcabcabca", "", http.StatusTeapot, ""},
+		{"wrong method", "POST", "/v1/get-transactions?address=0xabcabcabcabcabcabcabcabcabcabcabcabcabca", "", http.StatusMethodNotAllowed, "method not allowed"},
+		{"missing param", "GET", "/v1/get-transactions", "", http.StatusBadRequest, "address is required"},
+		{"invalid param", "GET", "/v1/get-transactions?address=abc", "", http.StatusBadRequest, "invalid address"},
+		{"short address", "GET", "/v1/get-transactions?address=0x123", "", http.StatusBadRequest, "invalid address"},
+		{"long address", "GET", "/v1/get-transactions?address=0xabcabcabcabcabcabcabcabcabcabcabcabcabcab", "", http.StatusBadRequest, "invalid address"},
+		{"invalid array param", "GET", "/v1/stream?address=0x1111111111111111111111111111111111111111&address=nope", "", http.StatusBadRequest, "invalid address"},
+		{"valid body", "POST", "/v1/subscribe", `{"address":"0xabcabcabcabcabcabcabcabcabcabcabcabcabca"}`, http.StatusTeapot, ""},
+		{"optional body", "POST", "/v1/subscribe?address=0xabcabcabcabcabcabcabcabcabcabcabcabcabca", "", http.StatusTeapot, ""},
+		{"malformed body", "POST", "/v1/subscribe", `{"address"`, http.StatusBadRequest, "invalid request body"},
+		{"wrong body type", "POST", "/v1/subscribe", `[]`, http.StatusBadRequest, "must be an object"},
+		{"wrong field type", "POST", "/v1/subscribe", `{"address":1}`, http.StatusBadRequest, "address must be a string"},
+		{"unknown field", "POST", "/v1/subscribe", `{"adress":"0x1111111111111111111111111111111111111111"}`, http.StatusBadRequest, "unknown field adress"},
+		{"invalid log level", "PUT", "/admin/log-level", `{"level":"loud"}`, http.StatusBadRequest, "level must be one of"},
+		{"unvalidated body", "POST", "/rpc", `[1]`, http.StatusTeapot, ""},
+		{"unknown path", "DELETE", "/nowhere", "", http.StatusTeapot, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			body = ""
+			rr := httptest.NewRecorder()
+			h.ServeHTTP(rr, httptest.NewRequest(tt.method, tt.target, strings.NewReader(tt.body)))
+			assert.Equal(t, tt.code, rr.Code)
+			if tt.message != "" {
+				assert.Contains(t, rr.Body.String(), tt.message)
+			}
+			if tt.code == http.StatusTeapot {
+				// the handler still gets the body
+				assert.Equal(t, tt.body, body)
+			}
+		})
+	}
+
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, httptest.NewRequest("PUT", "/v1/subscribe", nil))
+	assert.Equal(t, "POST", rr.Header().Get("Allow"))
+}
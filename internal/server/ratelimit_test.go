@@ -0,0 +1,132 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	"github.com/jmsilvadev/tx-parser/internal/handlers"
+	"github.com/jmsilvadev/tx-parser/pkg/apikey"
+	"github.com/jmsilvadev/tx-parser/pkg/jsonrpc"
+	"github.com/jmsilvadev/tx-parser/pkg/logger"
+	"github.com/jmsilvadev/tx-parser/pkg/metrics"
+	"github.com/jmsilvadev/tx-parser/pkg/parser/memorydb"
+	"github.com/jmsilvadev/tx-parser/pkg/ratelimit"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap/zapcore"
+)
+
+func TestRateLimit(t *testing.T) {
+	l := logger.New(zapcore.DebugLevel)
+	db := memorydb.New(jsonrpc.NewEthereum(l, "http://localhost:8545"), l)
+	h := NewServer(WithLogger(l), WithParser(db), WithRateLimit(handlers.RateLimitConfig{
+		Default: ratelimit.Limit{Rate: 0.001, Burst: 2},
+		Routes: map[string]ratelimit.Limit{
+			"/v1/get-transactions": {Rate: 0.001, Burst: 1},
+			"/openapi.json":        {},
+		},
+	})).Handler()
+
+	do := func(target, ip string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", target, nil)
+		req.RemoteAddr = ip + ":1234"
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, req)
+		return rr
+	}
+
+	assert.Equal(t, http.StatusOK, do("/v1/get-current-block", "10.0.0.1").Code)
+	assert.Equal(t, http.StatusOK, do("/v1/get-current-block", "10.0.0.1").Code)
+	rr := do("/v1/get-current-block", "10.0.0.1")
+	require.Equal(t, http.StatusTooManyRequests, rr.Code)
+	retryAfter, err := strconv.Atoi(rr.Header().Get("Retry-After"))
+	require.NoError(t, err)
+	assert.Greater(t, retryAfter, 0)
+	var body handlers.Response
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &body))
+	assert.Equal(t, handlers.Response{Status: "error", Message: "rate limit exceeded"}, body)
+
+	// clients and routes have buckets of their own
+	assert.Equal(t, http.StatusOK, do("/v1/get-current-block", "10.0.0.2").Code)
+	assert.Equal(t, http.StatusOK, do("/v1/get-transactions?address=0x1111111111111111111111111111111111111111", "10.0.0.1").Code)
+	assert.Equal(t, http.StatusTooManyRequests, do("/v1/get-transactions?address=0x1111111111111111111111111111111111111111", "10.0.0.1").Code)
+
+	// the probes and the exempted routes are not limited
+	for i := 0; i < 5; i++ {
+		assert.Equal(t, http.StatusOK, do("/livez", "10.0.0.1").Code)
+		assert.Equal(t, http.StatusOK, do("/openapi.json", "10.0.0.1").Code)
+	}
+}
+
+func TestRateLimitByKey(t *testing.T) {
+	l := logger.New(zapcore.DebugLevel)
+	db := memorydb.New(jsonrpc.NewEthereum(l, "http://localhost:8545"), l)
+	keys := apikey.New(db)
+	_, a, err := keys.Create("a", "", []string{apikey.ScopeRead})
+	require.NoError(t, err)
+	_, b, err := keys.Create("b", "", []string{apikey.ScopeRead})
+	require.NoError(t, err)
+	h := NewServer(WithLogger(l), WithParser(db), WithAPIKeys(keys), WithRateLimit(handlers.RateLimitConfig{
+		DailyQuota: 2,
+	})).Handler()
+
+	do := func(secret string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", "/v1/get-current-block", nil)
+		req.Header.Set("X-API-Key", secret)
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, req)
+		return rr
+	}
+
+	// the keys share the address but not the quota
+	assert.Equal(t, http.StatusOK, do(a).Code)
+	assert.Equal(t, http.StatusOK, do(a).Code)
+	rr := do(a)
+	assert.Equal(t, http.StatusTooManyRequests, rr.Code)
+	assert.Contains(t, rr.Body.String(), "daily quota exceeded")
+	assert.NotEmpty(t, rr.Header().Get("Retry-After"))
+	assert.Equal(t, http.StatusOK, do(b).Code)
+}
+
+func TestAuthFailures(t *testing.T) {
+	l := logger.New(zapcore.DebugLevel)
+	db := memorydb.New(jsonrpc.NewEthereum(l, "http://localhost:8545"), l)
+	keys := apikey.New(db)
+	_, valid, err := keys.Create("valid", "", []string{apikey.ScopeRead})
+	require.NoError(t, err)
+	m := metrics.New()
+	h := NewServer(WithLogger(l), WithParser(db), WithAPIKeys(keys), WithMetrics(m)).Handler()
+
+	do := func(secret, ip string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", "/v1/get-current-block", nil)
+		req.Header.Set("X-API-Key", secret)
+		req.RemoteAddr = ip + ":1234"
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, req)
+		return rr
+	}
+
+	// guessing keys is cut short without any limit configured
+	codes := map[int]int{}
+	for i := 0; i < 20; i++ {
+		codes[do("txp_guess_"+strconv.Itoa(i), "10.0.0.1").Code]++
+	}
+	assert.Equal(t, 10, codes[http.StatusUnauthorized])
+	assert.Equal(t, 10, codes[http.StatusTooManyRequests])
+	rr := do(valid, "10.0.0.1")
+	assert.Equal(t, http.StatusTooManyRequests, rr.Code)
+	assert.Contains(t, rr.Body.String(), "too many failed authentications")
+	assert.NotEmpty(t, rr.Header().Get("Retry-After"))
+
+	// other addresses are not affected
+	assert.Equal(t, http.StatusOK, do(valid, "10.0.0.2").Code)
+
+	// and the rejected requests are measured
+	rr = httptest.NewRecorder()
+	m.Handler().ServeHTTP(rr, httptest.NewRequest("GET", "/metrics", nil))
+	assert.Contains(t, rr.Body.String(), `txparser_http_request_duration_seconds_count{method="GET",route="/v1/get-current-block",status="401"} 10`)
+	assert.Contains(t, rr.Body.String(), `txparser_http_request_duration_seconds_count{method="GET",route="/v1/get-current-block",status="429"} 11`)
+}
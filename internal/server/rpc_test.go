@@ -0,0 +1,117 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/jmsilvadev/tx-parser/internal/handlers"
+	"github.com/jmsilvadev/tx-parser/pkg/jsonrpc"
+	"github.com/jmsilvadev/tx-parser/pkg/logger"
+	"github.com/jmsilvadev/tx-parser/pkg/parser/memorydb"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap/zapcore"
+)
+
+func rpcRequest(t *testing.T, h http.HandlerFunc, body string) *httptest.ResponseRecorder {
+	rr := httptest.NewRecorder()
+	h(rr, httptest.NewRequest("POST", "/rpc", strings.NewReader(body)))
+	return rr
+}
+
+func TestRPC(t *testing.T) {
+	l := logger.New(zapcore.DebugLevel)
+	db := memorydb.New(jsonrpc.NewEthereum(l, "http://localhost:8545"), l)
+	h := handlers.New(db).RPC
+
+	var resp jsonrpc.Response
+	rr := rpcRequest(t, h, `{"jsonrpc":"2.0","method":"parser_getCurrentBlock","id":1}`)
+	require.Equal(t, http.StatusOK, rr.Code)
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &resp))
+	assert.Nil(t, resp.Error)
+	assert.Equal(t, "0", string(resp.Result))
+	assert.Equal(t, float64(1), resp.ID)
+
+	rr = rpcRequest(t, h, `{"jsonrpc":"2.0","method":"parser_subscribe","params":["0xAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAA"],"id":"a"}`)
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &resp))
+	assert.Equal(t, "true", string(resp.Result))
+	assert.Equal(t, "a", resp.ID)
+
+	rr = rpcRequest(t, h, `{"jsonrpc":"2.0","method":"parser_subscribe","params":{"address":"0xaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa"},"id":2}`)
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &resp))
+	assert.Equal(t, "false", string(resp.Result))
+
+	rr = rpcRequest(t, h, `{"jsonrpc":"2.0","method":"parser_getTransactions","params":["0xaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa"],"id":3}`)
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &resp))
+	assert.Equal(t, "[]", string(resp.Result))
+
+	// notifications get no response
+	rr = rpcRequest(t, h, `{"jsonrpc":"2.0","method":"parser_subscribe","params":["0xbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbb"]}`)
+	assert.Equal(t, http.StatusNoContent, rr.Code)
+	assert.False(t, db.Subscribe(context.Background(), "0xbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbb"))
+
+	rr = httptest.NewRecorder()
+	handlers.ValidateRequests(http.HandlerFunc(h)).ServeHTTP(rr, httptest.NewRequest("GET", "/rpc", nil))
+	assert.Equal(t, http.StatusMethodNotAllowed, rr.Code)
+}
+
+func TestRPCErrors(t *testing.T) {
+	l := logger.New(zapcore.DebugLevel)
+	db := memorydb.New(jsonrpc.NewEthereum(l, "http://localhost:8545"), l)
+	h := handlers.New(db).RPC
+
+	tests := []struct {
+		body string
+		code int
+	}{
+		{`{"jsonrpc":"2.0","method"`, jsonrpc.CodeParseError},
+		{`"hello"`, jsonrpc.CodeInvalidRequest},
+		{`{"method":"parser_getCurrentBlock","id":1}`, jsonrpc.CodeInvalidRequest},
+		{`{"jsonrpc":"2.0","method":"parser_getCurrentBlock","id":{}}`, jsonrpc.CodeInvalidRequest},
+		{`{"jsonrpc":"2.0","method":"eth_blockNumber","id":1}`, jsonrpc.CodeMethodNotFound},
+		{`{"jsonrpc":"2.0","method":"parser_subscribe","id":1}`, jsonrpc.CodeInvalidParams},
+		{`{"jsonrpc":"2.0","method":"parser_subscribe","params":["0x1111111111111111111111111111111111111111","0x2222222222222222222222222222222222222222"],"id":1}`, jsonrpc.CodeInvalidParams},
+		{`{"jsonrpc":"2.0","method":"parser_getTransactions","params":["nope"],"id":1}`, jsonrpc.CodeInvalidParams},
+		{`{"jsonrpc":"2.0","method":"parser_getTransactions","params":["0x123"],"id":1}`, jsonrpc.CodeInvalidParams},
+		{`[]`, jsonrpc.CodeInvalidRequest},
+		{`[1,`, jsonrpc.CodeParseError},
+	}
+	for _, tt := range tests {
+		var resp jsonrpc.Response
+		rr := rpcRequest(t, h, tt.body)
+		require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &resp), tt.body)
+		require.NotNil(t, resp.Error, tt.body)
+		assert.Equal(t, tt.code, resp.Error.Code, tt.body)
+		assert.Empty(t, resp.Result, tt.body)
+	}
+}
+
+func TestRPCBatch(t *testing.T) {
+	l := logger.New(zapcore.DebugLevel)
+	db := memorydb.New(jsonrpc.NewEthereum(l, "http://localhost:8545"), l)
+	h := handlers.New(db).RPC
+
+	rr := rpcRequest(t, h, `[
+		{"jsonrpc":"2.0","method":"parser_subscribe","params":["0xaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa"],"id":1},
+		{"jsonrpc":"2.0","method":"parser_subscribe","params":["0xbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbb"]},
+		{"jsonrpc":"2.0","method":"unknown","id":2},
+		1
+	]`)
+	require.Equal(t, http.StatusOK, rr.Code)
+
+	var resp []jsonrpc.Response
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &resp))
+	require.Len(t, resp, 3)
+	assert.Equal(t, "true", string(resp[0].Result))
+	assert.Equal(t, jsonrpc.CodeMethodNotFound, resp[1].Error.Code)
+	assert.Equal(t, jsonrpc.CodeInvalidRequest, resp[2].Error.Code)
+	assert.Nil(t, resp[2].ID)
+
+	// a batch of notifications only gets no response
+	rr = rpcRequest(t, h, `[{"jsonrpc":"2.0","method":"parser_subscribe","params":["0xcccccccccccccccccccccccccccccccccccccccc"]}]`)
+	assert.Equal(t, http.StatusNoContent, rr.Code)
+}
@@ -2,8 +2,11 @@ package server
 
 import (
 	"context"
+	"crypto/tls"
 	"fmt"
 	"log"
+	"maps"
+	"net"
 	"net/http"
 	"os"
 	"os/signal"
@@ -12,42 +15,155 @@ import (
 	"time"
 
 	"github.com/jmsilvadev/tx-parser/internal/handlers"
+	"github.com/jmsilvadev/tx-parser/internal/stream"
+	parserv1 "github.com/jmsilvadev/tx-parser/pkg/api/parser/v1"
+	"github.com/jmsilvadev/tx-parser/pkg/apikey"
 	config "github.com/jmsilvadev/tx-parser/pkg/config"
+	"github.com/jmsilvadev/tx-parser/pkg/events"
+	"github.com/jmsilvadev/tx-parser/pkg/health"
+	"github.com/jmsilvadev/tx-parser/pkg/jsonrpc"
 	"github.com/jmsilvadev/tx-parser/pkg/logger"
+	"github.com/jmsilvadev/tx-parser/pkg/metrics"
 	"github.com/jmsilvadev/tx-parser/pkg/parser"
+	"github.com/jmsilvadev/tx-parser/pkg/publisher"
+	"github.com/jmsilvadev/tx-parser/pkg/ratelimit"
+	"github.com/jmsilvadev/tx-parser/pkg/tracing"
+	"github.com/jmsilvadev/tx-parser/pkg/webhook"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
 )
 
 type Server struct {
 	environment string
 	port        string
-	timeout     time.Duration
+	grpcPort    string
 	logger      logger.Logger
 	conf        *config.Config
 	parser      parser.Parser
+	jsonrpc     jsonrpc.JsonRpcClient
+	webhooks    *webhook.Dispatcher
+	bus         *events.Bus
+	publisher   *publisher.Forwarder
+	metrics     *metrics.Metrics
+	health      *health.Checker
+	tracing     *tracing.Provider
+	logLevel    *logger.Level
+	cors        *handlers.CORSConfig
+	compression bool
+	accessLog   bool
+	middlewares []handlers.Middleware
+	apiKeys     *apikey.Store
+	rateLimit   *handlers.RateLimitConfig
+
+	timeouts       Timeouts
+	maxHeaderBytes int
+	tls            *tls.Config
+	http2          bool
+
+	setupOnce   sync.Once
+	hub         *stream.Hub
+	grpcService parserv1.ParserServiceServer
+	httpHandler http.Handler
+	rateLimiter *handlers.RateLimiter
 }
 
 type ServerOption func(*Server)
 
+// Default Values
+var (
+	defaultTimeouts = Timeouts{
+		ReadHeader: 5 * time.Second,
+		Read:       30 * time.Second,
+		Write:      30 * time.Second,
+		Idle:       2 * time.Minute,
+	}
+	maxHeaderBytes = 1 << 20
+)
+
+// Timeouts bound the HTTP connections, zero meaning no limit. Streams and
+// WebSockets lift the read and write ones off their connection.
+type Timeouts struct {
+	ReadHeader time.Duration
+	Read       time.Duration
+	Write      time.Duration
+	Idle       time.Duration
+}
+
 func NewServer(options ...ServerOption) *Server {
-	svr := &Server{}
+	svr := &Server{
+		timeouts:       defaultTimeouts,
+		maxHeaderBytes: maxHeaderBytes,
+		http2:          true,
+	}
 	for _, opt := range options {
 		opt(svr)
 	}
 	return svr
 }
 
-func (s *Server) Start(ctx context.Context) {
+// Handler serves the HTTP API, so the server can be mounted into an
+// existing HTTP stack. The streams only get events once Run is called.
+func (s *Server) Handler() http.Handler {
+	s.setupOnce.Do(s.setup)
+	return s.httpHandler
+}
+
+// Run starts the ingestion and the consumers of its events, until ctx is
+// done. Start calls it, embedders serving Handler must call it themselves.
+func (s *Server) Run(ctx context.Context) {
+	s.setupOnce.Do(s.setup)
+	if s.bus != nil {
+		// neither consumer may miss an event and both return quickly, the
+		// hub drops its own slow clients and webhooks only write the outbox
+		go events.Consume(ctx, s.bus.Subscribe(64, events.Block, events.KindTransactionMatched, events.KindBlockIngested), s.hub.Handle)
+		if s.webhooks != nil {
+			go events.Consume(ctx, s.bus.Subscribe(64, events.Block, events.KindTransactionMatched), s.webhooks.Handle)
+		}
+		if s.publisher != nil {
+			go events.Consume(ctx, s.bus.Subscribe(64, events.Block, events.KindTransactionMatched, events.KindReorgDetected), s.publisher.Handle)
+		}
+		if s.health != nil {
+			go events.Consume(ctx, s.bus.Subscribe(64, events.Block, events.KindBlockIngested, events.KindChainHeadObserved), s.health.Handle)
+		}
+	}
+	if s.webhooks != nil {
+		go s.webhooks.Run(ctx)
+	}
+	if s.publisher != nil {
+		go s.publisher.Run(ctx)
+	}
+	if s.rateLimiter != nil {
+		go s.rateLimiter.Run(ctx)
+	}
 	go s.parser.UpdateBlockNumber(ctx)
-	h := handlers.New(s.parser)
+}
 
-	http.HandleFunc("/health", h.HealthHandler)
-	http.HandleFunc("/v1/get-current-block", h.GetCurrentBlock)
-	http.HandleFunc("/v1/subscribe", h.Subscribe)
-	http.HandleFunc("/v1/get-transactions", h.GetTransactions)
-	http.HandleFunc("/", handlers.NotFoundHandler)
+func (s *Server) Start(ctx context.Context) {
+	s.Run(ctx)
+	server := s.httpServer()
 
-	server := &http.Server{
-		Addr: s.port,
+	var grpcServer *grpc.Server
+	if s.grpcPort != "" {
+		var opts []grpc.ServerOption
+		if s.tls != nil {
+			opts = append(opts, grpc.Creds(credentials.NewTLS(s.tls.Clone())))
+		}
+		if s.apiKeys != nil {
+			unary, stream := handlers.GRPCAuth(s.apiKeys)
+			opts = append(opts, grpc.UnaryInterceptor(unary), grpc.StreamInterceptor(stream))
+		}
+		grpcServer = grpc.NewServer(opts...)
+		parserv1.RegisterParserServiceServer(grpcServer, s.grpcService)
+		lis, err := net.Listen("tcp", s.grpcPort)
+		if err != nil {
+			log.Fatal("failed to listen: " + err.Error())
+		}
+		go func() {
+			s.logger.Info("grpc server listening at " + s.grpcPort)
+			if err := grpcServer.Serve(lis); err != nil {
+				s.logger.Error("grpc server failed: " + err.Error())
+			}
+		}()
 	}
 
 	listener := make(chan os.Signal, 1)
@@ -58,15 +174,204 @@ func (s *Server) Start(ctx context.Context) {
 	go func() {
 		s.logger.Warn(fmt.Sprint("received a shutdown signal:", <-listener))
 		s.logger.Warn("shutdown the server...")
+		if grpcServer != nil {
+			stopGRPC(grpcServer, 5*time.Second)
+		}
 		server.Shutdown(ctx)
+		if err := s.tracing.Shutdown(ctx); err != nil {
+			s.logger.Error("failed to flush the traces: " + err.Error())
+		}
 		wg.Done()
 	}()
 
 	s.logger.Info("server listening at " + s.port)
-	if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+	var err error
+	if server.TLSConfig != nil {
+		// the certificates come from the TLS config
+		err = server.ListenAndServeTLS("", "")
+	} else {
+		err = server.ListenAndServe()
+	}
+	if err != nil && err != http.ErrServerClosed {
 		log.Fatal("failed to serve: " + err.Error())
 	}
 
 	wg.Wait()
 	s.logger.Warn("server gracefully stopped")
 }
+
+// httpServer serves the handler with the timeouts, over TLS when
+// configured, HTTP/2 being negotiated unless disabled.
+func (s *Server) httpServer() *http.Server {
+	server := &http.Server{
+		Addr:              s.port,
+		Handler:           s.Handler(),
+		ReadHeaderTimeout: s.timeouts.ReadHeader,
+		ReadTimeout:       s.timeouts.Read,
+		WriteTimeout:      s.timeouts.Write,
+		IdleTimeout:       s.timeouts.Idle,
+		MaxHeaderBytes:    s.maxHeaderBytes,
+	}
+	if s.tls != nil {
+		server.TLSConfig = s.tls.Clone()
+		if s.mutualTLS() {
+			// the probes of the kubelet present no certificate, the other
+			// routes requiring one in the handler
+			server.TLSConfig.ClientAuth = tls.VerifyClientCertIfGiven
+		}
+		if !s.http2 {
+			// a non-nil map keeps net/http from setting HTTP/2 up
+			server.TLSNextProto = make(map[string]func(*http.Server, *tls.Conn, http.Handler))
+		}
+	}
+	return server
+}
+
+// mutualTLS tells whether the clients must present a certificate.
+func (s *Server) mutualTLS() bool {
+	return s.tls != nil && s.tls.ClientAuth == tls.RequireAndVerifyClientCert
+}
+
+// setup builds the routes of the server on a mux of its own, so servers
+// do not share them.
+func (s *Server) setup() {
+	s.hub = stream.NewHub(0)
+	var origins []string
+	if s.cors != nil {
+		origins = s.cors.AllowedOrigins
+	}
+	h := handlers.New(s.parser,
+		handlers.WithWebhooks(s.webhooks),
+		handlers.WithHub(s.hub),
+		handlers.WithHealth(s.health),
+		handlers.WithAllowedOrigins(origins),
+	)
+	s.grpcService = h.GRPCService()
+
+	if s.rateLimit != nil {
+		// the probes and the metrics are never limited
+		c := *s.rateLimit
+		c.Routes = maps.Clone(c.Routes)
+		if c.Routes == nil {
+			c.Routes = make(map[string]ratelimit.Limit)
+		}
+		for _, route := range []string{"/livez", "/readyz", "/metrics"} {
+			if _, ok := c.Routes[route]; !ok {
+				c.Routes[route] = ratelimit.Limit{}
+			}
+		}
+		store, _ := s.parser.(parser.Store)
+		s.rateLimiter = handlers.NewRateLimiter(c, store)
+	}
+
+	// with API keys, routes but the probes, the metrics and the document
+	// require a scope
+	scoped := func(scope string, h http.HandlerFunc) http.HandlerFunc {
+		if s.apiKeys == nil {
+			return h
+		}
+		return handlers.RequireScope(scope, h)
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /livez", h.Livez)
+	mux.HandleFunc("GET /readyz", h.Readyz)
+	mux.HandleFunc("GET /v1/get-current-block", scoped(apikey.ScopeRead, h.GetCurrentBlock))
+	mux.HandleFunc("POST /v1/subscribe", scoped(apikey.ScopeSubscribe, h.Subscribe))
+	mux.HandleFunc("GET /v1/get-transactions", scoped(apikey.ScopeRead, h.GetTransactions))
+	mux.HandleFunc("GET /v1/stream", scoped(apikey.ScopeRead, h.Stream))
+	mux.HandleFunc("GET /v1/ws", scoped(apikey.ScopeRead, h.WebSocket))
+	mux.HandleFunc("GET /v1/webhooks/dead-letters", scoped(apikey.ScopeAdmin, h.GetDeadLetters))
+	mux.HandleFunc("POST /rpc", scoped(apikey.ScopeRead, h.RPC))
+	if u, ok := s.jsonrpc.(jsonrpc.UsageReporter); ok {
+		mux.HandleFunc("GET /v1/rpc-usage", scoped(apikey.ScopeAdmin, handlers.UsageHandler(u)))
+	}
+	mux.HandleFunc("GET /openapi.json", handlers.OpenAPIHandler)
+	if s.metrics != nil {
+		mux.Handle("GET /metrics", s.metrics.Handler())
+	}
+	if s.logLevel != nil {
+		mux.HandleFunc("GET /admin/log-level", scoped(apikey.ScopeAdmin, handlers.LogLevelHandler(*s.logLevel)))
+		mux.HandleFunc("PUT /admin/log-level", scoped(apikey.ScopeAdmin, handlers.LogLevelHandler(*s.logLevel)))
+	}
+	if s.apiKeys != nil {
+		mux.HandleFunc("GET /admin/api-keys", scoped(apikey.ScopeAdmin, handlers.ListAPIKeysHandler(s.apiKeys)))
+		mux.HandleFunc("POST /admin/api-keys", scoped(apikey.ScopeAdmin, handlers.CreateAPIKeyHandler(s.apiKeys)))
+		mux.HandleFunc("DELETE /admin/api-keys", scoped(apikey.ScopeAdmin, handlers.RevokeAPIKeyHandler(s.apiKeys)))
+	}
+	mux.HandleFunc("/", handlers.NotFoundHandler)
+
+	s.httpHandler = s.wrap(mux)
+}
+
+// wrap puts the mux in the middlewares. The request id comes inside
+// the tracing, so the logged entries carry the trace id, and before the
+// access logs and the recovery, which log with it. The rate limits come
+// after the authentication, to tell the clients apart by their key.
+func (s *Server) wrap(mux *http.ServeMux) http.Handler {
+	var chain []handlers.Middleware
+	if s.tracing != nil {
+		chain = append(chain, func(next http.Handler) http.Handler {
+			return tracing.Handler(mux, next)
+		})
+	}
+	chain = append(chain, func(next http.Handler) http.Handler {
+		return handlers.RequestID(s.logger, next)
+	})
+	if s.accessLog {
+		chain = append(chain, func(next http.Handler) http.Handler {
+			return handlers.AccessLog(s.logger, next)
+		})
+	}
+	// outside the recovery, the authentication and the limits, so the
+	// requests they answer are measured too
+	chain = append(chain, func(next http.Handler) http.Handler {
+		return s.metrics.InstrumentHandler(mux, next)
+	})
+	chain = append(chain, func(next http.Handler) http.Handler {
+		return handlers.Recover(s.logger, next)
+	})
+	if s.cors != nil {
+		chain = append(chain, func(next http.Handler) http.Handler {
+			return handlers.CORS(*s.cors, next)
+		})
+	}
+	if s.mutualTLS() {
+		chain = append(chain, func(next http.Handler) http.Handler {
+			return handlers.RequireClientCert(mux, []string{"/livez", "/readyz"}, next)
+		})
+	}
+	if s.apiKeys != nil {
+		chain = append(chain, func(next http.Handler) http.Handler {
+			return handlers.Authenticate(s.apiKeys, next)
+		})
+	}
+	if s.rateLimiter != nil {
+		chain = append(chain, func(next http.Handler) http.Handler {
+			return s.rateLimiter.Handler(mux, next)
+		})
+	}
+	if s.compression {
+		chain = append(chain, func(next http.Handler) http.Handler {
+			return handlers.Compress(0, next)
+		})
+	}
+	chain = append(chain, s.middlewares...)
+	return handlers.Chain(handlers.ValidateRequests(mux), chain...)
+}
+
+// stopGRPC lets the running calls finish, then closes the ones left, as
+// streams only end with their client.
+func stopGRPC(srv *grpc.Server, timeout time.Duration) {
+	stopped := make(chan struct{})
+	go func() {
+		srv.GracefulStop()
+		close(stopped)
+	}()
+
+	select {
+	case <-stopped:
+	case <-time.After(timeout):
+		srv.Stop()
+	}
+}
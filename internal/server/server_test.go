@@ -1,15 +1,26 @@
 package server
 
 import (
+	"bufio"
 	"context"
+	"encoding/json"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
 	"time"
 
+	"github.com/gorilla/websocket"
 	"github.com/jmsilvadev/tx-parser/internal/handlers"
+	"github.com/jmsilvadev/tx-parser/internal/stream"
+	"github.com/jmsilvadev/tx-parser/pkg/events"
+	"github.com/jmsilvadev/tx-parser/pkg/health"
+	"github.com/jmsilvadev/tx-parser/pkg/jsonrpc"
+	"github.com/jmsilvadev/tx-parser/pkg/jsonrpc/jsonrpctest"
 	"github.com/jmsilvadev/tx-parser/pkg/logger"
 	"github.com/jmsilvadev/tx-parser/pkg/parser"
+	"github.com/jmsilvadev/tx-parser/pkg/parser/memorydb"
+	"github.com/jmsilvadev/tx-parser/pkg/webhook"
 	"github.com/stretchr/testify/assert"
 	"go.uber.org/zap/zapcore"
 )
@@ -24,12 +35,16 @@ func (m *MockParser) Subscribe(ctx context.Context, address string) bool {
 	return true
 }
 
+func (m *MockParser) Unsubscribe(ctx context.Context, address string) bool {
+	return true
+}
+
 func (m *MockParser) GetTransactions(ctx context.Context, address string) []parser.Transaction {
 	return []parser.Transaction{
 		{
 			Hash:        "0xabc",
-			From:        "0x123",
-			To:          "0x456",
+			From:        "0x1231231231231231231231231231231231231231",
+			To:          "0x4564564564564564564564564564564564564564",
 			Value:       "100",
 			BlockNumber: 1,
 		},
@@ -45,7 +60,7 @@ func TestServer(t *testing.T) {
 	s := NewServer(
 		func(s *Server) {
 			s.port = ":8080"
-			s.timeout = 10 * time.Second
+			s.timeouts = Timeouts{Write: 10 * time.Second}
 			s.logger = mockLogger
 			s.parser = mockParser
 		},
@@ -54,17 +69,45 @@ func TestServer(t *testing.T) {
 	go s.Start(context.Background())
 	time.Sleep(1 * time.Second)
 
-	t.Run("HealthHandler", func(t *testing.T) {
-		req, err := http.NewRequest("GET", "/health", nil)
+	t.Run("Livez", func(t *testing.T) {
+		req, err := http.NewRequest("GET", "/livez", nil)
 		assert.NoError(t, err)
 
 		rr := httptest.NewRecorder()
-		handler := http.HandlerFunc(handlers.New(mockParser).HealthHandler)
+		handler := http.HandlerFunc(handlers.New(mockParser).Livez)
 		handler.ServeHTTP(rr, req)
 
 		assert.Equal(t, http.StatusOK, rr.Code)
 	})
 
+	t.Run("Readyz", func(t *testing.T) {
+		req, err := http.NewRequest("GET", "/readyz", nil)
+		assert.NoError(t, err)
+
+		checker := health.New(nil, health.WithMaxAge(time.Minute), health.WithMaxLag(1))
+		rr := httptest.NewRecorder()
+		handler := http.HandlerFunc(handlers.New(mockParser, handlers.WithHealth(checker)).Readyz)
+		handler.ServeHTTP(rr, req)
+		assert.Equal(t, http.StatusOK, rr.Code)
+		assert.Contains(t, rr.Body.String(), `"ready":true`)
+
+		checker.Handle(context.Background(), events.BlockIngested{BlockNumber: 100})
+		checker.Handle(context.Background(), events.ChainHeadObserved{BlockNumber: 105})
+		rr = httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		assert.Equal(t, http.StatusServiceUnavailable, rr.Code)
+
+		var response struct {
+			Status string        `json:"status"`
+			Data   health.Report `json:"data"`
+		}
+		assert.NoError(t, json.Unmarshal(rr.Body.Bytes(), &response))
+		assert.Equal(t, "error", response.Status)
+		assert.False(t, response.Data.Ready)
+		assert.Equal(t, health.StatusFailing, response.Data.Components["lag"].Status)
+		assert.Equal(t, health.StatusOK, response.Data.Components["ingestion"].Status)
+	})
+
 	t.Run("GetCurrentBlock", func(t *testing.T) {
 		req, err := http.NewRequest("GET", "/v1/get-current-block", nil)
 		assert.NoError(t, err)
@@ -78,7 +121,7 @@ func TestServer(t *testing.T) {
 	})
 
 	t.Run("Subscribe", func(t *testing.T) {
-		req, err := http.NewRequest("POST", "/v1/subscribe?address=0x123", nil)
+		req, err := http.NewRequest("POST", "/v1/subscribe?address=0x1231231231231231231231231231231231231231", nil)
 		assert.NoError(t, err)
 
 		rr := httptest.NewRecorder()
@@ -90,7 +133,7 @@ func TestServer(t *testing.T) {
 	})
 
 	t.Run("GetTransactions", func(t *testing.T) {
-		req, err := http.NewRequest("GET", "/v1/get-transactions?address=0x123", nil)
+		req, err := http.NewRequest("GET", "/v1/get-transactions?address=0x1231231231231231231231231231231231231231", nil)
 		assert.NoError(t, err)
 
 		rr := httptest.NewRecorder()
@@ -105,3 +148,240 @@ func TestServer(t *testing.T) {
 	assert.NoError(t, err)
 	http.DefaultClient.Do(req)
 }
+
+type mockUsage struct{}
+
+func (m *mockUsage) Usage() []jsonrpc.Usage {
+	return []jsonrpc.Usage{{Endpoint: "http://node", Calls: map[string]uint64{"eth_blockNumber": 7}}}
+}
+
+func TestUsageHandler(t *testing.T) {
+	req, err := http.NewRequest("GET", "/v1/rpc-usage", nil)
+	assert.NoError(t, err)
+
+	rr := httptest.NewRecorder()
+	handlers.UsageHandler(&mockUsage{}).ServeHTTP(rr, req)
+
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Contains(t, rr.Body.String(), `"eth_blockNumber":7`)
+
+	req, err = http.NewRequest("POST", "/v1/rpc-usage", nil)
+	assert.NoError(t, err)
+
+	rr = httptest.NewRecorder()
+	handlers.ValidateRequests(handlers.UsageHandler(&mockUsage{})).ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusMethodNotAllowed, rr.Code)
+}
+
+func TestSubscribeWithWebhook(t *testing.T) {
+	l := logger.New(zapcore.DebugLevel)
+	db := memorydb.New(jsonrpc.NewEthereum(l, "http://localhost:8545"), l)
+	d := webhook.New(db, l, []byte("secret"))
+	h := handlers.New(db, handlers.WithWebhooks(d))
+
+	body := strings.NewReader(`{"address":"0x1231231231231231231231231231231231231231","webhook":"https://203.0.113.10/hook"}`)
+	req := httptest.NewRequest("POST", "/v1/subscribe", body)
+	rr := httptest.NewRecorder()
+	h.Subscribe(rr, req)
+	assert.Equal(t, http.StatusOK, rr.Code)
+
+	hook, ok := d.Webhook(context.Background(), "0x1231231231231231231231231231231231231231")
+	assert.True(t, ok)
+	assert.Equal(t, "https://203.0.113.10/hook", hook)
+
+	// updating the webhook of a subscribed address
+	body = strings.NewReader(`{"address":"0x1231231231231231231231231231231231231231","webhook":"https://203.0.113.11/hook"}`)
+	rr = httptest.NewRecorder()
+	h.Subscribe(rr, httptest.NewRequest("POST", "/v1/subscribe", body))
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Contains(t, rr.Body.String(), `"subscribed":false`)
+
+	body = strings.NewReader(`{"address":"0x1231231231231231231231231231231231231231","webhook":"not a url"}`)
+	rr = httptest.NewRecorder()
+	h.Subscribe(rr, httptest.NewRequest("POST", "/v1/subscribe", body))
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+	assert.True(t, db.Subscribed(context.Background(), "0x1231231231231231231231231231231231231231"))
+
+	// a refused webhook does not leave a new subscription behind
+	body = strings.NewReader(`{"address":"0x4564564564564564564564564564564564564564","webhook":"not a url"}`)
+	rr = httptest.NewRecorder()
+	h.Subscribe(rr, httptest.NewRequest("POST", "/v1/subscribe", body))
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+	assert.False(t, db.Subscribed(context.Background(), "0x4564564564564564564564564564564564564564"))
+
+	body = strings.NewReader(`{"address":"0x1231231231231231231231231231231231231231","webhook":"https://203.0.113.10/hook"}`)
+	rr = httptest.NewRecorder()
+	handlers.New(db).Subscribe(rr, httptest.NewRequest("POST", "/v1/subscribe", body))
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+
+	rr = httptest.NewRecorder()
+	h.GetDeadLetters(rr, httptest.NewRequest("GET", "/v1/webhooks/dead-letters", nil))
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Contains(t, rr.Body.String(), `"status":"success"`)
+
+	rr = httptest.NewRecorder()
+	handlers.New(db).GetDeadLetters(rr, httptest.NewRequest("GET", "/v1/webhooks/dead-letters", nil))
+	assert.Equal(t, http.StatusNotFound, rr.Code)
+}
+
+func readEvent(t *testing.T, r *bufio.Reader) (string, string) {
+	var id, data string
+	for {
+		line, err := r.ReadString('\n')
+		if !assert.NoError(t, err) {
+			return "", ""
+		}
+		line = strings.TrimSuffix(line, "\n")
+		switch {
+		case line == "" && id != "":
+			return id, data
+		case strings.HasPrefix(line, "id: "):
+			id = strings.TrimPrefix(line, "id: ")
+		case strings.HasPrefix(line, "data: "):
+			data = strings.TrimPrefix(line, "data: ")
+		}
+	}
+}
+
+func TestStream(t *testing.T) {
+	l := logger.New(zapcore.DebugLevel)
+	node := jsonrpctest.NewServer(jsonrpctest.DefaultChain())
+	defer node.Close()
+
+	address := "0xa1a1a1a1a1a1a1a1a1a1a1a1a1a1a1a1a1a1a1a1"
+	bus := events.New()
+	db := memorydb.New(jsonrpc.NewEthereum(l, node.URL), l, memorydb.WithBus(bus))
+	db.Subscribe(context.Background(), address)
+	hub := stream.NewHub(0)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go events.Consume(ctx, bus.Subscribe(64, events.Block), hub.Handle)
+
+	srv := httptest.NewServer(http.HandlerFunc(handlers.New(db, handlers.WithHub(hub)).Stream))
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL + "?address=" + strings.ToUpper(address))
+	assert.NoError(t, err)
+	defer resp.Body.Close()
+	assert.Equal(t, "text/event-stream", resp.Header.Get("Content-Type"))
+	assert.Eventually(t, func() bool { return hub.Subscribers() == 1 }, time.Second, 10*time.Millisecond)
+
+	go db.UpdateBlockNumber(ctx)
+
+	id, data := readEvent(t, bufio.NewReader(resp.Body))
+	assert.True(t, strings.HasPrefix(id, "104:0x"))
+	assert.Contains(t, data, address)
+
+	// resuming from an older cursor replays the history
+	req, _ := http.NewRequest("GET", srv.URL+"?address="+address, nil)
+	req.Header.Set("Last-Event-ID", "100:0xunknown")
+	resumed, err := http.DefaultClient.Do(req)
+	assert.NoError(t, err)
+	defer resumed.Body.Close()
+
+	replayedID, _ := readEvent(t, bufio.NewReader(resumed.Body))
+	assert.Equal(t, id, replayedID)
+
+	rr := httptest.NewRecorder()
+	handlers.New(db, handlers.WithHub(hub)).Stream(rr, httptest.NewRequest("GET", "/v1/stream", nil))
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+
+	req = httptest.NewRequest("GET", "/v1/stream?address=0x1111111111111111111111111111111111111111", nil)
+	req.Header.Set("Last-Event-ID", "bad")
+	rr = httptest.NewRecorder()
+	handlers.New(db, handlers.WithHub(hub)).Stream(rr, req)
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+}
+
+func TestWebSocket(t *testing.T) {
+	l := logger.New(zapcore.DebugLevel)
+	node := jsonrpctest.NewServer(jsonrpctest.DefaultChain())
+	defer node.Close()
+
+	address := "0xa1a1a1a1a1a1a1a1a1a1a1a1a1a1a1a1a1a1a1a1"
+	bus := events.New()
+	db := memorydb.New(jsonrpc.NewEthereum(l, node.URL), l, memorydb.WithBus(bus))
+	hub := stream.NewHub(0)
+
+	srv := httptest.NewServer(http.HandlerFunc(handlers.New(db, handlers.WithHub(hub)).WebSocket))
+	defer srv.Close()
+
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http"), nil)
+	assert.NoError(t, err)
+	defer conn.Close()
+
+	var msg map[string]interface{}
+	assert.NoError(t, conn.WriteJSON(map[string]string{"type": "subscribe"}))
+	assert.NoError(t, conn.ReadJSON(&msg))
+	assert.Equal(t, "error", msg["type"])
+
+	assert.NoError(t, conn.WriteJSON(map[string]string{"type": "subscribe", "address": address}))
+	assert.NoError(t, conn.ReadJSON(&msg))
+	assert.Equal(t, "subscribed", msg["type"])
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go events.Consume(ctx, bus.Subscribe(64, events.Block), hub.Handle)
+	go db.UpdateBlockNumber(ctx)
+
+	seen := map[string]bool{}
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	for !seen["transaction"] || !seen["block"] {
+		msg = nil
+		if !assert.NoError(t, conn.ReadJSON(&msg)) {
+			return
+		}
+		seen[msg["type"].(string)] = true
+	}
+
+	assert.NoError(t, conn.WriteJSON(map[string]string{"type": "unsubscribe", "address": address}))
+	assert.NoError(t, conn.ReadJSON(&msg))
+	assert.Equal(t, "unsubscribed", msg["type"])
+	assert.Eventually(t, func() bool { return !db.Subscribed(context.Background(), address) }, time.Second, 10*time.Millisecond)
+
+	// the addresses subscribed before the connection outlive it, those it
+	// subscribed do not
+	other := "0xb2b2b2b2b2b2b2b2b2b2b2b2b2b2b2b2b2b2b2b2"
+	db.Subscribe(context.Background(), other)
+	assert.NoError(t, conn.WriteJSON(map[string]string{"type": "subscribe", "address": other}))
+	assert.NoError(t, conn.ReadJSON(&msg))
+	assert.NoError(t, conn.WriteJSON(map[string]string{"type": "subscribe", "address": address}))
+	assert.NoError(t, conn.ReadJSON(&msg))
+	assert.Eventually(t, func() bool { return db.Subscribed(context.Background(), address) }, time.Second, 10*time.Millisecond)
+	conn.Close()
+	assert.Eventually(t, func() bool { return !db.Subscribed(context.Background(), address) }, time.Second, 10*time.Millisecond)
+	assert.True(t, db.Subscribed(context.Background(), other))
+}
+
+func TestWebSocketOrigin(t *testing.T) {
+	l := logger.New(zapcore.DebugLevel)
+	db := memorydb.New(jsonrpc.NewEthereum(l, "http://localhost:8545"), l)
+	srv := httptest.NewServer(NewServer(WithLogger(l), WithParser(db), WithCORS(handlers.CORSConfig{AllowedOrigins: []string{"https://app.example.com"}})).Handler())
+	defer srv.Close()
+	url := "ws" + strings.TrimPrefix(srv.URL, "http") + "/v1/ws"
+
+	for origin, allowed := range map[string]bool{
+		"":                             true,
+		srv.URL:                        true,
+		"https://app.example.com":      true,
+		"https://attacker.example":     false,
+		"https://app.example.com.evil": false,
+	} {
+		header := http.Header{}
+		if origin != "" {
+			header.Set("Origin", origin)
+		}
+		conn, resp, err := websocket.DefaultDialer.Dial(url, header)
+		if allowed {
+			if assert.NoError(t, err, origin) {
+				conn.Close()
+			}
+			continue
+		}
+		assert.Error(t, err, origin)
+		if assert.NotNil(t, resp, origin) {
+			assert.Equal(t, http.StatusForbidden, resp.StatusCode, origin)
+		}
+	}
+}
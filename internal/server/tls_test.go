@@ -0,0 +1,77 @@
+package server
+
+import (
+	"crypto/tls"
+	"net"
+	"net/http"
+	"testing"
+
+	"github.com/jmsilvadev/tx-parser/pkg/certs"
+	"github.com/jmsilvadev/tx-parser/pkg/certs/certstest"
+	"github.com/jmsilvadev/tx-parser/pkg/jsonrpc"
+	"github.com/jmsilvadev/tx-parser/pkg/logger"
+	"github.com/jmsilvadev/tx-parser/pkg/parser/memorydb"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap/zapcore"
+)
+
+func TestTLS(t *testing.T) {
+	l := logger.New(zapcore.DebugLevel)
+	db := memorydb.New(jsonrpc.NewEthereum(l, "http://localhost:8545"), l)
+	ca := certstest.NewCA()
+	certFile, keyFile := ca.WriteFiles(t.TempDir(), "localhost")
+	r, err := certs.New(certFile, keyFile, l)
+	require.NoError(t, err)
+
+	serve := func(options ...ServerOption) string {
+		srv := NewServer(append([]ServerOption{WithLogger(l), WithParser(db)}, options...)...).httpServer()
+		ln, err := net.Listen("tcp", "127.0.0.1:0")
+		require.NoError(t, err)
+		go srv.ServeTLS(ln, "", "")
+		t.Cleanup(func() { srv.Close() })
+		return "https://" + ln.Addr().String()
+	}
+	get := func(url string, clientCerts ...tls.Certificate) (*http.Response, error) {
+		cli := &http.Client{Transport: &http.Transport{
+			TLSClientConfig:   &tls.Config{RootCAs: ca.Pool(), Certificates: clientCerts},
+			ForceAttemptHTTP2: true,
+		}}
+		resp, err := cli.Get(url)
+		if err == nil {
+			resp.Body.Close()
+		}
+		return resp, err
+	}
+
+	url := serve(WithTLS(certs.ServerConfig(r, nil, nil)))
+	resp, err := get(url + "/livez")
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "HTTP/2.0", resp.Proto)
+
+	url = serve(WithTLS(certs.ServerConfig(r, nil, nil)), WithHTTP2(false))
+	resp, err = get(url + "/livez")
+	require.NoError(t, err)
+	assert.Equal(t, "HTTP/1.1", resp.Proto)
+
+	// with mutual TLS only the allowed clients get through
+	url = serve(WithTLS(certs.ServerConfig(r, ca.Pool(), []string{"dashboard"})))
+	resp, err = get(url+"/v1/get-current-block", ca.Certificate("dashboard"))
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	_, err = get(url+"/v1/get-current-block", ca.Certificate("intruder"))
+	assert.Error(t, err)
+	_, err = get(url+"/livez", certstest.NewCA().Certificate("dashboard"))
+	assert.Error(t, err)
+	resp, err = get(url + "/v1/get-current-block")
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+
+	// the probes of the kubelet present no certificate
+	for _, probe := range []string{"/livez", "/readyz"} {
+		resp, err = get(url + probe)
+		require.NoError(t, err)
+		assert.NotEqual(t, http.StatusUnauthorized, resp.StatusCode, probe)
+	}
+}
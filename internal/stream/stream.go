@@ -0,0 +1,250 @@
+package stream
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/jmsilvadev/tx-parser/pkg/events"
+	"github.com/jmsilvadev/tx-parser/pkg/parser"
+)
+
+// Default Values
+var (
+	defaultBuffer = 64
+)
+
+const (
+	EventTransaction = "transaction"
+	EventBlock       = "block"
+)
+
+// Event is either a transaction delivered to the subscribers of one of
+// its addresses, or a new block delivered to the block subscribers.
+type Event struct {
+	Type        string
+	ID          string
+	BlockNumber int
+	Transaction parser.Transaction
+}
+
+// Cursor identifies a transaction in the history of an address: its block
+// number and hash.
+type Cursor struct {
+	BlockNumber int
+	Hash        string
+}
+
+func (c Cursor) String() string {
+	return fmt.Sprintf("%d:%s", c.BlockNumber, c.Hash)
+}
+
+// ParseCursor reads a cursor formatted by Cursor.String.
+func ParseCursor(v string) (Cursor, error) {
+	block, hash, _ := strings.Cut(v, ":")
+	n, err := strconv.Atoi(block)
+	if err != nil {
+		return Cursor{}, fmt.Errorf("invalid cursor: %s", v)
+	}
+	return Cursor{BlockNumber: n, Hash: strings.ToLower(hash)}, nil
+}
+
+// Hub fans transactions out to subscribers. Publishing never blocks: a
+// subscriber whose buffer is full is dropped and its channel closed, so a
+// slow client cannot stall ingestion. Dropped clients resume from their
+// last cursor.
+type Hub struct {
+	mu        sync.RWMutex
+	buffer    int
+	addresses map[string]map[*Subscriber]struct{}
+	blocks    map[*Subscriber]struct{}
+}
+
+type Subscriber struct {
+	C         chan Event
+	addresses map[string]struct{}
+	closed    bool
+}
+
+func NewHub(buffer int) *Hub {
+	if buffer <= 0 {
+		buffer = defaultBuffer
+	}
+	return &Hub{
+		buffer:    buffer,
+		addresses: make(map[string]map[*Subscriber]struct{}),
+		blocks:    make(map[*Subscriber]struct{}),
+	}
+}
+
+func (h *Hub) Subscribe(addresses ...string) *Subscriber {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	s := &Subscriber{
+		C:         make(chan Event, h.buffer),
+		addresses: make(map[string]struct{}),
+	}
+	h.add(s, addresses...)
+	return s
+}
+
+// Add watches more addresses on an existing subscriber.
+func (h *Hub) Add(s *Subscriber, addresses ...string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if !s.closed {
+		h.add(s, addresses...)
+	}
+}
+
+// Remove stops watching addresses, the subscriber stays connected.
+func (h *Hub) Remove(s *Subscriber, addresses ...string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for _, a := range addresses {
+		a = strings.ToLower(a)
+		delete(s.addresses, a)
+		delete(h.addresses[a], s)
+		if len(h.addresses[a]) == 0 {
+			delete(h.addresses, a)
+		}
+	}
+}
+
+// WatchBlocks makes the subscriber receive new block events.
+func (h *Hub) WatchBlocks(s *Subscriber) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if !s.closed {
+		h.blocks[s] = struct{}{}
+	}
+}
+
+func (h *Hub) Unsubscribe(s *Subscriber) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.remove(s)
+}
+
+// Subscribers returns the number of connected subscribers.
+func (h *Hub) Subscribers() int {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	subs := make(map[*Subscriber]struct{})
+	for s := range h.blocks {
+		subs[s] = struct{}{}
+	}
+	for _, set := range h.addresses {
+		for s := range set {
+			subs[s] = struct{}{}
+		}
+	}
+	return len(subs)
+}
+
+// Publish sends a transaction to the subscribers of its addresses, Handle
+// calling it for the transactions matched on the events bus.
+func (h *Hub) Publish(ctx context.Context, tx parser.Transaction) {
+	event := Event{
+		Type:        EventTransaction,
+		ID:          Cursor{BlockNumber: tx.BlockNumber, Hash: strings.ToLower(tx.Hash)}.String(),
+		BlockNumber: tx.BlockNumber,
+		Transaction: tx,
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	sent := make(map[*Subscriber]bool)
+	for _, a := range []string{strings.ToLower(tx.From), strings.ToLower(tx.To)} {
+		for s := range h.addresses[a] {
+			if sent[s] {
+				continue
+			}
+			sent[s] = true
+			h.send(s, event)
+		}
+	}
+}
+
+// PublishBlock notifies the block subscribers of a new block.
+func (h *Hub) PublishBlock(ctx context.Context, blockNumber int) {
+	event := Event{
+		Type:        EventBlock,
+		ID:          strconv.Itoa(blockNumber),
+		BlockNumber: blockNumber,
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for s := range h.blocks {
+		h.send(s, event)
+	}
+}
+
+func (h *Hub) send(s *Subscriber, event Event) {
+	select {
+	case s.C <- event:
+	default:
+		h.remove(s)
+	}
+}
+
+func (h *Hub) add(s *Subscriber, addresses ...string) {
+	for _, a := range addresses {
+		a = strings.ToLower(a)
+		if h.addresses[a] == nil {
+			h.addresses[a] = make(map[*Subscriber]struct{})
+		}
+		h.addresses[a][s] = struct{}{}
+		s.addresses[a] = struct{}{}
+	}
+}
+
+func (h *Hub) remove(s *Subscriber) {
+	if s.closed {
+		return
+	}
+	s.closed = true
+	for a := range s.addresses {
+		delete(h.addresses[a], s)
+		if len(h.addresses[a]) == 0 {
+			delete(h.addresses, a)
+		}
+	}
+	delete(h.blocks, s)
+	close(s.C)
+}
+
+// Handle forwards the ingestion events of the bus to the subscribers.
+func (h *Hub) Handle(ctx context.Context, e events.Event) {
+	switch e := e.(type) {
+	case events.TransactionMatched:
+		h.Publish(ctx, e.Transaction)
+	case events.BlockIngested:
+		h.PublishBlock(ctx, e.BlockNumber)
+	}
+}
+
+// After returns the transactions of the history that come after the
+// cursor. When the cursor hash is not in the history, every transaction
+// of a later block is returned.
+func After(history []parser.Transaction, c Cursor) []parser.Transaction {
+	for i, tx := range history {
+		if tx.BlockNumber == c.BlockNumber && strings.EqualFold(tx.Hash, c.Hash) {
+			return history[i+1:]
+		}
+	}
+
+	var after []parser.Transaction
+	for _, tx := range history {
+		if tx.BlockNumber > c.BlockNumber {
+			after = append(after, tx)
+		}
+	}
+	return after
+}
@@ -0,0 +1,109 @@
+package stream
+
+import (
+	"context"
+	"testing"
+
+	"github.com/jmsilvadev/tx-parser/pkg/events"
+	"github.com/jmsilvadev/tx-parser/pkg/parser"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPublish(t *testing.T) {
+	h := NewHub(2)
+	a := h.Subscribe("0xAAA")
+	b := h.Subscribe("0xbbb", "0xaaa")
+	assert.Equal(t, 2, h.Subscribers())
+
+	h.Publish(context.Background(), parser.Transaction{Hash: "0x1", From: "0xaaa", To: "0xbbb", BlockNumber: 7})
+
+	event := <-a.C
+	assert.Equal(t, "7:0x1", event.ID)
+	assert.Equal(t, "0x1", (<-b.C).Transaction.Hash)
+	assert.Len(t, b.C, 0)
+
+	h.Unsubscribe(a)
+	h.Unsubscribe(a)
+	assert.Equal(t, 1, h.Subscribers())
+	_, ok := <-a.C
+	assert.False(t, ok)
+}
+
+func TestSlowSubscriberDropped(t *testing.T) {
+	h := NewHub(1)
+	s := h.Subscribe("0xaaa")
+
+	h.Publish(context.Background(), parser.Transaction{Hash: "0x1", To: "0xaaa"})
+	h.Publish(context.Background(), parser.Transaction{Hash: "0x2", To: "0xaaa"})
+
+	assert.Equal(t, 0, h.Subscribers())
+	<-s.C
+	_, ok := <-s.C
+	assert.False(t, ok)
+}
+
+func TestCursor(t *testing.T) {
+	c, err := ParseCursor("12:0xABC")
+	assert.NoError(t, err)
+	assert.Equal(t, Cursor{BlockNumber: 12, Hash: "0xabc"}, c)
+	assert.Equal(t, "12:0xabc", c.String())
+
+	_, err = ParseCursor("nope")
+	assert.Error(t, err)
+}
+
+func TestAfter(t *testing.T) {
+	history := []parser.Transaction{
+		{Hash: "0x1", BlockNumber: 10},
+		{Hash: "0x2", BlockNumber: 11},
+		{Hash: "0x3", BlockNumber: 11},
+		{Hash: "0x4", BlockNumber: 12},
+	}
+
+	assert.Equal(t, history[2:], After(history, Cursor{BlockNumber: 11, Hash: "0x2"}))
+	assert.Equal(t, history[3:], After(history, Cursor{BlockNumber: 11, Hash: "0xunknown"}))
+	assert.Empty(t, After(history, Cursor{BlockNumber: 12, Hash: "0x4"}))
+}
+
+func TestAddRemove(t *testing.T) {
+	h := NewHub(4)
+	s := h.Subscribe()
+	h.Add(s, "0xAAA", "0xbbb")
+	h.Remove(s, "0xaaa")
+
+	h.Publish(context.Background(), parser.Transaction{Hash: "0x1", To: "0xaaa"})
+	h.Publish(context.Background(), parser.Transaction{Hash: "0x2", To: "0xbbb"})
+
+	assert.Equal(t, "0x2", (<-s.C).Transaction.Hash)
+	assert.Len(t, s.C, 0)
+}
+
+func TestPublishBlock(t *testing.T) {
+	h := NewHub(4)
+	s := h.Subscribe()
+	other := h.Subscribe("0xaaa")
+	h.WatchBlocks(s)
+
+	h.PublishBlock(context.Background(), 12)
+	event := <-s.C
+	assert.Equal(t, EventBlock, event.Type)
+	assert.Equal(t, 12, event.BlockNumber)
+	assert.Len(t, other.C, 0)
+
+	h.Unsubscribe(s)
+	assert.Equal(t, 1, h.Subscribers())
+}
+
+func TestHandle(t *testing.T) {
+	h := NewHub(4)
+	s := h.Subscribe("0xaaa")
+	h.WatchBlocks(s)
+
+	h.Handle(context.Background(), events.TransactionMatched{Transaction: parser.Transaction{Hash: "0x1", From: "0xAAA", BlockNumber: 7}})
+	h.Handle(context.Background(), events.BlockIngested{BlockNumber: 7})
+	h.Handle(context.Background(), events.SubscriptionAdded{Address: "0xaaa"})
+
+	assert.Equal(t, EventTransaction, (<-s.C).Type)
+	assert.Equal(t, EventBlock, (<-s.C).Type)
+	assert.Len(t, s.C, 0)
+}
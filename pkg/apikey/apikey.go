@@ -0,0 +1,238 @@
+package apikey
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/jmsilvadev/tx-parser/pkg/parser"
+)
+
+const (
+	ScopeRead      = "read"
+	ScopeSubscribe = "subscribe"
+	ScopeAdmin     = "admin"
+	ScopeOperator  = "operator"
+
+	keyPrefix   = "apikey:"
+	secretStart = "txp_"
+	bootstrapID = "bootstrap"
+)
+
+var (
+	ErrInvalidKey    = errors.New("invalid api key")
+	ErrInvalidScope  = errors.New("scopes must be read, subscribe, admin or operator")
+	ErrNotFound      = errors.New("api key not found")
+	ErrInvalidTenant = errors.New("tenant must be 1 to 64 letters, digits, '_', '-' or '.'")
+)
+
+// Key describes an API key. The secret itself is only known to the
+// client, the store keeps its hash. The subscriptions made with the key
+// belong to its tenant.
+type Key struct {
+	ID        string     `json:"id"`
+	Name      string     `json:"name"`
+	Tenant    string     `json:"tenant"`
+	Scopes    []string   `json:"scopes"`
+	CreatedAt time.Time  `json:"created_at"`
+	RevokedAt *time.Time `json:"revoked_at,omitempty"`
+}
+
+// Has tells whether the key grants scope. Admin grants them all within
+// the tenant of the key, operator them all across the tenants.
+func (k Key) Has(scope string) bool {
+	if slices.Contains(k.Scopes, scope) || slices.Contains(k.Scopes, ScopeOperator) {
+		return true
+	}
+	return scope != ScopeOperator && slices.Contains(k.Scopes, ScopeAdmin)
+}
+
+type record struct {
+	Key
+	Hash string `json:"hash"`
+}
+
+// Store keeps the API keys in the parser store.
+type Store struct {
+	store     parser.Store
+	bootstrap string
+	now       func() time.Time
+}
+
+type Option func(*Store)
+
+func New(store parser.Store, options ...Option) *Store {
+	s := &Store{store: store, now: time.Now}
+	for _, opt := range options {
+		opt(s)
+	}
+	return s
+}
+
+// Create stores a new key and returns it along with its secret, which
+// cannot be recovered afterwards. An empty tenant is the default one.
+func (s *Store) Create(name, tenant string, scopes []string) (Key, string, error) {
+	if tenant == "" {
+		tenant = parser.DefaultTenant
+	}
+	if !parser.ValidTenant(tenant) {
+		return Key{}, "", ErrInvalidTenant
+	}
+	if len(scopes) == 0 {
+		return Key{}, "", ErrInvalidScope
+	}
+	for _, scope := range scopes {
+		if scope != ScopeRead && scope != ScopeSubscribe && scope != ScopeAdmin && scope != ScopeOperator {
+			return Key{}, "", ErrInvalidScope
+		}
+	}
+
+	id, err := random(8)
+	if err != nil {
+		return Key{}, "", err
+	}
+	secret, err := random(32)
+	if err != nil {
+		return Key{}, "", err
+	}
+	secret = secretStart + id + "_" + secret
+
+	key := Key{
+		ID:        id,
+		Name:      name,
+		Tenant:    tenant,
+		Scopes:    slices.Compact(slices.Sorted(slices.Values(scopes))),
+		CreatedAt: s.now().UTC(),
+	}
+	if err := s.save(record{Key: key, Hash: hash(secret)}); err != nil {
+		return Key{}, "", err
+	}
+	return key, secret, nil
+}
+
+// List returns the keys, revoked ones included, by id.
+func (s *Store) List() ([]Key, error) {
+	keys := []Key{}
+	var err error
+	iterErr := s.store.Iterate(keyPrefix, func(_ string, value []byte) bool {
+		var r record
+		if err = json.Unmarshal(value, &r); err != nil {
+			return false
+		}
+		keys = append(keys, r.Key)
+		return true
+	})
+	if iterErr != nil {
+		return nil, iterErr
+	}
+	return keys, err
+}
+
+// Get returns the key of an id, revoked or not.
+func (s *Store) Get(id string) (Key, error) {
+	r, err := s.load(id)
+	if err != nil {
+		return Key{}, err
+	}
+	return r.Key, nil
+}
+
+// Revoke stops a key from authenticating. It is kept, so the listing
+// still shows it.
+func (s *Store) Revoke(id string) (Key, error) {
+	r, err := s.load(id)
+	if err != nil {
+		return Key{}, err
+	}
+	if r.RevokedAt == nil {
+		now := s.now().UTC()
+		r.RevokedAt = &now
+		if err := s.save(r); err != nil {
+			return Key{}, err
+		}
+	}
+	return r.Key, nil
+}
+
+// Authenticate returns the key of a secret, unless it is unknown or
+// revoked.
+func (s *Store) Authenticate(secret string) (Key, error) {
+	if s.bootstrap != "" && subtle.ConstantTimeCompare([]byte(hash(secret)), []byte(s.bootstrap)) == 1 {
+		return Key{ID: bootstrapID, Name: bootstrapID, Tenant: parser.DefaultTenant, Scopes: []string{ScopeOperator}}, nil
+	}
+
+	id, _, ok := strings.Cut(strings.TrimPrefix(secret, secretStart), "_")
+	if !ok || !strings.HasPrefix(secret, secretStart) {
+		return Key{}, ErrInvalidKey
+	}
+	r, err := s.load(id)
+	if err != nil {
+		return Key{}, ErrInvalidKey
+	}
+	if subtle.ConstantTimeCompare([]byte(hash(secret)), []byte(r.Hash)) != 1 || r.RevokedAt != nil {
+		return Key{}, ErrInvalidKey
+	}
+	return r.Key, nil
+}
+
+func (s *Store) load(id string) (record, error) {
+	value, err := s.store.Get(keyPrefix + id)
+	if errors.Is(err, parser.ErrNotFound) {
+		return record{}, ErrNotFound
+	}
+	if err != nil {
+		return record{}, err
+	}
+	var r record
+	if err := json.Unmarshal(value, &r); err != nil {
+		return record{}, fmt.Errorf("api key %s: %w", id, err)
+	}
+	// keys created before the tenants belong to the default one
+	if r.Tenant == "" {
+		r.Tenant = parser.DefaultTenant
+	}
+	return r, nil
+}
+
+func (s *Store) save(r record) error {
+	value, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	return s.store.Put(keyPrefix+r.ID, value)
+}
+
+type ctxKey struct{}
+
+// NewContext returns a copy of ctx carrying the key of the caller.
+func NewContext(ctx context.Context, k Key) context.Context {
+	return context.WithValue(ctx, ctxKey{}, k)
+}
+
+// FromContext returns the key of the caller, if authenticated.
+func FromContext(ctx context.Context) (Key, bool) {
+	k, ok := ctx.Value(ctxKey{}).(Key)
+	return k, ok
+}
+
+// hash only needs to be fast and one-way, the secrets being random.
+func hash(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+func random(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
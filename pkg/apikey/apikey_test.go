@@ -0,0 +1,118 @@
+package apikey
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jmsilvadev/tx-parser/pkg/jsonrpc"
+	"github.com/jmsilvadev/tx-parser/pkg/logger"
+	"github.com/jmsilvadev/tx-parser/pkg/parser"
+	"github.com/jmsilvadev/tx-parser/pkg/parser/memorydb"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap/zapcore"
+)
+
+func newStore() *memorydb.DB {
+	l := logger.New(zapcore.DebugLevel)
+	return memorydb.New(jsonrpc.NewEthereum(l, "http://localhost:8545"), l)
+}
+
+func TestCreate(t *testing.T) {
+	db := newStore()
+	s := New(db)
+
+	key, secret, err := s.Create("dashboard", "", []string{ScopeSubscribe, ScopeRead, ScopeRead})
+	require.NoError(t, err)
+	require.Equal(t, "dashboard", key.Name)
+	require.Equal(t, []string{ScopeRead, ScopeSubscribe}, key.Scopes)
+	require.True(t, strings.HasPrefix(secret, "txp_"+key.ID+"_"))
+
+	// only the hash is stored
+	value, err := db.Get(keyPrefix + key.ID)
+	require.NoError(t, err)
+	require.NotContains(t, string(value), secret)
+	require.Contains(t, string(value), hash(secret))
+
+	_, _, err = s.Create("none", "", nil)
+	require.ErrorIs(t, err, ErrInvalidScope)
+	_, _, err = s.Create("root", "", []string{"root"})
+	require.ErrorIs(t, err, ErrInvalidScope)
+
+	require.Equal(t, parser.DefaultTenant, key.Tenant)
+	key, _, err = s.Create("acme", "acme", []string{ScopeRead})
+	require.NoError(t, err)
+	require.Equal(t, "acme", key.Tenant)
+	_, _, err = s.Create("acme", "acme/other", []string{ScopeRead})
+	require.ErrorIs(t, err, ErrInvalidTenant)
+}
+
+func TestAuthenticate(t *testing.T) {
+	s := New(newStore(), WithBootstrapKey("bootstrap-secret"))
+	key, secret, err := s.Create("dashboard", "", []string{ScopeRead})
+	require.NoError(t, err)
+
+	got, err := s.Authenticate(secret)
+	require.NoError(t, err)
+	require.Equal(t, key.ID, got.ID)
+	require.True(t, got.Has(ScopeRead))
+	require.False(t, got.Has(ScopeSubscribe))
+
+	for _, v := range []string{"", "nope", secret + "x", "txp_" + key.ID, "txp_unknown_secret"} {
+		_, err = s.Authenticate(v)
+		require.ErrorIs(t, err, ErrInvalidKey, v)
+	}
+
+	admin, err := s.Authenticate("bootstrap-secret")
+	require.NoError(t, err)
+	require.True(t, admin.Has(ScopeSubscribe))
+	require.True(t, admin.Has(ScopeAdmin))
+	require.True(t, admin.Has(ScopeOperator))
+	require.Equal(t, parser.DefaultTenant, admin.Tenant)
+}
+
+func TestScopes(t *testing.T) {
+	admin := Key{Scopes: []string{ScopeAdmin}}
+	require.True(t, admin.Has(ScopeSubscribe))
+	require.False(t, admin.Has(ScopeOperator))
+
+	operator := Key{Scopes: []string{ScopeOperator}}
+	require.True(t, operator.Has(ScopeAdmin))
+	require.True(t, operator.Has(ScopeRead))
+}
+
+func TestRevoke(t *testing.T) {
+	s := New(newStore())
+	now := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	s.now = func() time.Time { return now }
+
+	a, secret, err := s.Create("a", "", []string{ScopeRead})
+	require.NoError(t, err)
+	b, _, err := s.Create("b", "", []string{ScopeAdmin})
+	require.NoError(t, err)
+
+	revoked, err := s.Revoke(a.ID)
+	require.NoError(t, err)
+	require.Equal(t, now, *revoked.RevokedAt)
+	_, err = s.Authenticate(secret)
+	require.ErrorIs(t, err, ErrInvalidKey)
+
+	_, err = s.Revoke("unknown")
+	require.ErrorIs(t, err, ErrNotFound)
+
+	keys, err := s.List()
+	require.NoError(t, err)
+	require.Len(t, keys, 2)
+	require.ElementsMatch(t, []string{a.ID, b.ID}, []string{keys[0].ID, keys[1].ID})
+}
+
+func TestContext(t *testing.T) {
+	_, ok := FromContext(context.Background())
+	require.False(t, ok)
+
+	key := Key{ID: "abc"}
+	got, ok := FromContext(NewContext(context.Background(), key))
+	require.True(t, ok)
+	require.Equal(t, key, got)
+}
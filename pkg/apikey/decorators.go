@@ -0,0 +1,11 @@
+package apikey
+
+// WithBootstrapKey accepts secret as an operator key without storing it,
+// so the first keys of every tenant can be created.
+func WithBootstrapKey(secret string) Option {
+	return func(s *Store) {
+		if secret != "" {
+			s.bootstrap = hash(secret)
+		}
+	}
+}
@@ -0,0 +1,153 @@
+package certs
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/jmsilvadev/tx-parser/pkg/logger"
+	"go.uber.org/zap"
+)
+
+// Default Values
+var (
+	reloadInterval = 5 * time.Second
+)
+
+var ErrClientNotAllowed = errors.New("client certificate not allowed")
+
+// Reloader serves a certificate and key pair read from files, loading them
+// again when they change, so renewed certificates are picked up without a
+// restart. The files are checked on handshakes, at most once per interval.
+type Reloader struct {
+	certFile string
+	keyFile  string
+	log      logger.Logger
+	interval time.Duration
+	now      func() time.Time
+
+	mu      sync.Mutex
+	cert    *tls.Certificate
+	loaded  time.Time
+	checked time.Time
+}
+
+type Option func(*Reloader)
+
+// New fails when the pair cannot be loaded.
+func New(certFile, keyFile string, l logger.Logger, options ...Option) (*Reloader, error) {
+	r := &Reloader{
+		certFile: certFile,
+		keyFile:  keyFile,
+		log:      l,
+		interval: reloadInterval,
+		now:      time.Now,
+	}
+	for _, opt := range options {
+		opt(r)
+	}
+	if err := r.reload(); err != nil {
+		return nil, err
+	}
+	r.checked = r.now()
+	return r, nil
+}
+
+// GetCertificate is the callback of tls.Config. A pair failing to load,
+// e.g. while being written, is logged and the previous one kept.
+func (r *Reloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if now := r.now(); now.Sub(r.checked) >= r.interval {
+		r.checked = now
+		if err := r.reload(); err != nil {
+			r.log.Error("failed to reload the tls certificate", logger.Err(err))
+		}
+	}
+	return r.cert, nil
+}
+
+// reload loads the pair when either file changed since the last load.
+func (r *Reloader) reload() error {
+	modTime, err := r.modTime()
+	if err != nil {
+		return err
+	}
+	if r.cert != nil && modTime.Equal(r.loaded) {
+		return nil
+	}
+
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return err
+	}
+	r.cert = &cert
+	r.loaded = modTime
+	r.log.Info("tls certificate loaded", zap.String("cert_file", r.certFile))
+	return nil
+}
+
+// modTime is the latest change of the files, their links being followed
+// as mounted secrets are swapped through symlinks.
+func (r *Reloader) modTime() (time.Time, error) {
+	var latest time.Time
+	for _, path := range []string{r.certFile, r.keyFile} {
+		info, err := os.Stat(path)
+		if err != nil {
+			return time.Time{}, err
+		}
+		if info.ModTime().After(latest) {
+			latest = info.ModTime()
+		}
+	}
+	return latest, nil
+}
+
+// ServerConfig returns the TLS settings of a server presenting the pair of
+// r. With clientCAs, clients must present a certificate signed by one of
+// them, whose common name must be in allowedCNs unless empty. A server
+// relaxing ClientAuth to VerifyClientCertIfGiven still checks the names of
+// the certificates given.
+func ServerConfig(r *Reloader, clientCAs *x509.CertPool, allowedCNs []string) *tls.Config {
+	c := &tls.Config{
+		MinVersion:     tls.VersionTLS12,
+		GetCertificate: r.GetCertificate,
+	}
+	if clientCAs == nil {
+		return c
+	}
+
+	c.ClientAuth = tls.RequireAndVerifyClientCert
+	c.ClientCAs = clientCAs
+	if len(allowedCNs) > 0 {
+		c.VerifyConnection = func(cs tls.ConnectionState) error {
+			if len(cs.PeerCertificates) == 0 {
+				return nil
+			}
+			if !slices.Contains(allowedCNs, cs.PeerCertificates[0].Subject.CommonName) {
+				return ErrClientNotAllowed
+			}
+			return nil
+		}
+	}
+	return c
+}
+
+// LoadCertPool reads the PEM encoded certificates of a file.
+func LoadCertPool(path string) (*x509.CertPool, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(data) {
+		return nil, fmt.Errorf("no certificate in %s", path)
+	}
+	return pool, nil
+}
@@ -0,0 +1,123 @@
+package certs
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/jmsilvadev/tx-parser/pkg/certs/certstest"
+	"github.com/jmsilvadev/tx-parser/pkg/logger"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap/zapcore"
+)
+
+func TestReloader(t *testing.T) {
+	l := logger.New(zapcore.DebugLevel)
+	dir := t.TempDir()
+	ca := certstest.NewCA()
+	certFile, keyFile := ca.WriteFiles(dir, "first")
+
+	now := time.Now()
+	r, err := New(certFile, keyFile, l, WithInterval(time.Minute))
+	require.NoError(t, err)
+	r.now = func() time.Time { return now }
+	r.checked = now
+	assert.Equal(t, "first", commonName(t, r))
+
+	// the files are not checked before the interval
+	ca.WriteFiles(dir, "second")
+	touch(t, time.Now().Add(time.Second), certFile, keyFile)
+	assert.Equal(t, "first", commonName(t, r))
+	now = now.Add(time.Minute)
+	assert.Equal(t, "second", commonName(t, r))
+
+	// a broken pair keeps the previous one
+	require.NoError(t, os.WriteFile(keyFile, []byte("partial"), 0600))
+	touch(t, time.Now().Add(2*time.Second), certFile, keyFile)
+	now = now.Add(time.Minute)
+	assert.Equal(t, "second", commonName(t, r))
+
+	_, err = New(filepath.Join(dir, "missing.crt"), keyFile, l)
+	assert.Error(t, err)
+}
+
+func TestServerConfig(t *testing.T) {
+	l := logger.New(zapcore.DebugLevel)
+	ca := certstest.NewCA()
+	certFile, keyFile := ca.WriteFiles(t.TempDir(), "localhost")
+	r, err := New(certFile, keyFile, l)
+	require.NoError(t, err)
+
+	assert.Equal(t, tls.NoClientCert, ServerConfig(r, nil, nil).ClientAuth)
+
+	config := ServerConfig(r, ca.Pool(), []string{"dashboard"})
+	assert.Equal(t, tls.RequireAndVerifyClientCert, config.ClientAuth)
+
+	ln, err := tls.Listen("tcp", "127.0.0.1:0", config)
+	require.NoError(t, err)
+	defer ln.Close()
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			conn.(*tls.Conn).Handshake()
+			conn.Close()
+		}
+	}()
+
+	dial := func(certs ...tls.Certificate) error {
+		conn, err := tls.Dial("tcp", ln.Addr().String(), &tls.Config{RootCAs: ca.Pool(), Certificates: certs})
+		if err != nil {
+			return err
+		}
+		defer conn.Close()
+		// the client learns about a rejection on its first read
+		conn.SetReadDeadline(time.Now().Add(time.Second))
+		_, err = conn.Read(make([]byte, 1))
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		return err
+	}
+	assert.NoError(t, dial(ca.Certificate("dashboard")))
+	assert.Error(t, dial(ca.Certificate("intruder")))
+	assert.Error(t, dial(certstest.NewCA().Certificate("dashboard")))
+	assert.Error(t, dial())
+}
+
+func TestLoadCertPool(t *testing.T) {
+	dir := t.TempDir()
+	ca := certstest.NewCA()
+	path := filepath.Join(dir, "ca.crt")
+	require.NoError(t, os.WriteFile(path, ca.PEM, 0600))
+
+	pool, err := LoadCertPool(path)
+	require.NoError(t, err)
+	assert.True(t, pool.Equal(ca.Pool()))
+
+	require.NoError(t, os.WriteFile(path, []byte("nope"), 0600))
+	_, err = LoadCertPool(path)
+	assert.Error(t, err)
+}
+
+func commonName(t *testing.T, r *Reloader) string {
+	cert, err := r.GetCertificate(nil)
+	require.NoError(t, err)
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	require.NoError(t, err)
+	return leaf.Subject.CommonName
+}
+
+func touch(t *testing.T, at time.Time, paths ...string) {
+	for _, path := range paths {
+		require.NoError(t, os.Chtimes(path, at, at))
+	}
+}
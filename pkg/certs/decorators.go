@@ -0,0 +1,10 @@
+package certs
+
+import "time"
+
+// WithInterval sets how often the files are checked for changes.
+func WithInterval(v time.Duration) Option {
+	return func(r *Reloader) {
+		r.interval = v
+	}
+}
@@ -2,16 +2,33 @@ package config
 
 import (
 	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"errors"
 	"fmt"
+	"math"
+	"net"
 	"os"
+	"strconv"
 	"strings"
 	"time"
 
+	"github.com/jmsilvadev/tx-parser/pkg/apikey"
+	"github.com/jmsilvadev/tx-parser/pkg/certs"
+	"github.com/jmsilvadev/tx-parser/pkg/events"
+	"github.com/jmsilvadev/tx-parser/pkg/health"
 	"github.com/jmsilvadev/tx-parser/pkg/jsonrpc"
 	"github.com/jmsilvadev/tx-parser/pkg/logger"
+	"github.com/jmsilvadev/tx-parser/pkg/metrics"
 	"github.com/jmsilvadev/tx-parser/pkg/parser"
 	"github.com/jmsilvadev/tx-parser/pkg/parser/leveldb"
 	"github.com/jmsilvadev/tx-parser/pkg/parser/memorydb"
+	"github.com/jmsilvadev/tx-parser/pkg/publisher"
+	"github.com/jmsilvadev/tx-parser/pkg/ratelimit"
+	"github.com/jmsilvadev/tx-parser/pkg/tracing"
+	"github.com/jmsilvadev/tx-parser/pkg/webhook"
+	"go.uber.org/zap"
 )
 
 // Default Values
@@ -19,19 +36,87 @@ var (
 	parserEngine   = "leveldb"
 	dbPath         = "/tmp/parser.db"
 	serverPort     = ":5000"
+	grpcPort       = ":5001"
 	loggerLevel    = "DEBUG"
+	loggerFormat   = "json"
+	loggerOutput   = "stdout"
 	environment    = "dev"
 	timeout        = "1s"
 	defaultTimeout = time.Second
 	cliUrl         = "https://ethereum-rpc.publicnode.com"
+	cliRetries     = "3"
+	cliQuorum      = "0"
+	cliRateLimit   = "0"
+	cliBurst       = "1"
+	cliMode        = ""
+	cliFixtures    = "/tmp/jsonrpc-fixtures"
+	webhookSecret  = ""
+	webhookRetries = "8"
+	webhookNets    = ""
+	publisherUrl   = ""
+	publisherTopic = "txparser"
+	readyMaxAge    = "2m"
+	readyMaxLag    = "10"
+	otlpEndpoint   = ""
+	traceRatio     = "1"
+	corsOrigins    = ""
+	corsHeaders    = ""
+	compression    = "true"
+	accessLog      = "true"
+	readHeader     = "5s"
+	readTimeout    = "30s"
+	writeTimeout   = "30s"
+	idleTimeout    = "2m"
+	maxHeaderBytes = "1048576"
+	apiAuth        = "false"
+	apiAdminKey    = ""
+	rateLimit      = "0"
+	rateBurst      = "0"
+	rateLimits     = ""
+	dailyQuota     = "0"
+	tlsCertFile    = ""
+	tlsKeyFile     = ""
+	tlsClientCA    = ""
+	tlsAllowedCNs  = ""
+	http2          = "true"
 )
 
 type Config struct {
 	ServerPort string
+	GRPCPort   string
 	Env        string
 	Timeout    time.Duration
 	Parser     parser.Parser
 	Logger     logger.Logger
+	LogLevel   logger.Level
+	JsonRpc    jsonrpc.JsonRpcClient
+	Webhooks   *webhook.Dispatcher
+	Bus        *events.Bus
+	Publisher  *publisher.Forwarder
+	Metrics    *metrics.Metrics
+	Health     *health.Checker
+	Tracing    *tracing.Provider
+	// CORSOrigins are the origins browsers may call the API from, none
+	// when empty.
+	CORSOrigins []string
+	CORSHeaders []string
+	Compression bool
+	AccessLog   bool
+	// timeouts of the HTTP connections, zero meaning none
+	ReadHeaderTimeout time.Duration
+	ReadTimeout       time.Duration
+	WriteTimeout      time.Duration
+	IdleTimeout       time.Duration
+	MaxHeaderBytes    int
+	APIKeys           *apikey.Store
+	// requests of each client, the routes overriding RateLimit by mux
+	// pattern, and per UTC day, zero meaning no limit
+	RateLimit       ratelimit.Limit
+	RouteRateLimits map[string]ratelimit.Limit
+	DailyQuota      int
+	// TLS serves the APIs over TLS when not nil
+	TLS   *tls.Config
+	HTTP2 bool
 }
 
 func New(ctx context.Context, port, env string, duration time.Duration, p parser.Parser, logger logger.Logger) *Config {
@@ -47,10 +132,46 @@ func New(ctx context.Context, port, env string, duration time.Duration, p parser
 func GetDefaultConfig() *Config {
 	environment = getEnv("ENV", environment)
 	serverPort = getEnv("SERVER_PORT", serverPort)
+	grpcPort = getEnv("GRPC_PORT", grpcPort)
 	loggerLevel = getEnv("LOG_LEVEL", loggerLevel)
+	loggerFormat = getEnv("LOG_FORMAT", loggerFormat)
+	loggerOutput = getEnv("LOG_OUTPUT", loggerOutput)
 	dbPath = getEnv("DB_PATH", dbPath)
 	parserEngine = getEnv("PARSER_ENGINE", parserEngine)
 	cliUrl = getEnv("JSONRPC_URL", cliUrl)
+	cliRetries = getEnv("JSONRPC_RETRIES", cliRetries)
+	cliQuorum = getEnv("JSONRPC_QUORUM", cliQuorum)
+	cliMode = getEnv("JSONRPC_MODE", cliMode)
+	cliFixtures = getEnv("JSONRPC_FIXTURES", cliFixtures)
+	webhookSecret = getEnv("WEBHOOK_SECRET", webhookSecret)
+	webhookRetries = getEnv("WEBHOOK_MAX_ATTEMPTS", webhookRetries)
+	webhookNets = getEnv("WEBHOOK_ALLOWED_NETWORKS", webhookNets)
+	publisherUrl = getEnv("PUBLISHER_URL", publisherUrl)
+	publisherTopic = getEnv("PUBLISHER_SUBJECT", publisherTopic)
+	readyMaxAge = getEnv("READY_MAX_AGE", readyMaxAge)
+	readyMaxLag = getEnv("READY_MAX_LAG", readyMaxLag)
+	otlpEndpoint = getEnv("OTLP_ENDPOINT", otlpEndpoint)
+	traceRatio = getEnv("TRACING_SAMPLE_RATIO", traceRatio)
+	corsOrigins = getEnv("CORS_ALLOWED_ORIGINS", corsOrigins)
+	corsHeaders = getEnv("CORS_ALLOWED_HEADERS", corsHeaders)
+	compression = getEnv("HTTP_COMPRESSION", compression)
+	accessLog = getEnv("ACCESS_LOG", accessLog)
+	readHeader = getEnv("HTTP_READ_HEADER_TIMEOUT", readHeader)
+	readTimeout = getEnv("HTTP_READ_TIMEOUT", readTimeout)
+	writeTimeout = getEnv("HTTP_WRITE_TIMEOUT", writeTimeout)
+	idleTimeout = getEnv("HTTP_IDLE_TIMEOUT", idleTimeout)
+	maxHeaderBytes = getEnv("HTTP_MAX_HEADER_BYTES", maxHeaderBytes)
+	apiAuth = getEnv("API_AUTH", apiAuth)
+	apiAdminKey = getEnv("API_ADMIN_KEY", apiAdminKey)
+	rateLimit = getEnv("HTTP_RATE_LIMIT", rateLimit)
+	rateBurst = getEnv("HTTP_RATE_BURST", rateBurst)
+	rateLimits = getEnv("HTTP_RATE_LIMIT_ROUTES", rateLimits)
+	dailyQuota = getEnv("HTTP_DAILY_QUOTA", dailyQuota)
+	tlsCertFile = getEnv("TLS_CERT_FILE", tlsCertFile)
+	tlsKeyFile = getEnv("TLS_KEY_FILE", tlsKeyFile)
+	tlsClientCA = getEnv("TLS_CLIENT_CA_FILE", tlsClientCA)
+	tlsAllowedCNs = getEnv("TLS_ALLOWED_CNS", tlsAllowedCNs)
+	http2 = getEnv("HTTP2", http2)
 
 	timeout = getEnv("TIMEOUT", timeout)
 	duration, err := time.ParseDuration(timeout)
@@ -58,19 +179,43 @@ func GetDefaultConfig() *Config {
 		duration = defaultTimeout
 	}
 
-	level := logger.LEVEL_ERROR
-	if loggerLevel == "INFO" {
-		level = logger.LEVEL_INFO
+	log, logLevel, err := getLogger(loggerLevel, loggerFormat, loggerOutput)
+	if err != nil {
+		panic(err.Error())
 	}
-	if loggerLevel == "WARN" {
-		level = logger.LEVEL_WARN
+
+	// installed first, the clients and parsers trace through the global provider
+	tp, err := getTracing(otlpEndpoint, traceRatio, environment, log)
+	if err != nil {
+		log.Error(err.Error())
+		panic("invalid tracing")
 	}
-	if loggerLevel == "DEBUG" {
-		level = logger.LEVEL_DEBUG
+
+	retries, err := strconv.Atoi(cliRetries)
+	if err != nil {
+		log.Info("invalid JSONRPC_RETRIES, retries disabled")
+		retries = 0
 	}
-	log := logger.New(level)
 
-	db, err := getDatabase(parserEngine, dbPath, cliUrl, log)
+	quorum, err := strconv.Atoi(cliQuorum)
+	if err != nil {
+		log.Info("invalid JSONRPC_QUORUM, quorum disabled")
+		quorum = 0
+	}
+
+	m := metrics.New()
+	cli, err := getFixtureClient(cliMode, cliFixtures, log, getJsonRpcClient(splitList(cliUrl), quorum, m, log,
+		jsonrpc.WithTimeout(duration),
+		jsonrpc.WithRetries(retries),
+		jsonrpc.WithMetrics(m),
+	))
+	if err != nil {
+		log.Error(err.Error())
+		panic("invalid jsonrpc mode")
+	}
+
+	bus := events.New()
+	db, err := getDatabase(parserEngine, dbPath, cli, bus, m, log)
 	if err != nil {
 		log.Info("invalid database")
 		panic("invalid database")
@@ -78,20 +223,105 @@ func GetDefaultConfig() *Config {
 
 	ctx := context.Background()
 	config := New(ctx, serverPort, environment, duration, db, log)
+	config.GRPCPort = grpcPort
+	config.LogLevel = logLevel
+	config.CORSOrigins = splitList(corsOrigins)
+	config.CORSHeaders = splitList(corsHeaders)
+	config.Compression = getBool("HTTP_COMPRESSION", compression, true, log)
+	config.AccessLog = getBool("ACCESS_LOG", accessLog, true, log)
+	config.ReadHeaderTimeout = getDuration("HTTP_READ_HEADER_TIMEOUT", readHeader, 5*time.Second, log)
+	config.ReadTimeout = getDuration("HTTP_READ_TIMEOUT", readTimeout, 30*time.Second, log)
+	config.WriteTimeout = getDuration("HTTP_WRITE_TIMEOUT", writeTimeout, 30*time.Second, log)
+	config.IdleTimeout = getDuration("HTTP_IDLE_TIMEOUT", idleTimeout, 2*time.Minute, log)
+	config.MaxHeaderBytes, err = strconv.Atoi(maxHeaderBytes)
+	if err != nil || config.MaxHeaderBytes <= 0 {
+		log.Info("invalid HTTP_MAX_HEADER_BYTES, using 1048576")
+		config.MaxHeaderBytes = 1 << 20
+	}
+	config.RateLimit, err = parseRateLimit(rateLimit + ":" + rateBurst)
+	if err != nil {
+		log.Info("invalid HTTP_RATE_LIMIT, rate limit disabled")
+		config.RateLimit = ratelimit.Limit{}
+	}
+	config.RouteRateLimits, err = parseRateLimits(rateLimits)
+	if err != nil {
+		log.Error(err.Error())
+		panic("invalid rate limits")
+	}
+	config.DailyQuota, err = strconv.Atoi(dailyQuota)
+	if err != nil || config.DailyQuota < 0 {
+		log.Info("invalid HTTP_DAILY_QUOTA, quota disabled")
+		config.DailyQuota = 0
+	}
+	config.TLS, err = getTLS(tlsCertFile, tlsKeyFile, tlsClientCA, splitList(tlsAllowedCNs), log)
+	if err != nil {
+		log.Error(err.Error())
+		panic("invalid tls")
+	}
+	config.HTTP2 = getBool("HTTP2", http2, true, log)
+	config.JsonRpc = cli
+	config.Bus = bus
+	config.Metrics = m
+	config.Tracing = tp
+	config.Health = getHealth(readyMaxAge, readyMaxLag, db, log)
+	webhookNetworks, err := parseNetworks(webhookNets)
+	if err != nil {
+		log.Error(err.Error())
+		panic("invalid webhook networks")
+	}
+	config.Webhooks = getWebhooks(webhookSecret, webhookRetries, webhookNetworks, db, log)
+	config.APIKeys, err = getAPIKeys(getBool("API_AUTH", apiAuth, false, log), apiAdminKey, db)
+	if err != nil {
+		log.Error(err.Error())
+		panic("invalid api keys")
+	}
+	config.Publisher, err = getPublisher(publisherUrl, publisherTopic, db, log)
+	if err != nil {
+		log.Error(err.Error())
+		panic("invalid publisher")
+	}
 
 	return config
 }
 
-func getDatabase(parserEngine, dbPath, cliUrl string, l logger.Logger) (parser.Parser, error) {
+// getLogger builds the logger writing to output, stdout, stderr or a file
+// path, along with the level to change it at runtime.
+func getLogger(levelName, format, output string) (logger.Logger, logger.Level, error) {
+	level := logger.LEVEL_ERROR
+	if levelName == "INFO" {
+		level = logger.LEVEL_INFO
+	}
+	if levelName == "WARN" {
+		level = logger.LEVEL_WARN
+	}
+	if levelName == "DEBUG" {
+		level = logger.LEVEL_DEBUG
+	}
+	logLevel := logger.NewLevel(level)
+
+	format, err := logger.ParseFormat(format)
+	if err != nil {
+		return nil, logLevel, err
+	}
+	sink, _, err := zap.Open(output)
+	if err != nil {
+		return nil, logLevel, fmt.Errorf("invalid log output: %w", err)
+	}
+
+	log := logger.New(level, logger.WithLevel(logLevel), logger.WithFormat(format), logger.WithOutput(sink))
+	return log, logLevel, nil
+}
+
+func getDatabase(parserEngine, dbPath string, cli jsonrpc.JsonRpcClient, bus *events.Bus, m *metrics.Metrics, l logger.Logger) (parser.Parser, error) {
 	var (
 		p   parser.Parser
 		err error
 	)
 
-	cli := jsonrpc.NewEthereum(l, cliUrl)
-	p = memorydb.New(cli, l)
 	if strings.ToLower(parserEngine) == "leveldb" {
-		p, err = leveldb.New(dbPath, cli, l)
+		p, err = leveldb.New(dbPath, cli, l, leveldb.WithBus(bus), leveldb.WithMetrics(m))
+	} else {
+		p = memorydb.New(cli, l, memorydb.WithBus(bus), memorydb.WithMetrics(m))
 	}
 
 	if err != nil {
@@ -101,6 +331,334 @@ func getDatabase(parserEngine, dbPath, cliUrl string, l logger.Logger) (parser.P
 	return p, nil
 }
 
+// getJsonRpcClient returns a single Ethereum client, or a Pool failing over
+// between them when several endpoints are configured. With a quorum, every
+// block is cross-checked by that many providers instead.
+func getJsonRpcClient(urls []string, quorum int, m *metrics.Metrics, l logger.Logger, options ...jsonrpc.EthereumOption) jsonrpc.JsonRpcClient {
+	clients := make([]jsonrpc.JsonRpcClient, 0, len(urls))
+	for i, u := range urls {
+		opts := append([]jsonrpc.EthereumOption{}, options...)
+		opts = append(opts, getEndpointOptions(i, l)...)
+		clients = append(clients, jsonrpc.NewEthereum(l, u, opts...))
+	}
+	if quorum > 0 {
+		q := jsonrpc.NewQuorum(l, quorum, clients...)
+		m.RegisterQuorum(q.Disagreements)
+		return q
+	}
+	if len(clients) == 1 {
+		return clients[0]
+	}
+	return jsonrpc.NewPool(l, clients...)
+}
+
+// getTracing returns nil, spans being dropped, unless an OTLP endpoint is
+// configured.
+func getTracing(endpoint, ratio, env string, l logger.Logger) (*tracing.Provider, error) {
+	sampleRatio, err := strconv.ParseFloat(ratio, 64)
+	if err != nil || sampleRatio < 0 || sampleRatio > 1 {
+		l.Info("invalid TRACING_SAMPLE_RATIO, using 1")
+		sampleRatio = 1
+	}
+	return tracing.New(context.Background(), endpoint,
+		tracing.WithEnvironment(env),
+		tracing.WithSampleRatio(sampleRatio),
+	)
+}
+
+// getHealth returns the readiness checker. The store check is skipped for
+// parsers that are not a parser.Store.
+func getHealth(maxAge, maxLag string, p parser.Parser, l logger.Logger) *health.Checker {
+	age, err := time.ParseDuration(maxAge)
+	if err != nil || age <= 0 {
+		l.Info("invalid READY_MAX_AGE, using 2m")
+		age = 2 * time.Minute
+	}
+	lag, err := strconv.Atoi(maxLag)
+	if err != nil || lag < 0 {
+		l.Info("invalid READY_MAX_LAG, using 10")
+		lag = 10
+	}
+	store, _ := p.(parser.Store)
+	return health.New(store, health.WithMaxAge(age), health.WithMaxLag(lag))
+}
+
+// getAPIKeys returns nil, leaving the API open, unless authentication is
+// enabled. Unlike the other features, it fails rather than being disabled
+// when the parser cannot store the keys.
+func getAPIKeys(enabled bool, bootstrap string, p parser.Parser) (*apikey.Store, error) {
+	if !enabled {
+		return nil, nil
+	}
+	store, ok := p.(parser.Store)
+	if !ok {
+		return nil, errors.New("parser cannot store api keys")
+	}
+	return apikey.New(store, apikey.WithBootstrapKey(bootstrap)), nil
+}
+
+// getTLS returns the TLS settings of the servers, nil without a
+// certificate. The certificate and key are reloaded when their files
+// change; with a client CA, clients must present a certificate it signed,
+// with one of the allowed common names if any.
+func getTLS(certFile, keyFile, clientCA string, allowedCNs []string, l logger.Logger) (*tls.Config, error) {
+	if certFile == "" && keyFile == "" {
+		if clientCA != "" || len(allowedCNs) > 0 {
+			return nil, errors.New("TLS_CLIENT_CA_FILE and TLS_ALLOWED_CNS need TLS_CERT_FILE and TLS_KEY_FILE")
+		}
+		return nil, nil
+	}
+	if certFile == "" || keyFile == "" {
+		return nil, errors.New("TLS_CERT_FILE and TLS_KEY_FILE go together")
+	}
+	if clientCA == "" && len(allowedCNs) > 0 {
+		return nil, errors.New("TLS_ALLOWED_CNS needs TLS_CLIENT_CA_FILE")
+	}
+
+	r, err := certs.New(certFile, keyFile, l)
+	if err != nil {
+		return nil, fmt.Errorf("invalid tls certificate: %w", err)
+	}
+	var pool *x509.CertPool
+	if clientCA != "" {
+		if pool, err = certs.LoadCertPool(clientCA); err != nil {
+			return nil, fmt.Errorf("invalid tls client ca: %w", err)
+		}
+	}
+	return certs.ServerConfig(r, pool, allowedCNs), nil
+}
+
+// getWebhooks returns nil, disabling webhooks, unless a signing secret is
+// configured and the parser can persist the outbox.
+func getWebhooks(secret, maxAttempts string, allowed []*net.IPNet, p parser.Parser, l logger.Logger) *webhook.Dispatcher {
+	if secret == "" {
+		return nil
+	}
+	store, ok := p.(parser.Store)
+	if !ok {
+		l.Info("parser cannot store webhooks, webhooks disabled")
+		return nil
+	}
+	attempts, err := strconv.Atoi(maxAttempts)
+	if err != nil || attempts < 1 {
+		l.Info("invalid WEBHOOK_MAX_ATTEMPTS, using 8")
+		attempts = 8
+	}
+	return webhook.New(store, l, []byte(secret), webhook.WithMaxAttempts(attempts), webhook.WithAllowedNetworks(allowed))
+}
+
+// getPublisher returns nil, disabling the broker publisher, unless a
+// broker url is configured. The parser must persist the publish log.
+func getPublisher(rawURL, subject string, p parser.Parser, l logger.Logger) (*publisher.Forwarder, error) {
+	if rawURL == "" {
+		return nil, nil
+	}
+	store, ok := p.(parser.Store)
+	if !ok {
+		return nil, fmt.Errorf("parser cannot store the publish log")
+	}
+	nats, err := publisher.NewNATS(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	return publisher.New(store, l, nats, publisher.WithSubject(subject))
+}
+
+// getFixtureClient wraps the client to capture every call to the fixtures
+// directory in record mode, or replaces it by those fixtures in replay mode.
+func getFixtureClient(mode, dir string, l logger.Logger, cli jsonrpc.JsonRpcClient) (jsonrpc.JsonRpcClient, error) {
+	switch strings.ToLower(mode) {
+	case "":
+		return cli, nil
+	case "record":
+		l.Info("recording jsonrpc calls to " + dir)
+		return jsonrpc.NewRecorder(l, cli, dir)
+	case "replay":
+		l.Info("replaying jsonrpc calls from " + dir)
+		return jsonrpc.NewReplay(l, dir)
+	}
+	return nil, fmt.Errorf("invalid JSONRPC_MODE: %s", mode)
+}
+
+// getEndpointOptions reads the settings of the i-th JSONRPC_URL entry.
+// Each JSONRPC_<setting> can be overridden per endpoint with
+// JSONRPC_<i>_<setting>, i being the zero based position in the list.
+func getEndpointOptions(i int, l logger.Logger) []jsonrpc.EthereumOption {
+	rate, err := strconv.ParseFloat(getEndpointEnv(i, "RATE_LIMIT", cliRateLimit), 64)
+	if err != nil {
+		l.Info("invalid RATE_LIMIT, rate limit disabled", zap.Int("endpoint", i))
+		rate = 0
+	}
+	burst, err := strconv.Atoi(getEndpointEnv(i, "BURST", cliBurst))
+	if err != nil {
+		l.Info("invalid BURST, using 1", zap.Int("endpoint", i))
+		burst = 1
+	}
+
+	options := []jsonrpc.EthereumOption{
+		jsonrpc.WithRateLimit(rate, burst),
+	}
+
+	headers, err := parseHeaders(getEndpointEnv(i, "HEADERS", ""))
+	if err != nil {
+		l.Error("invalid HEADERS", zap.Int("endpoint", i), logger.Err(err))
+		panic("invalid jsonrpc headers")
+	}
+	for key, value := range headers {
+		options = append(options, jsonrpc.WithHeader(key, value))
+	}
+
+	if token := getEndpointEnv(i, "BEARER_TOKEN", ""); token != "" {
+		options = append(options, jsonrpc.WithBearerToken(token))
+	}
+
+	if basic := getEndpointEnv(i, "BASIC_AUTH", ""); basic != "" {
+		username, password, _ := strings.Cut(basic, ":")
+		options = append(options, jsonrpc.WithBasicAuth(username, password))
+	}
+
+	if path := getEndpointEnv(i, "JWT_SECRET_FILE", ""); path != "" {
+		secret, err := loadJWTSecret(path)
+		if err != nil {
+			l.Error("invalid JWT_SECRET_FILE", zap.Int("endpoint", i), logger.Err(err))
+			panic("invalid jwt secret")
+		}
+		options = append(options, jsonrpc.WithJWTSecret(secret))
+	}
+
+	return options
+}
+
+// parseHeaders reads "Name: value" pairs separated by semicolons.
+func parseHeaders(v string) (map[string]string, error) {
+	headers := make(map[string]string)
+	for _, pair := range strings.Split(v, ";") {
+		if strings.TrimSpace(pair) == "" {
+			continue
+		}
+		key, value, ok := strings.Cut(pair, ":")
+		key = strings.TrimSpace(key)
+		if !ok || key == "" {
+			return nil, errors.New("invalid header")
+		}
+		headers[key] = strings.TrimSpace(value)
+	}
+	return headers, nil
+}
+
+// loadJWTSecret reads a hex encoded secret, the format used by execution
+// clients for their jwt.hex file.
+func loadJWTSecret(path string) ([]byte, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	secret, err := hex.DecodeString(strings.TrimPrefix(strings.TrimSpace(string(data)), "0x"))
+	if err != nil {
+		return nil, errors.New("secret is not hex encoded")
+	}
+	if len(secret) < 32 {
+		return nil, errors.New("secret must be at least 32 bytes")
+	}
+	return secret, nil
+}
+
+// parseNetworks reads comma separated CIDRs, a single address being a
+// network of its own.
+func parseNetworks(v string) ([]*net.IPNet, error) {
+	var networks []*net.IPNet
+	for _, entry := range splitList(v) {
+		if ip := net.ParseIP(entry); ip != nil {
+			bits := 8 * len(ip)
+			if ip4 := ip.To4(); ip4 != nil {
+				ip, bits = ip4, 32
+			}
+			networks = append(networks, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, network, err := net.ParseCIDR(entry)
+		if err != nil {
+			return nil, fmt.Errorf("invalid network: %s", entry)
+		}
+		networks = append(networks, network)
+	}
+	return networks, nil
+}
+
+// parseRateLimits reads the limits of the routes, as comma separated
+// route=rate:burst entries, e.g. /v1/get-transactions=5:10. A zero rate
+// lifts the limit of the route.
+func parseRateLimits(v string) (map[string]ratelimit.Limit, error) {
+	limits := make(map[string]ratelimit.Limit)
+	for _, entry := range splitList(v) {
+		route, limit, ok := strings.Cut(entry, "=")
+		if !ok || !strings.HasPrefix(route, "/") {
+			return nil, fmt.Errorf("invalid rate limit: %s", entry)
+		}
+		l, err := parseRateLimit(limit)
+		if err != nil {
+			return nil, fmt.Errorf("invalid rate limit: %s", entry)
+		}
+		limits[route] = l
+	}
+	return limits, nil
+}
+
+// parseRateLimit reads rate:burst, the burst defaulting to the rate.
+func parseRateLimit(v string) (ratelimit.Limit, error) {
+	rate, burst, _ := strings.Cut(v, ":")
+	r, err := strconv.ParseFloat(rate, 64)
+	if err != nil || r < 0 {
+		return ratelimit.Limit{}, errors.New("invalid rate")
+	}
+	b := 0
+	if burst != "" {
+		if b, err = strconv.Atoi(burst); err != nil || b < 0 {
+			return ratelimit.Limit{}, errors.New("invalid burst")
+		}
+	}
+	if b == 0 {
+		b = max(1, int(math.Ceil(r)))
+	}
+	return ratelimit.Limit{Rate: r, Burst: b}, nil
+}
+
+func getEndpointEnv(i int, key, fallback string) string {
+	return getEnv(fmt.Sprintf("JSONRPC_%d_%s", i, key), getEnv("JSONRPC_"+key, fallback))
+}
+
+func splitList(v string) []string {
+	var list []string
+	for _, item := range strings.Split(v, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
+
+// getBool parses the value of the env var name, keeping fallback when it
+// is not a boolean.
+func getBool(name, v string, fallback bool, l logger.Logger) bool {
+	b, err := strconv.ParseBool(v)
+	if err != nil {
+		l.Info("invalid "+name, zap.Bool("using", fallback))
+		return fallback
+	}
+	return b
+}
+
+// getDuration parses the value of the env var name, keeping fallback when
+// it is not a duration. Zero and negative durations disable the timeout.
+func getDuration(name, v string, fallback time.Duration, l logger.Logger) time.Duration {
+	d, err := time.ParseDuration(v)
+	if err != nil {
+		l.Info("invalid "+name, zap.Duration("using", fallback))
+		return fallback
+	}
+	return max(d, 0)
+}
+
 func getEnv(key, fallback string) string {
 	if val, ok := os.LookupEnv(key); ok {
 		return val
@@ -2,17 +2,30 @@ package config
 
 import (
 	"context"
+	"crypto/tls"
+	"net"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
 	"time"
 
+	"github.com/jmsilvadev/tx-parser/pkg/apikey"
+	"github.com/jmsilvadev/tx-parser/pkg/certs/certstest"
+	"github.com/jmsilvadev/tx-parser/pkg/events"
+	"github.com/jmsilvadev/tx-parser/pkg/jsonrpc"
 	"github.com/jmsilvadev/tx-parser/pkg/logger"
+	"github.com/jmsilvadev/tx-parser/pkg/metrics"
+	"github.com/jmsilvadev/tx-parser/pkg/ratelimit"
 	"github.com/stretchr/testify/require"
 	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
 )
 
 func TestNewConfig(t *testing.T) {
 	l := logger.New(zap.DebugLevel)
-	parser, _ := getDatabase("memorydb", "", cliUrl, l)
+	parser, _ := getDatabase("memorydb", "", jsonrpc.NewEthereum(l, cliUrl), events.New(), nil, l)
 	got := New(context.Background(), ":5000", "dev", time.Second, parser, &zap.Logger{})
 	if got.ServerPort != ":5000" {
 		t.Errorf("Got and Expected are not equals. Got: %v, expected: :5000", got.ServerPort)
@@ -30,3 +43,247 @@ func TestGetEnv(t *testing.T) {
 	v := getEnv("a", "b")
 	require.Equal(t, "b", v)
 }
+
+func TestGetBool(t *testing.T) {
+	l := logger.New(zap.DebugLevel)
+	require.False(t, getBool("ACCESS_LOG", "false", true, l))
+	require.True(t, getBool("ACCESS_LOG", "1", false, l))
+	require.True(t, getBool("ACCESS_LOG", "maybe", true, l))
+}
+
+func TestGetDuration(t *testing.T) {
+	l := logger.New(zap.DebugLevel)
+	require.Equal(t, 5*time.Second, getDuration("HTTP_READ_TIMEOUT", "5s", time.Second, l))
+	require.Equal(t, time.Duration(0), getDuration("HTTP_READ_TIMEOUT", "-1s", time.Second, l))
+	require.Equal(t, time.Second, getDuration("HTTP_READ_TIMEOUT", "soon", time.Second, l))
+}
+
+func TestSplitList(t *testing.T) {
+	require.Equal(t, []string{"http://a", "http://b"}, splitList(" http://a, ,http://b "))
+	require.Empty(t, splitList(""))
+}
+
+func TestGetJsonRpcClient(t *testing.T) {
+	l := logger.New(zap.DebugLevel)
+
+	cli := getJsonRpcClient([]string{"http://a"}, 0, nil, l)
+	require.IsType(t, &jsonrpc.Ethereum{}, cli)
+
+	cli = getJsonRpcClient([]string{"http://a", "http://b"}, 0, nil, l)
+	require.IsType(t, &jsonrpc.Pool{}, cli)
+
+	m := metrics.New()
+	cli = getJsonRpcClient([]string{"http://a", "http://b", "http://c"}, 1, m, l)
+	require.IsType(t, &jsonrpc.Quorum{}, cli)
+
+	rr := httptest.NewRecorder()
+	m.Handler().ServeHTTP(rr, httptest.NewRequest("GET", "/metrics", nil))
+	require.Contains(t, rr.Body.String(), "txparser_rpc_quorum_disagreements_total 0")
+}
+
+func TestGetEndpointEnv(t *testing.T) {
+	t.Setenv("JSONRPC_RATE_LIMIT", "10")
+	t.Setenv("JSONRPC_1_RATE_LIMIT", "2")
+
+	require.Equal(t, "10", getEndpointEnv(0, "RATE_LIMIT", "0"))
+	require.Equal(t, "2", getEndpointEnv(1, "RATE_LIMIT", "0"))
+	require.Equal(t, "1", getEndpointEnv(1, "BURST", "1"))
+}
+
+func TestParseRateLimits(t *testing.T) {
+	limits, err := parseRateLimits("/v1/get-transactions=5:10, /v1/subscribe=0.5, /livez=0")
+	require.NoError(t, err)
+	require.Equal(t, map[string]ratelimit.Limit{
+		"/v1/get-transactions": {Rate: 5, Burst: 10},
+		"/v1/subscribe":        {Rate: 0.5, Burst: 1},
+		"/livez":               {Rate: 0, Burst: 1},
+	}, limits)
+
+	for _, v := range []string{"/v1/stream", "v1/stream=1", "/v1/stream=fast", "/v1/stream=1:-1"} {
+		_, err = parseRateLimits(v)
+		require.Error(t, err, v)
+	}
+}
+
+func TestParseHeaders(t *testing.T) {
+	headers, err := parseHeaders("X-Api-Key: abc; X-Other:def;")
+	require.NoError(t, err)
+	require.Equal(t, map[string]string{"X-Api-Key": "abc", "X-Other": "def"}, headers)
+
+	_, err = parseHeaders("no-separator")
+	require.Error(t, err)
+}
+
+func TestLoadJWTSecret(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "jwt.hex")
+
+	require.NoError(t, os.WriteFile(path, []byte("0x"+strings.Repeat("ab", 32)+"\n"), 0600))
+	secret, err := loadJWTSecret(path)
+	require.NoError(t, err)
+	require.Len(t, secret, 32)
+
+	require.NoError(t, os.WriteFile(path, []byte("abcd"), 0600))
+	_, err = loadJWTSecret(path)
+	require.Error(t, err)
+
+	_, err = loadJWTSecret(filepath.Join(t.TempDir(), "missing"))
+	require.Error(t, err)
+}
+
+func TestGetFixtureClient(t *testing.T) {
+	l := logger.New(zap.DebugLevel)
+	cli := jsonrpc.NewEthereum(l, cliUrl)
+
+	got, err := getFixtureClient("", "", l, cli)
+	require.NoError(t, err)
+	require.Equal(t, cli, got)
+
+	got, err = getFixtureClient("record", t.TempDir(), l, cli)
+	require.NoError(t, err)
+	require.IsType(t, &jsonrpc.Recorder{}, got)
+
+	_, err = getFixtureClient("replay", t.TempDir(), l, cli)
+	require.Error(t, err)
+
+	_, err = getFixtureClient("other", "", l, cli)
+	require.Error(t, err)
+}
+
+func TestGetWebhooks(t *testing.T) {
+	l := logger.New(zap.DebugLevel)
+	parser, _ := getDatabase("memorydb", "", jsonrpc.NewEthereum(l, cliUrl), events.New(), nil, l)
+
+	require.Nil(t, getWebhooks("", "8", nil, parser, l))
+	require.NotNil(t, getWebhooks("secret", "8", nil, parser, l))
+	require.NotNil(t, getWebhooks("secret", "invalid", nil, parser, l))
+}
+
+func TestParseNetworks(t *testing.T) {
+	networks, err := parseNetworks("")
+	require.NoError(t, err)
+	require.Empty(t, networks)
+
+	networks, err = parseNetworks("10.0.0.0/8, 192.168.1.10,fd00::/8")
+	require.NoError(t, err)
+	require.Len(t, networks, 3)
+	require.True(t, networks[0].Contains(net.ParseIP("10.1.2.3")))
+	require.True(t, networks[1].Contains(net.ParseIP("192.168.1.10")))
+	require.False(t, networks[1].Contains(net.ParseIP("192.168.1.11")))
+	require.True(t, networks[2].Contains(net.ParseIP("fd00::1")))
+
+	_, err = parseNetworks("10.0.0.0/33")
+	require.Error(t, err)
+	_, err = parseNetworks("internal")
+	require.Error(t, err)
+}
+
+func TestGetTracing(t *testing.T) {
+	l := logger.New(zap.DebugLevel)
+
+	tp, err := getTracing("", "1", "dev", l)
+	require.NoError(t, err)
+	require.Nil(t, tp)
+
+	tp, err = getTracing("http://localhost:4318", "invalid", "dev", l)
+	require.NoError(t, err)
+	require.NotNil(t, tp)
+	require.NoError(t, tp.Shutdown(context.Background()))
+}
+
+func TestGetHealth(t *testing.T) {
+	l := logger.New(zap.DebugLevel)
+	parser, _ := getDatabase("memorydb", "", jsonrpc.NewEthereum(l, cliUrl), events.New(), nil, l)
+
+	require.True(t, getHealth("2m", "10", parser, l).Ready(context.Background()).Ready)
+	require.True(t, getHealth("invalid", "-1", parser, l).Ready(context.Background()).Ready)
+}
+
+func TestGetPublisher(t *testing.T) {
+	l := logger.New(zap.DebugLevel)
+	parser, _ := getDatabase("memorydb", "", jsonrpc.NewEthereum(l, cliUrl), events.New(), nil, l)
+
+	p, err := getPublisher("", "txparser", parser, l)
+	require.NoError(t, err)
+	require.Nil(t, p)
+
+	p, err = getPublisher("nats://localhost:4222", "txparser", parser, l)
+	require.NoError(t, err)
+	require.NotNil(t, p)
+
+	_, err = getPublisher("localhost:4222", "txparser", parser, l)
+	require.Error(t, err)
+}
+
+func TestGetLogger(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "parser.log")
+
+	l, level, err := getLogger("INFO", "console", path)
+	require.NoError(t, err)
+	require.Equal(t, logger.LEVEL_INFO, level.Level())
+	l.Info("written")
+	level.SetLevel(logger.LEVEL_ERROR)
+	l.Info("dropped")
+
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	require.Contains(t, string(data), "written")
+	require.NotContains(t, string(data), "dropped")
+
+	_, _, err = getLogger("INFO", "xml", "stdout")
+	require.Error(t, err)
+
+	_, _, err = getLogger("INFO", "json", filepath.Join(t.TempDir(), "missing", "parser.log"))
+	require.Error(t, err)
+}
+
+func TestGetAPIKeys(t *testing.T) {
+	l := logger.New(zap.DebugLevel)
+	parser, _ := getDatabase("memorydb", "", jsonrpc.NewEthereum(l, cliUrl), events.New(), nil, l)
+
+	keys, err := getAPIKeys(false, "", parser)
+	require.NoError(t, err)
+	require.Nil(t, keys)
+
+	keys, err = getAPIKeys(true, "secret", parser)
+	require.NoError(t, err)
+	key, err := keys.Authenticate("secret")
+	require.NoError(t, err)
+	require.True(t, key.Has(apikey.ScopeAdmin))
+
+	_, err = getAPIKeys(true, "", nil)
+	require.Error(t, err)
+}
+
+func TestGetTLS(t *testing.T) {
+	l := logger.New(zapcore.DebugLevel)
+	dir := t.TempDir()
+	ca := certstest.NewCA()
+	certFile, keyFile := ca.WriteFiles(dir, "localhost")
+	caFile := filepath.Join(dir, "ca.crt")
+	require.NoError(t, os.WriteFile(caFile, ca.PEM, 0600))
+
+	config, err := getTLS("", "", "", nil, l)
+	require.NoError(t, err)
+	require.Nil(t, config)
+
+	config, err = getTLS(certFile, keyFile, "", nil, l)
+	require.NoError(t, err)
+	require.Equal(t, tls.NoClientCert, config.ClientAuth)
+
+	config, err = getTLS(certFile, keyFile, caFile, []string{"dashboard"}, l)
+	require.NoError(t, err)
+	require.Equal(t, tls.RequireAndVerifyClientCert, config.ClientAuth)
+	require.NotNil(t, config.VerifyConnection)
+
+	for _, args := range [][3]string{
+		{certFile, "", ""},
+		{"", "", caFile},
+		{certFile, filepath.Join(dir, "missing.key"), ""},
+		{certFile, keyFile, filepath.Join(dir, "missing.crt")},
+	} {
+		_, err = getTLS(args[0], args[1], args[2], nil, l)
+		require.Error(t, err, args)
+	}
+	_, err = getTLS(certFile, keyFile, "", []string{"dashboard"}, l)
+	require.Error(t, err)
+}
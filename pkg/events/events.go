@@ -0,0 +1,183 @@
+package events
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+
+	"github.com/jmsilvadev/tx-parser/pkg/parser"
+)
+
+type Kind string
+
+const (
+	KindBlockIngested      Kind = "block_ingested"
+	KindTransactionMatched Kind = "transaction_matched"
+	KindReorgDetected      Kind = "reorg_detected"
+	KindSubscriptionAdded  Kind = "subscription_added"
+	KindChainHeadObserved  Kind = "chain_head_observed"
+)
+
+type Event interface {
+	Kind() Kind
+}
+
+// BlockIngested is published once the parser is done with a block.
+type BlockIngested struct {
+	BlockNumber  int
+	Hash         string
+	Transactions int
+	Matched      int
+}
+
+// TransactionMatched is published for each transaction stored for a
+// subscribed address, after it has been stored.
+type TransactionMatched struct {
+	Transaction parser.Transaction
+	Addresses   []string
+}
+
+// ReorgDetected is published when a new block does not build on the last
+// ingested one.
+type ReorgDetected struct {
+	BlockNumber int
+	OldHash     string
+	NewHash     string
+}
+
+type SubscriptionAdded struct {
+	Address string
+}
+
+// ChainHeadObserved is published every time the provider reports its
+// latest block, whether the parser had it already or not.
+type ChainHeadObserved struct {
+	BlockNumber int
+}
+
+func (BlockIngested) Kind() Kind      { return KindBlockIngested }
+func (TransactionMatched) Kind() Kind { return KindTransactionMatched }
+func (ReorgDetected) Kind() Kind      { return KindReorgDetected }
+func (SubscriptionAdded) Kind() Kind  { return KindSubscriptionAdded }
+func (ChainHeadObserved) Kind() Kind  { return KindChainHeadObserved }
+
+// Policy tells the bus what to do when a subscriber buffer is full.
+type Policy int
+
+const (
+	// Drop discards the event for that subscriber, the publisher never waits.
+	Drop Policy = iota
+	// Block makes the publisher wait for room, for subscribers that must
+	// not miss events. A slow Block subscriber slows down ingestion.
+	Block
+)
+
+// Bus is an in-process publish/subscribe bus. Every subscriber has its own
+// bounded buffer. A nil Bus discards everything.
+type Bus struct {
+	mu   sync.RWMutex
+	subs map[*Subscription]struct{}
+}
+
+type Subscription struct {
+	C       <-chan Event
+	ch      chan Event
+	policy  Policy
+	kinds   map[Kind]bool
+	bus     *Bus
+	done    chan struct{}
+	once    sync.Once
+	dropped atomic.Uint64
+}
+
+func New() *Bus {
+	return &Bus{subs: make(map[*Subscription]struct{})}
+}
+
+// Subscribe receives the events of the given kinds, or every event when
+// no kind is given.
+func (b *Bus) Subscribe(buffer int, policy Policy, kinds ...Kind) *Subscription {
+	ch := make(chan Event, buffer)
+	s := &Subscription{
+		C:      ch,
+		ch:     ch,
+		policy: policy,
+		bus:    b,
+		done:   make(chan struct{}),
+	}
+	if len(kinds) > 0 {
+		s.kinds = make(map[Kind]bool)
+		for _, k := range kinds {
+			s.kinds[k] = true
+		}
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.subs[s] = struct{}{}
+	return s
+}
+
+// Publish delivers the event to every interested subscriber, following
+// their policy. Block subscribers are waited for until ctx is done.
+func (b *Bus) Publish(ctx context.Context, e Event) {
+	if b == nil {
+		return
+	}
+
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	for s := range b.subs {
+		if s.kinds != nil && !s.kinds[e.Kind()] {
+			continue
+		}
+		if s.policy == Block {
+			select {
+			case s.ch <- e:
+			case <-s.done:
+			case <-ctx.Done():
+				s.dropped.Add(1)
+			}
+			continue
+		}
+		select {
+		case s.ch <- e:
+		default:
+			s.dropped.Add(1)
+		}
+	}
+}
+
+// Close stops the subscription and closes its channel.
+func (s *Subscription) Close() {
+	s.once.Do(func() {
+		// release a publisher blocked on this subscription first
+		close(s.done)
+		s.bus.mu.Lock()
+		delete(s.bus.subs, s)
+		s.bus.mu.Unlock()
+		close(s.ch)
+	})
+}
+
+// Dropped returns how many events the subscription missed.
+func (s *Subscription) Dropped() uint64 {
+	return s.dropped.Load()
+}
+
+// Consume calls fn for every event of the subscription until ctx is done,
+// then closes the subscription.
+func Consume(ctx context.Context, s *Subscription, fn func(context.Context, Event)) {
+	defer s.Close()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case e, ok := <-s.C:
+			if !ok {
+				return
+			}
+			fn(ctx, e)
+		}
+	}
+}
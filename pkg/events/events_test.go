@@ -0,0 +1,105 @@
+package events
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/jmsilvadev/tx-parser/pkg/parser"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPublishFilters(t *testing.T) {
+	b := New()
+	all := b.Subscribe(4, Drop)
+	blocks := b.Subscribe(4, Drop, KindBlockIngested)
+
+	b.Publish(context.Background(), BlockIngested{BlockNumber: 1})
+	b.Publish(context.Background(), TransactionMatched{Transaction: parser.Transaction{Hash: "0x1"}})
+
+	assert.Len(t, all.C, 2)
+	assert.Len(t, blocks.C, 1)
+	assert.Equal(t, BlockIngested{BlockNumber: 1}, <-blocks.C)
+}
+
+func TestDropPolicy(t *testing.T) {
+	b := New()
+	s := b.Subscribe(1, Drop)
+
+	b.Publish(context.Background(), SubscriptionAdded{Address: "0x1"})
+	b.Publish(context.Background(), SubscriptionAdded{Address: "0x2"})
+
+	assert.Equal(t, uint64(1), s.Dropped())
+	assert.Equal(t, SubscriptionAdded{Address: "0x1"}, <-s.C)
+}
+
+func TestBlockPolicy(t *testing.T) {
+	b := New()
+	s := b.Subscribe(1, Block)
+
+	b.Publish(context.Background(), SubscriptionAdded{Address: "0x1"})
+
+	published := make(chan struct{})
+	go func() {
+		b.Publish(context.Background(), SubscriptionAdded{Address: "0x2"})
+		close(published)
+	}()
+
+	select {
+	case <-published:
+		t.Fatal("publisher did not wait")
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	<-s.C
+	<-published
+	assert.Equal(t, uint64(0), s.Dropped())
+
+	// a blocked publisher gives up with its context
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	b.Publish(ctx, SubscriptionAdded{Address: "0x3"})
+	assert.Equal(t, uint64(1), s.Dropped())
+}
+
+func TestCloseReleasesPublisher(t *testing.T) {
+	b := New()
+	s := b.Subscribe(0, Block)
+
+	published := make(chan struct{})
+	go func() {
+		b.Publish(context.Background(), SubscriptionAdded{Address: "0x1"})
+		close(published)
+	}()
+	time.Sleep(10 * time.Millisecond)
+
+	s.Close()
+	s.Close()
+	<-published
+	_, ok := <-s.C
+	assert.False(t, ok)
+}
+
+func TestConsume(t *testing.T) {
+	b := New()
+	s := b.Subscribe(4, Block)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	got := make(chan Event, 1)
+	done := make(chan struct{})
+	go func() {
+		Consume(ctx, s, func(ctx context.Context, e Event) { got <- e })
+		close(done)
+	}()
+
+	b.Publish(ctx, ReorgDetected{BlockNumber: 5})
+	assert.Equal(t, KindReorgDetected, (<-got).Kind())
+
+	cancel()
+	<-done
+}
+
+func TestNilBus(t *testing.T) {
+	var b *Bus
+	b.Publish(context.Background(), BlockIngested{})
+}
@@ -0,0 +1,18 @@
+package health
+
+import "time"
+
+// WithMaxAge is how long the ingestion may go without success before the
+// parser is not ready.
+func WithMaxAge(v time.Duration) Option {
+	return func(c *Checker) {
+		c.maxAge = v
+	}
+}
+
+// WithMaxLag is how many blocks the parser may be behind the chain head.
+func WithMaxLag(v int) Option {
+	return func(c *Checker) {
+		c.maxLag = v
+	}
+}
@@ -0,0 +1,166 @@
+package health
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/jmsilvadev/tx-parser/pkg/events"
+	"github.com/jmsilvadev/tx-parser/pkg/parser"
+)
+
+// Default Values
+var (
+	defaultMaxAge = 2 * time.Minute
+	defaultMaxLag = 10
+	probeKey      = "health"
+)
+
+const (
+	StatusOK      = "ok"
+	StatusFailing = "failing"
+)
+
+// Component is the state of one of the checks of the readiness probe.
+type Component struct {
+	Status  string                 `json:"status"`
+	Message string                 `json:"message,omitempty"`
+	Details map[string]interface{} `json:"details,omitempty"`
+}
+
+// Report is the outcome of the readiness probe, ready only when all of
+// its components are.
+type Report struct {
+	Ready      bool                 `json:"ready"`
+	Components map[string]Component `json:"components"`
+}
+
+// Checker follows the ingestion through the bus events and tells whether
+// the parser is fit to serve: its store can be read, it ingested recently
+// and it is not too far behind the chain head.
+type Checker struct {
+	store  parser.Store
+	maxAge time.Duration
+	maxLag int
+	now    func() time.Time
+
+	mu          sync.Mutex
+	head        int
+	current     int
+	lastSuccess time.Time
+}
+
+type Option func(*Checker)
+
+// New returns a Checker reading the store, which may be nil for parsers
+// that are not a parser.Store. The ingestion gets maxAge from now to
+// report its first block.
+func New(store parser.Store, options ...Option) *Checker {
+	c := &Checker{
+		store:  store,
+		maxAge: defaultMaxAge,
+		maxLag: defaultMaxLag,
+		now:    time.Now,
+	}
+	for _, opt := range options {
+		opt(c)
+	}
+	c.lastSuccess = c.now()
+	return c
+}
+
+// Handle records the ingestion events. An ingestion is successful when a
+// block is ingested, or when the parser is already at the chain head.
+func (c *Checker) Handle(ctx context.Context, e events.Event) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	switch e := e.(type) {
+	case events.BlockIngested:
+		c.current = e.BlockNumber
+		if e.BlockNumber > c.head {
+			c.head = e.BlockNumber
+		}
+		c.lastSuccess = c.now()
+	case events.ChainHeadObserved:
+		c.head = e.BlockNumber
+		if c.current > 0 && e.BlockNumber <= c.current {
+			c.lastSuccess = c.now()
+		}
+	}
+}
+
+// Ready runs the readiness checks.
+func (c *Checker) Ready(ctx context.Context) Report {
+	report := Report{
+		Ready: true,
+		Components: map[string]Component{
+			"ingestion": c.checkIngestion(),
+			"lag":       c.checkLag(),
+		},
+	}
+	if c.store != nil {
+		report.Components["store"] = c.checkStore()
+	}
+	for _, component := range report.Components {
+		if component.Status != StatusOK {
+			report.Ready = false
+		}
+	}
+	return report
+}
+
+// checkStore reads a key that is never written, a missing key being the
+// expected answer of a working store.
+func (c *Checker) checkStore() Component {
+	if _, err := c.store.Get(probeKey); err != nil && !errors.Is(err, parser.ErrNotFound) {
+		return Component{Status: StatusFailing, Message: err.Error()}
+	}
+	return Component{Status: StatusOK}
+}
+
+func (c *Checker) checkIngestion() Component {
+	c.mu.Lock()
+	lastSuccess := c.lastSuccess
+	c.mu.Unlock()
+
+	age := c.now().Sub(lastSuccess)
+	component := Component{
+		Status: StatusOK,
+		Details: map[string]interface{}{
+			"last_success": lastSuccess.UTC().Format(time.RFC3339),
+			"age_seconds":  int(age.Seconds()),
+		},
+	}
+	if age > c.maxAge {
+		component.Status = StatusFailing
+		component.Message = fmt.Sprintf("no successful ingestion for %s", age.Truncate(time.Second))
+	}
+	return component
+}
+
+func (c *Checker) checkLag() Component {
+	c.mu.Lock()
+	head, current := c.head, c.current
+	c.mu.Unlock()
+
+	lag := 0
+	if current > 0 && head > current {
+		lag = head - current
+	}
+	component := Component{
+		Status: StatusOK,
+		Details: map[string]interface{}{
+			"head":    head,
+			"current": current,
+			"lag":     lag,
+		},
+	}
+	if lag > c.maxLag {
+		component.Status = StatusFailing
+		component.Message = fmt.Sprintf("%d blocks behind the chain head", lag)
+	}
+	return component
+}
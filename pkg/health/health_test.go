@@ -0,0 +1,85 @@
+package health
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/jmsilvadev/tx-parser/pkg/events"
+	"github.com/jmsilvadev/tx-parser/pkg/parser"
+	"github.com/stretchr/testify/assert"
+)
+
+type fakeStore struct {
+	parser.Store
+	err error
+}
+
+func (s *fakeStore) Get(key string) ([]byte, error) {
+	if s.err != nil {
+		return nil, s.err
+	}
+	return nil, parser.ErrNotFound
+}
+
+type clock struct {
+	t time.Time
+}
+
+func (c *clock) now() time.Time {
+	return c.t
+}
+
+func newChecker(store parser.Store, options ...Option) (*Checker, *clock) {
+	clk := &clock{t: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)}
+	c := New(store, append(options, func(c *Checker) { c.now = clk.now })...)
+	return c, clk
+}
+
+func TestReady(t *testing.T) {
+	ctx := context.Background()
+	c, clk := newChecker(&fakeStore{}, WithMaxAge(time.Minute), WithMaxLag(2))
+
+	report := c.Ready(ctx)
+	assert.True(t, report.Ready, "ingestion gets maxAge to start")
+	assert.Equal(t, StatusOK, report.Components["store"].Status)
+
+	c.Handle(ctx, events.ChainHeadObserved{BlockNumber: 100})
+	c.Handle(ctx, events.BlockIngested{BlockNumber: 100})
+	clk.t = clk.t.Add(50 * time.Second)
+	c.Handle(ctx, events.ChainHeadObserved{BlockNumber: 100})
+	clk.t = clk.t.Add(50 * time.Second)
+	assert.True(t, c.Ready(ctx).Ready, "being at the head counts as a success")
+
+	c.Handle(ctx, events.ChainHeadObserved{BlockNumber: 103})
+	report = c.Ready(ctx)
+	assert.False(t, report.Ready)
+	assert.Equal(t, StatusFailing, report.Components["lag"].Status)
+	assert.Equal(t, 3, report.Components["lag"].Details["lag"])
+	assert.Equal(t, StatusOK, report.Components["ingestion"].Status)
+
+	c.Handle(ctx, events.BlockIngested{BlockNumber: 103})
+	assert.True(t, c.Ready(ctx).Ready)
+}
+
+func TestReadyStale(t *testing.T) {
+	ctx := context.Background()
+	c, clk := newChecker(nil, WithMaxAge(time.Minute))
+	c.Handle(ctx, events.BlockIngested{BlockNumber: 100})
+
+	// the provider keeps failing, nothing is observed anymore
+	clk.t = clk.t.Add(61 * time.Second)
+	report := c.Ready(ctx)
+	assert.False(t, report.Ready)
+	assert.Equal(t, StatusFailing, report.Components["ingestion"].Status)
+	assert.Equal(t, "no successful ingestion for 1m1s", report.Components["ingestion"].Message)
+	assert.NotContains(t, report.Components, "store")
+}
+
+func TestReadyStoreFailing(t *testing.T) {
+	c, _ := newChecker(&fakeStore{err: errors.New("leveldb: closed")})
+	report := c.Ready(context.Background())
+	assert.False(t, report.Ready)
+	assert.Equal(t, Component{Status: StatusFailing, Message: "leveldb: closed"}, report.Components["store"])
+}
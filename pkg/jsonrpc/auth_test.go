@@ -0,0 +1,71 @@
+package jsonrpc
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jmsilvadev/tx-parser/pkg/logger"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap/zapcore"
+)
+
+func TestNewJWT(t *testing.T) {
+	secret := []byte("0123456789abcdef0123456789abcdef")
+	token, err := newJWT(secret, time.Unix(1700000000, 0))
+	assert.NoError(t, err)
+
+	parts := strings.Split(token, ".")
+	assert.Len(t, parts, 3)
+
+	claims, err := base64.RawURLEncoding.DecodeString(parts[1])
+	assert.NoError(t, err)
+	assert.JSONEq(t, `{"iat":1700000000}`, string(claims))
+
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(parts[0] + "." + parts[1]))
+	assert.Equal(t, base64.RawURLEncoding.EncodeToString(mac.Sum(nil)), parts[2])
+}
+
+func TestAuthHeaders(t *testing.T) {
+	l := logger.New(zapcore.DebugLevel)
+
+	var got http.Header
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r.Header.Clone()
+		w.Write([]byte(`{"jsonrpc":"2.0","id":1,"result":"0x1"}`))
+	}))
+	defer srv.Close()
+
+	e := NewEthereum(l, srv.URL, WithHeader("X-Api-Key", "secret"), WithBasicAuth("user", "pass"))
+	_, err := e.GetCurrentBlockNumber(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, "secret", got.Get("X-Api-Key"))
+	assert.True(t, strings.HasPrefix(got.Get("Authorization"), "Basic "))
+
+	e = NewEthereum(l, srv.URL, WithBearerToken("token"))
+	_, err = e.GetCurrentBlockNumber(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, "Bearer token", got.Get("Authorization"))
+
+	e = NewEthereum(l, srv.URL, WithBearerToken("token"), WithJWTSecret([]byte("0123456789abcdef0123456789abcdef")))
+	_, err = e.GetCurrentBlockNumber(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, 2, strings.Count(got.Get("Authorization"), "."))
+}
+
+func TestErrorsDoNotLeakURL(t *testing.T) {
+	l := logger.New(zapcore.DebugLevel)
+
+	e := NewEthereum(l, "http://127.0.0.1:1/v3/supersecretkey", WithRetries(0))
+	_, err := e.GetCurrentBlockNumber(context.Background())
+	assert.Error(t, err)
+	assert.NotContains(t, err.Error(), "supersecretkey")
+	assert.Equal(t, "http://127.0.0.1:1", e.Endpoint())
+}
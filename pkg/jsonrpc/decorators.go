@@ -0,0 +1,87 @@
+package jsonrpc
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/jmsilvadev/tx-parser/pkg/metrics"
+	"github.com/jmsilvadev/tx-parser/pkg/ratelimit"
+)
+
+type EthereumOption func(*Ethereum)
+
+func WithHTTPClient(v *http.Client) EthereumOption {
+	return func(e *Ethereum) {
+		e.client = v
+	}
+}
+
+// WithTimeout bounds each individual attempt, retries get a fresh timeout.
+func WithTimeout(v time.Duration) EthereumOption {
+	return func(e *Ethereum) {
+		e.timeout = v
+	}
+}
+
+func WithRetries(v int) EthereumOption {
+	return func(e *Ethereum) {
+		e.retries = v
+	}
+}
+
+func WithBackoff(base, max time.Duration) EthereumOption {
+	return func(e *Ethereum) {
+		e.backoff = base
+		e.maxBackoff = max
+	}
+}
+
+// WithRateLimit caps the requests sent to the endpoint, retries included.
+// A rate of zero disables the limiter.
+func WithRateLimit(rate float64, burst int) EthereumOption {
+	return func(e *Ethereum) {
+		if rate <= 0 {
+			e.limiter = nil
+			return
+		}
+		e.limiter = ratelimit.New(rate, burst)
+	}
+}
+
+// WithHeader adds a static header, e.g. the API key header of a provider.
+func WithHeader(key, value string) EthereumOption {
+	return func(e *Ethereum) {
+		if e.auth.headers == nil {
+			e.auth.headers = make(http.Header)
+		}
+		e.auth.headers.Add(key, value)
+	}
+}
+
+func WithBearerToken(v string) EthereumOption {
+	return func(e *Ethereum) {
+		e.auth.bearerToken = v
+	}
+}
+
+func WithBasicAuth(username, password string) EthereumOption {
+	return func(e *Ethereum) {
+		e.auth.username = username
+		e.auth.password = password
+	}
+}
+
+// WithJWTSecret signs every request with a fresh HS256 token, as required
+// by the authenticated endpoints of self-hosted nodes.
+func WithJWTSecret(v []byte) EthereumOption {
+	return func(e *Ethereum) {
+		e.auth.jwtSecret = v
+	}
+}
+
+// WithMetrics records the latency and errors of every attempt, per method.
+func WithMetrics(v *metrics.Metrics) EthereumOption {
+	return func(e *Ethereum) {
+		e.metrics = v
+	}
+}
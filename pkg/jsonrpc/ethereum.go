@@ -1,115 +1,370 @@
 package jsonrpc
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"math/rand/v2"
 	"net/http"
+	"net/url"
 	"strconv"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/jmsilvadev/tx-parser/pkg/logger"
+	"github.com/jmsilvadev/tx-parser/pkg/metrics"
 	"github.com/jmsilvadev/tx-parser/pkg/parser"
+	"github.com/jmsilvadev/tx-parser/pkg/ratelimit"
+	"github.com/jmsilvadev/tx-parser/pkg/tracing"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/propagation"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+	"go.uber.org/zap"
+)
+
+// Default Values
+var (
+	defaultRetries    = 3
+	defaultBackoff    = 250 * time.Millisecond
+	defaultMaxBackoff = 10 * time.Second
+)
+
+var tracer = otel.Tracer("github.com/jmsilvadev/tx-parser/pkg/jsonrpc")
+
+var (
+	ErrBlockNotFound = errors.New("block not found")
+	ErrInvalidURL    = errors.New("invalid json-rpc url")
 )
 
 type Ethereum struct {
-	log    logger.Logger
-	cliUrl string
+	log        logger.Logger
+	cliUrl     string
+	client     *http.Client
+	timeout    time.Duration
+	retries    int
+	backoff    time.Duration
+	maxBackoff time.Duration
+	limiter    *ratelimit.Bucket
+	auth       auth
+	mu         sync.Mutex
+	calls      map[string]uint64
+	metrics    *metrics.Metrics
 }
 
 var _ JsonRpcClient = &Ethereum{}
+var _ UsageReporter = &Ethereum{}
+
+func NewEthereum(l logger.Logger, cliUrl string, options ...EthereumOption) *Ethereum {
+	e := &Ethereum{
+		log:        l,
+		cliUrl:     cliUrl,
+		client:     http.DefaultClient,
+		retries:    defaultRetries,
+		backoff:    defaultBackoff,
+		maxBackoff: defaultMaxBackoff,
+		calls:      make(map[string]uint64),
+	}
+	for _, opt := range options {
+		opt(e)
+	}
+	return e
+}
 
-func NewEthereum(l logger.Logger, cliUrl string) *Ethereum {
-	return &Ethereum{
-		log:    l,
-		cliUrl: cliUrl,
+// Endpoint identifies the provider in logs without exposing the path or
+// query, where providers usually put API keys.
+func (e *Ethereum) Endpoint() string {
+	u, err := url.Parse(e.cliUrl)
+	if err != nil || u.Host == "" {
+		return "invalid-url"
 	}
+	return u.Scheme + "://" + u.Host
 }
 
-func (e *Ethereum) GetCurrentBlockNumber(ctx context.Context) (int, error) {
-	e.log.Debug("Executing GetCurrentBlockNumber")
-	payload := `{"jsonrpc":"2.0","method":"eth_blockNumber","params":[],"id":1}`
+func (e *Ethereum) Usage() []Usage {
+	e.mu.Lock()
+	defer e.mu.Unlock()
 
-	resp, err := http.Post(e.cliUrl, "application/json", strings.NewReader(payload))
-	if err != nil {
-		e.log.Error(err.Error())
-		return 0, err
+	calls := make(map[string]uint64, len(e.calls))
+	for method, n := range e.calls {
+		calls[method] = n
 	}
-	defer resp.Body.Close()
+	return []Usage{{Endpoint: e.Endpoint(), Calls: calls}}
+}
 
-	var result map[string]interface{}
-	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
-		e.log.Error(err.Error())
+func (e *Ethereum) GetCurrentBlockNumber(ctx context.Context) (int, error) {
+	log := e.ctxLogger(ctx)
+	log.Debug("executing GetCurrentBlockNumber")
+
+	var blockHex string
+	if err := e.call(ctx, "eth_blockNumber", []interface{}{}, &blockHex); err != nil {
+		log.Error("eth_blockNumber failed", logger.Err(err))
 		return 0, err
 	}
 
-	blockHex := result["result"].(string)
-	blockNumber, err := strconv.ParseInt(blockHex[2:], 16, 64)
+	blockNumber, err := strconv.ParseInt(strings.TrimPrefix(blockHex, "0x"), 16, 64)
 	if err != nil {
-		e.log.Error(err.Error())
+		log.Error("invalid block number", zap.String("result", blockHex), logger.Err(err))
 		return 0, err
 	}
 
 	return int(blockNumber), nil
 }
 
-func (e *Ethereum) GetBlockTransactions(ctx context.Context, blockNumber int) ([]parser.Transaction, error) {
-	e.log.Debug(fmt.Sprintf("Executing GetBlockTransactions. Block: %v", blockNumber))
+func (e *Ethereum) GetBlock(ctx context.Context, blockNumber int) (*Block, error) {
+	log := e.ctxLogger(ctx)
+	log.Debug("executing GetBlock", zap.Int("block", blockNumber))
+
+	var block *struct {
+		Hash         string `json:"hash"`
+		ParentHash   string `json:"parentHash"`
+		Transactions []struct {
+			Hash  string `json:"hash"`
+			From  string `json:"from"`
+			To    string `json:"to"`
+			Value string `json:"value"`
+		} `json:"transactions"`
+	}
+	params := []interface{}{fmt.Sprintf("0x%x", blockNumber), true}
+	if err := e.call(ctx, "eth_getBlockByNumber", params, &block, attribute.Int("block.number", blockNumber)); err != nil {
+		log.Error("eth_getBlockByNumber failed", logger.Err(err))
+		return nil, err
+	}
+	if block == nil {
+		log.Error(ErrBlockNotFound.Error())
+		return nil, ErrBlockNotFound
+	}
 
-	payload := fmt.Sprintf(`{"jsonrpc":"2.0","method":"eth_getBlockByNumber","params":["0x%x", true],"id":1}`, blockNumber)
+	var transactions []parser.Transaction
+	for _, tx := range block.Transactions {
+		if tx.Hash == "" {
+			log.Error("transaction without hash")
+			continue
+		}
+
+		value := tx.Value
+		if value == "" {
+			value = "0x0"
+		}
+
+		transactions = append(transactions, parser.Transaction{
+			Hash:        tx.Hash,
+			From:        tx.From,
+			To:          tx.To,
+			Value:       value,
+			BlockNumber: blockNumber,
+		})
+	}
 
-	resp, err := http.Post(e.cliUrl, "application/json", strings.NewReader(payload))
+	return &Block{
+		Number:       blockNumber,
+		Hash:         block.Hash,
+		ParentHash:   block.ParentHash,
+		Transactions: transactions,
+	}, nil
+}
+
+func (e *Ethereum) GetBlockTransactions(ctx context.Context, blockNumber int) ([]parser.Transaction, error) {
+	block, err := e.GetBlock(ctx, blockNumber)
 	if err != nil {
-		e.log.Error(err.Error())
 		return nil, err
 	}
-	defer resp.Body.Close()
+	return block.Transactions, nil
+}
 
-	var result map[string]interface{}
-	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
-		e.log.Error(err.Error())
-		return nil, err
+// ctxLogger returns the logger of the caller, which carries its context
+// like the request or the block being ingested.
+func (e *Ethereum) ctxLogger(ctx context.Context) logger.Logger {
+	return logger.With(logger.FromContext(ctx, e.log), zap.String("endpoint", e.Endpoint()))
+}
+
+// call executes a JSON-RPC method and decodes its result into result,
+// retrying transient failures with a jittered exponential backoff, or the
+// delay the provider asks for unless longer than the maximum backoff. The
+// call is traced as a single span, attrs included, retries being events.
+func (e *Ethereum) call(ctx context.Context, method string, params []interface{}, result interface{}, attrs ...attribute.KeyValue) (err error) {
+	ctx, span := tracer.Start(ctx, "jsonrpc "+method,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			semconv.RPCSystemKey.String("jsonrpc"),
+			semconv.RPCMethod(method),
+			semconv.ServerAddress(e.Endpoint()),
+		),
+		trace.WithAttributes(attrs...),
+	)
+	defer func() {
+		if err != nil {
+			tracing.Fail(span, err)
+		}
+		span.End()
+	}()
+
+	rawParams, err := json.Marshal(params)
+	if err != nil {
+		return err
+	}
+	payload, err := json.Marshal(Request{
+		JsonRpc: Version,
+		Method:  method,
+		Params:  rawParams,
+		ID:      1,
+	})
+	if err != nil {
+		return err
 	}
 
-	block := result["result"].(map[string]interface{})
-	txs := block["transactions"].([]interface{})
+	for attempt := 0; ; attempt++ {
+		if err := e.limiter.Wait(ctx); err != nil {
+			return err
+		}
+		e.mu.Lock()
+		e.calls[method]++
+		e.mu.Unlock()
 
-	var transactions []parser.Transaction
-	for _, tx := range txs {
-		txMap := tx.(map[string]interface{})
+		start := time.Now()
+		resp, retryAfter, err := e.post(ctx, payload)
+		outcome := err
+		if err == nil && resp.Error != nil {
+			outcome = resp.Error
+		}
+		e.metrics.ObserveRPC(e.Endpoint(), method, time.Since(start), outcome)
+		if err == nil {
+			if resp.Error != nil {
+				return resp.Error
+			}
+			return json.Unmarshal(resp.Result, result)
+		}
 
-		hash, ok := txMap["hash"].(string)
-		if !ok {
-			e.log.Error(fmt.Sprintf("%s", txMap["hash"]))
-			continue
+		var retryable *retryableError
+		if !errors.As(err, &retryable) || attempt >= e.retries {
+			return err
 		}
 
-		from, ok := txMap["from"].(string)
-		if !ok {
-			e.log.Error(fmt.Sprintf("%s", txMap["from"]))
-			from = ""
+		if retryAfter > e.maxBackoff {
+			// waiting that long would stall the ingestion, failing lets a
+			// pool or a quorum turn to the other providers
+			return fmt.Errorf("%w: retry after %s exceeds %s", err, retryAfter, e.maxBackoff)
+		}
+		wait := retryAfter
+		if wait <= 0 {
+			wait = e.backoffFor(attempt)
 		}
+		e.ctxLogger(ctx).Warn("call failed, retrying",
+			zap.String("method", method),
+			zap.Duration("wait", wait),
+			zap.Int("attempt", attempt+1),
+			zap.Int("retries", e.retries),
+			logger.Err(err),
+		)
+		span.AddEvent("retry", trace.WithAttributes(
+			attribute.Int("attempt", attempt+1),
+			attribute.String("error", err.Error()),
+		))
 
-		to, ok := txMap["to"].(string)
-		if !ok {
-			e.log.Error(fmt.Sprintf("%s", txMap["to"]))
-			to = ""
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(wait):
 		}
+	}
+}
 
-		value, ok := txMap["value"].(string)
-		if !ok {
-			e.log.Error(fmt.Sprintf("%s", txMap["value"]))
-			value = "0x0"
+// post sends a single attempt. Transient failures are wrapped in a
+// retryableError, along with the delay requested by the server, if any.
+func (e *Ethereum) post(ctx context.Context, payload []byte) (*Response, time.Duration, error) {
+	attemptCtx := ctx
+	if e.timeout > 0 {
+		var cancel context.CancelFunc
+		attemptCtx, cancel = context.WithTimeout(ctx, e.timeout)
+		defer cancel()
+	}
+
+	req, err := http.NewRequestWithContext(attemptCtx, http.MethodPost, e.cliUrl, bytes.NewReader(payload))
+	if err != nil {
+		return nil, 0, ErrInvalidURL
+	}
+	req.Header.Set("Content-Type", "application/json")
+	otel.GetTextMapPropagator().Inject(ctx, propagation.HeaderCarrier(req.Header))
+	if err := e.auth.apply(req); err != nil {
+		return nil, 0, err
+	}
+
+	resp, err := e.client.Do(req)
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, 0, ctx.Err()
+		}
+		// the url may embed an API key, keep it out of the logs
+		var urlErr *url.Error
+		if errors.As(err, &urlErr) {
+			urlErr.URL = e.Endpoint()
 		}
+		return nil, 0, &retryableError{err}
+	}
+	defer resp.Body.Close()
 
-		transactions = append(transactions, parser.Transaction{
-			Hash:        hash,
-			From:        from,
-			To:          to,
-			Value:       value,
-			BlockNumber: blockNumber,
-		})
+	if resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= http.StatusInternalServerError {
+		io.Copy(io.Discard, resp.Body)
+		err := fmt.Errorf("unexpected status code: %d", resp.StatusCode)
+		return nil, parseRetryAfter(resp.Header.Get("Retry-After")), &retryableError{err}
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, 0, fmt.Errorf("unexpected status code: %d", resp.StatusCode)
+	}
+
+	var result Response
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		if ctx.Err() == nil && attemptCtx.Err() != nil {
+			return nil, 0, &retryableError{err}
+		}
+		return nil, 0, err
+	}
+
+	return &result, 0, nil
+}
+
+// backoffFor returns the exponential delay for the given attempt with
+// equal jitter, so concurrent clients do not retry in lockstep.
+func (e *Ethereum) backoffFor(attempt int) time.Duration {
+	d := e.backoff << attempt
+	if d <= 0 || d > e.maxBackoff {
+		d = e.maxBackoff
 	}
+	if d <= 0 {
+		return 0
+	}
+	half := d / 2
+	return half + rand.N(half+1)
+}
+
+// parseRetryAfter understands both forms of the header: delay in seconds
+// and HTTP date.
+func parseRetryAfter(v string) time.Duration {
+	if v == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(v); err == nil {
+		return time.Duration(seconds) * time.Second
+	}
+	if t, err := http.ParseTime(v); err == nil {
+		return time.Until(t)
+	}
+	return 0
+}
+
+type retryableError struct {
+	err error
+}
+
+func (e *retryableError) Error() string {
+	return e.err.Error()
+}
 
-	return transactions, nil
+func (e *retryableError) Unwrap() error {
+	return e.err
 }
@@ -2,31 +2,273 @@ package jsonrpc
 
 import (
 	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
 	"testing"
+	"time"
 
+	"github.com/jmsilvadev/tx-parser/pkg/jsonrpc/jsonrpctest"
 	"github.com/jmsilvadev/tx-parser/pkg/logger"
+	"github.com/jmsilvadev/tx-parser/pkg/metrics"
 	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace/noop"
 	"go.uber.org/zap/zapcore"
 )
 
-var curBlock = 0
-var cliUrl = "https://ethereum-rpc.publicnode.com"
-
 func TestGetCurrentBlockNumber(t *testing.T) {
 	l := logger.New(zapcore.DebugLevel)
+	node := jsonrpctest.NewServer(jsonrpctest.DefaultChain())
+	defer node.Close()
 
-	e := NewEthereum(l, cliUrl)
+	e := NewEthereum(l, node.URL)
 	blockNumber, err := e.GetCurrentBlockNumber(context.Background())
 	assert.NoError(t, err)
-	assert.Greater(t, blockNumber, 0)
-
-	curBlock = blockNumber
+	assert.Equal(t, 104, blockNumber)
 }
 
 func TestGetBlockTransactions(t *testing.T) {
 	l := logger.New(zapcore.DebugLevel)
-	e := NewEthereum(l, cliUrl)
-	transactions, err := e.GetBlockTransactions(context.Background(), curBlock)
+	node := jsonrpctest.NewServer(jsonrpctest.DefaultChain())
+	defer node.Close()
+
+	e := NewEthereum(l, node.URL)
+	transactions, err := e.GetBlockTransactions(context.Background(), 101)
+	assert.NoError(t, err)
+	assert.Len(t, transactions, 2)
+	assert.Equal(t, 101, transactions[0].BlockNumber)
+	assert.Equal(t, "", transactions[1].To)
+}
+
+func TestGetBlock(t *testing.T) {
+	l := logger.New(zapcore.DebugLevel)
+	chain := jsonrpctest.DefaultChain()
+	node := jsonrpctest.NewServer(chain)
+	defer node.Close()
+
+	e := NewEthereum(l, node.URL)
+	block, err := e.GetBlock(context.Background(), 103)
+	assert.NoError(t, err)
+	assert.Equal(t, chain.Block(103).Hash, block.Hash)
+	assert.Equal(t, chain.Block(102).Hash, block.ParentHash)
+}
+
+func TestRetryOnRateLimitedNode(t *testing.T) {
+	l := logger.New(zapcore.DebugLevel)
+	node := jsonrpctest.NewServer(jsonrpctest.DefaultChain())
+	defer node.Close()
+
+	node.FailHTTP(2, http.StatusServiceUnavailable)
+	e := NewEthereum(l, node.URL, WithBackoff(time.Millisecond, 10*time.Millisecond))
+	_, err := e.GetBlockTransactions(context.Background(), 100)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, node.Calls("eth_getBlockByNumber"))
+}
+
+func TestRetryOnTooManyRequests(t *testing.T) {
+	l := logger.New(zapcore.DebugLevel)
+
+	var calls int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&calls, 1) == 1 {
+			w.Header().Set("Retry-After", "0")
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		w.Write([]byte(`{"jsonrpc":"2.0","id":1,"result":"0x10"}`))
+	}))
+	defer srv.Close()
+
+	e := NewEthereum(l, srv.URL, WithBackoff(time.Millisecond, 10*time.Millisecond))
+	blockNumber, err := e.GetCurrentBlockNumber(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, 16, blockNumber)
+	assert.Equal(t, int32(2), atomic.LoadInt32(&calls))
+}
+
+func TestRetryAfterTooLong(t *testing.T) {
+	l := logger.New(zapcore.DebugLevel)
+
+	var calls int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		w.Header().Set("Retry-After", "3600")
+		w.WriteHeader(http.StatusTooManyRequests)
+	}))
+	defer srv.Close()
+
+	e := NewEthereum(l, srv.URL, WithRetries(3), WithBackoff(time.Millisecond, 10*time.Millisecond))
+	start := time.Now()
+	_, err := e.GetCurrentBlockNumber(context.Background())
+	assert.ErrorContains(t, err, "429")
+	assert.Less(t, time.Since(start), time.Second)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&calls))
+}
+
+func TestRetriesExhausted(t *testing.T) {
+	l := logger.New(zapcore.DebugLevel)
+
+	var calls int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer srv.Close()
+
+	e := NewEthereum(l, srv.URL, WithRetries(2), WithBackoff(time.Millisecond, 10*time.Millisecond))
+	_, err := e.GetCurrentBlockNumber(context.Background())
+	assert.Error(t, err)
+	assert.Equal(t, int32(3), atomic.LoadInt32(&calls))
+}
+
+func TestNoRetryOnRPCError(t *testing.T) {
+	l := logger.New(zapcore.DebugLevel)
+
+	var calls int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		w.Write([]byte(`{"jsonrpc":"2.0","id":1,"error":{"code":-32601,"message":"method not found"}}`))
+	}))
+	defer srv.Close()
+
+	e := NewEthereum(l, srv.URL)
+	_, err := e.GetCurrentBlockNumber(context.Background())
+	var rpcErr *Error
+	assert.ErrorAs(t, err, &rpcErr)
+	assert.Equal(t, -32601, rpcErr.Code)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&calls))
+}
+
+func TestTimeoutAndContext(t *testing.T) {
+	l := logger.New(zapcore.DebugLevel)
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-time.After(time.Second):
+		}
+	}))
+	defer srv.Close()
+
+	e := NewEthereum(l, srv.URL, WithTimeout(10*time.Millisecond), WithRetries(1), WithBackoff(time.Millisecond, time.Millisecond))
+	start := time.Now()
+	_, err := e.GetCurrentBlockNumber(context.Background())
+	assert.Error(t, err)
+	assert.Less(t, time.Since(start), 500*time.Millisecond)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	e = NewEthereum(l, srv.URL)
+	_, err = e.GetCurrentBlockNumber(ctx)
+	assert.ErrorIs(t, err, context.Canceled)
+}
+
+func TestBlockNotFound(t *testing.T) {
+	l := logger.New(zapcore.DebugLevel)
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"jsonrpc":"2.0","id":1,"result":null}`))
+	}))
+	defer srv.Close()
+
+	e := NewEthereum(l, srv.URL)
+	_, err := e.GetBlockTransactions(context.Background(), 1)
+	assert.ErrorIs(t, err, ErrBlockNotFound)
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	assert.Equal(t, 2*time.Second, parseRetryAfter("2"))
+	assert.Equal(t, time.Duration(0), parseRetryAfter(""))
+	assert.Equal(t, time.Duration(0), parseRetryAfter("soon"))
+	assert.Greater(t, parseRetryAfter(time.Now().Add(time.Minute).UTC().Format(http.TimeFormat)), 50*time.Second)
+}
+
+func TestRateLimitAndUsage(t *testing.T) {
+	l := logger.New(zapcore.DebugLevel)
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"jsonrpc":"2.0","id":1,"result":"0x10"}`))
+	}))
+	defer srv.Close()
+
+	e := NewEthereum(l, srv.URL, WithRateLimit(20, 1))
+	start := time.Now()
+	for i := 0; i < 3; i++ {
+		_, err := e.GetCurrentBlockNumber(context.Background())
+		assert.NoError(t, err)
+	}
+	assert.GreaterOrEqual(t, time.Since(start), 90*time.Millisecond)
+
+	usage := e.Usage()
+	assert.Len(t, usage, 1)
+	assert.Equal(t, srv.URL, usage[0].Endpoint)
+	assert.Equal(t, uint64(3), usage[0].Calls["eth_blockNumber"])
+}
+
+func TestMetrics(t *testing.T) {
+	l := logger.New(zapcore.DebugLevel)
+
+	var calls int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&calls, 1) == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte(`{"jsonrpc":"2.0","id":1,"result":"0x10"}`))
+	}))
+	defer srv.Close()
+
+	m := metrics.New()
+	e := NewEthereum(l, srv.URL, WithBackoff(time.Millisecond, time.Millisecond), WithMetrics(m))
+	_, err := e.GetCurrentBlockNumber(context.Background())
 	assert.NoError(t, err)
-	assert.Greater(t, len(transactions), 0)
+
+	// each attempt is observed, the failed one counted as an error
+	rr := httptest.NewRecorder()
+	m.Handler().ServeHTTP(rr, httptest.NewRequest("GET", "/metrics", nil))
+	body := rr.Body.String()
+	assert.Contains(t, body, `txparser_jsonrpc_request_duration_seconds_count{endpoint="`+e.Endpoint()+`",method="eth_blockNumber"} 2`)
+	assert.Contains(t, body, `txparser_jsonrpc_errors_total{endpoint="`+e.Endpoint()+`",method="eth_blockNumber"} 1`)
+}
+
+func TestTracing(t *testing.T) {
+	l := logger.New(zapcore.DebugLevel)
+	recorder := tracetest.NewSpanRecorder()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	defer otel.SetTracerProvider(noop.NewTracerProvider())
+
+	var traceparent atomic.Value
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		traceparent.Store(r.Header.Get("traceparent"))
+		w.Write([]byte(`{"jsonrpc":"2.0","id":1,"result":null}`))
+	}))
+	defer srv.Close()
+
+	e := NewEthereum(l, srv.URL, WithRetries(1), WithBackoff(time.Millisecond, time.Millisecond))
+	_, err := e.GetBlock(context.Background(), 104)
+	assert.ErrorIs(t, err, ErrBlockNotFound)
+
+	spans := recorder.Ended()
+	assert.Len(t, spans, 1)
+	span := spans[0]
+	assert.Equal(t, "jsonrpc eth_getBlockByNumber", span.Name())
+	assert.Contains(t, span.Attributes(), attribute.String("rpc.method", "eth_getBlockByNumber"))
+	assert.Contains(t, span.Attributes(), attribute.Int("block.number", 104))
+	// the provider gets the trace context of the call
+	assert.Contains(t, traceparent.Load(), span.SpanContext().TraceID().String())
+
+	srv.Close()
+	_, err = e.GetCurrentBlockNumber(context.Background())
+	assert.Error(t, err)
+	spans = recorder.Ended()
+	assert.Len(t, spans, 2)
+	assert.Equal(t, codes.Error, spans[1].Status().Code)
+	assert.Len(t, spans[1].Events(), 2, "one retry and the error")
 }
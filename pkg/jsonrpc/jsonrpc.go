@@ -2,11 +2,72 @@ package jsonrpc
 
 import (
 	"context"
+	"encoding/json"
+	"fmt"
 
 	"github.com/jmsilvadev/tx-parser/pkg/parser"
 )
 
+const Version = "2.0"
+
+// Standard JSON-RPC 2.0 error codes.
+const (
+	CodeParseError     = -32700
+	CodeInvalidRequest = -32600
+	CodeMethodNotFound = -32601
+	CodeInvalidParams  = -32602
+	CodeInternalError  = -32603
+)
+
 type JsonRpcClient interface {
 	GetCurrentBlockNumber(context.Context) (int, error)
+	GetBlock(context.Context, int) (*Block, error)
 	GetBlockTransactions(context.Context, int) ([]parser.Transaction, error)
 }
+
+// Block is the subset of a block the parser works with.
+type Block struct {
+	Number       int                  `json:"number"`
+	Hash         string               `json:"hash"`
+	ParentHash   string               `json:"parentHash"`
+	Transactions []parser.Transaction `json:"transactions"`
+}
+
+// UsageReporter is implemented by clients that account for the calls they
+// send to their providers.
+type UsageReporter interface {
+	Usage() []Usage
+}
+
+// Usage is the number of calls sent to an endpoint, per JSON-RPC method.
+type Usage struct {
+	Endpoint string            `json:"endpoint"`
+	Calls    map[string]uint64 `json:"calls"`
+}
+
+// Request is a JSON-RPC 2.0 request object.
+type Request struct {
+	JsonRpc string          `json:"jsonrpc"`
+	Method  string          `json:"method"`
+	Params  json.RawMessage `json:"params,omitempty"`
+	ID      interface{}     `json:"id,omitempty"`
+}
+
+// Response is a JSON-RPC 2.0 response object.
+type Response struct {
+	JsonRpc string          `json:"jsonrpc"`
+	Result  json.RawMessage `json:"result,omitempty"`
+	Error   *Error          `json:"error,omitempty"`
+	ID      interface{}     `json:"id"`
+}
+
+// Error is the error object of a JSON-RPC 2.0 response.
+type Error struct {
+	Code    int         `json:"code"`
+	Message string      `json:"message"`
+	Data    interface{} `json:"data,omitempty"`
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("jsonrpc error %d: %s", e.Code, e.Message)
+}
@@ -0,0 +1,137 @@
+package jsonrpctest
+
+import (
+	"crypto/sha256"
+	"embed"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+)
+
+//go:embed fixtures/*.json
+var fixtures embed.FS
+
+// Chain is a scripted chain, blocks are ordered by number without gaps.
+type Chain struct {
+	Blocks []*Block `json:"blocks"`
+}
+
+type Block struct {
+	Number       int            `json:"number"`
+	Hash         string         `json:"hash"`
+	ParentHash   string         `json:"parentHash"`
+	Timestamp    int64          `json:"timestamp"`
+	Transactions []*Transaction `json:"transactions"`
+}
+
+type Transaction struct {
+	Hash    string `json:"hash"`
+	From    string `json:"from"`
+	To      string `json:"to"`
+	Value   string `json:"value"`
+	Status  string `json:"status,omitempty"`
+	GasUsed string `json:"gasUsed,omitempty"`
+	Logs    []*Log `json:"logs,omitempty"`
+}
+
+type Log struct {
+	Address string   `json:"address"`
+	Topics  []string `json:"topics"`
+	Data    string   `json:"data"`
+}
+
+// DefaultChain returns a fresh copy of the chain in fixtures/chain.json.
+func DefaultChain() *Chain {
+	data, err := fixtures.ReadFile("fixtures/chain.json")
+	if err != nil {
+		panic(err)
+	}
+	chain, err := parseChain(data)
+	if err != nil {
+		panic(err)
+	}
+	return chain
+}
+
+// LoadChain reads a chain fixture from disk.
+func LoadChain(path string) (*Chain, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return parseChain(data)
+}
+
+func parseChain(data []byte) (*Chain, error) {
+	var chain Chain
+	if err := json.Unmarshal(data, &chain); err != nil {
+		return nil, err
+	}
+	for i, b := range chain.Blocks {
+		if i > 0 && b.Number != chain.Blocks[i-1].Number+1 {
+			return nil, fmt.Errorf("block %d does not follow block %d", b.Number, chain.Blocks[i-1].Number)
+		}
+	}
+	return &chain, nil
+}
+
+// Head returns the last block, or nil for an empty chain.
+func (c *Chain) Head() *Block {
+	if len(c.Blocks) == 0 {
+		return nil
+	}
+	return c.Blocks[len(c.Blocks)-1]
+}
+
+// Block returns the block with the given number, or nil.
+func (c *Chain) Block(number int) *Block {
+	if len(c.Blocks) == 0 {
+		return nil
+	}
+	i := number - c.Blocks[0].Number
+	if i < 0 || i >= len(c.Blocks) {
+		return nil
+	}
+	return c.Blocks[i]
+}
+
+// Mine appends a block holding the given transactions.
+func (c *Chain) Mine(txs ...*Transaction) *Block {
+	b := &Block{Transactions: txs}
+	if head := c.Head(); head != nil {
+		b.Number = head.Number + 1
+		b.ParentHash = head.Hash
+		b.Timestamp = head.Timestamp + 12
+	}
+	b.Hash = hash("block", b.ParentHash, fmt.Sprint(b.Number))
+	c.Blocks = append(c.Blocks, b)
+	return b
+}
+
+// Reorg replaces the last depth blocks with blocks of the same numbers but
+// different hashes. Transactions are kept unless dropTxs is set.
+func (c *Chain) Reorg(depth int, dropTxs bool) {
+	if depth > len(c.Blocks) {
+		depth = len(c.Blocks)
+	}
+	start := len(c.Blocks) - depth
+	for i := start; i < len(c.Blocks); i++ {
+		b := c.Blocks[i]
+		if i > 0 {
+			b.ParentHash = c.Blocks[i-1].Hash
+		}
+		b.Hash = hash("reorg", b.Hash, b.ParentHash)
+		if dropTxs {
+			b.Transactions = nil
+		}
+	}
+}
+
+func hash(parts ...string) string {
+	h := sha256.New()
+	for _, p := range parts {
+		h.Write([]byte(p))
+	}
+	return "0x" + hex.EncodeToString(h.Sum(nil))
+}
@@ -0,0 +1,388 @@
+// Package jsonrpctest provides a fake Ethereum JSON-RPC node for tests.
+//
+// The node serves a scripted Chain and can be told to fail, slow down,
+// throttle or reorg, so ingestion can be tested deterministically and
+// offline:
+//
+//	node := jsonrpctest.NewServer(jsonrpctest.DefaultChain())
+//	defer node.Close()
+//	cli := jsonrpc.NewEthereum(l, node.URL)
+package jsonrpctest
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jmsilvadev/tx-parser/pkg/ratelimit"
+)
+
+// JSON-RPC error codes
+const (
+	CodeParseError     = -32700
+	CodeInvalidRequest = -32600
+	CodeMethodNotFound = -32601
+	CodeInvalidParams  = -32602
+	CodeInternalError  = -32603
+)
+
+// The wire types are declared here rather than taken from pkg/jsonrpc, so
+// the tests of pkg/jsonrpc itself can use this package.
+type request struct {
+	JsonRpc string          `json:"jsonrpc"`
+	Method  string          `json:"method"`
+	Params  json.RawMessage `json:"params,omitempty"`
+	ID      interface{}     `json:"id,omitempty"`
+}
+
+type response struct {
+	JsonRpc string          `json:"jsonrpc"`
+	Result  json.RawMessage `json:"result,omitempty"`
+	Error   *rpcError       `json:"error,omitempty"`
+	ID      interface{}     `json:"id"`
+}
+
+type rpcError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+type Server struct {
+	*httptest.Server
+
+	mu       sync.Mutex
+	chain    *Chain
+	latency  time.Duration
+	limiter  *ratelimit.Bucket
+	failures []failure
+	calls    map[string]int
+}
+
+// failure is a scripted answer: an HTTP status, or a JSON-RPC error when
+// status is zero.
+type failure struct {
+	status int
+	err    *rpcError
+}
+
+// NewServer starts a node serving the chain. The chain is shared with the
+// caller, so it can be mined or reorged while the node runs, through the
+// Server methods that take the node lock.
+func NewServer(chain *Chain) *Server {
+	s := &Server{
+		chain: chain,
+		calls: make(map[string]int),
+	}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.serveHTTP))
+	return s
+}
+
+// Mine appends a block to the chain.
+func (s *Server) Mine(txs ...*Transaction) *Block {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.chain.Mine(txs...)
+}
+
+// Reorg replaces the last depth blocks of the chain, see Chain.Reorg.
+func (s *Server) Reorg(depth int, dropTxs bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.chain.Reorg(depth, dropTxs)
+}
+
+// SetLatency delays every answer.
+func (s *Server) SetLatency(d time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.latency = d
+}
+
+// SetRateLimit answers 429 with a Retry-After header above the given rate.
+// A rate of zero removes the limit.
+func (s *Server) SetRateLimit(rate float64, burst int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.limiter = nil
+	if rate > 0 {
+		s.limiter = ratelimit.New(rate, burst)
+	}
+}
+
+// FailHTTP answers the next n requests with the given HTTP status.
+func (s *Server) FailHTTP(n, status int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i := 0; i < n; i++ {
+		s.failures = append(s.failures, failure{status: status})
+	}
+}
+
+// FailRPC answers the next n requests with a JSON-RPC error.
+func (s *Server) FailRPC(n, code int, message string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i := 0; i < n; i++ {
+		s.failures = append(s.failures, failure{err: &rpcError{Code: code, Message: message}})
+	}
+}
+
+// Calls returns how many times a method was requested.
+func (s *Server) Calls(method string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.calls[method]
+}
+
+func (s *Server) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	latency := s.latency
+	limited := !s.limiter.Allow()
+	var fail *failure
+	if !limited && len(s.failures) > 0 {
+		fail = &s.failures[0]
+		s.failures = s.failures[1:]
+	}
+	s.mu.Unlock()
+
+	if latency > 0 {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-time.After(latency):
+		}
+	}
+
+	if limited {
+		w.Header().Set("Retry-After", "1")
+		w.WriteHeader(http.StatusTooManyRequests)
+		return
+	}
+	if fail != nil && fail.status != 0 {
+		w.WriteHeader(fail.status)
+		return
+	}
+
+	var req request
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeResponse(w, response{JsonRpc: "2.0", Error: &rpcError{Code: CodeParseError, Message: "parse error"}})
+		return
+	}
+
+	s.mu.Lock()
+	s.calls[req.Method]++
+	s.mu.Unlock()
+
+	resp := response{JsonRpc: "2.0", ID: req.ID}
+	if fail != nil {
+		resp.Error = fail.err
+		writeResponse(w, resp)
+		return
+	}
+
+	result, rpcErr := s.dispatch(req)
+	if rpcErr != nil {
+		resp.Error = rpcErr
+	} else {
+		resp.Result, _ = json.Marshal(result)
+	}
+	writeResponse(w, resp)
+}
+
+func (s *Server) dispatch(req request) (interface{}, *rpcError) {
+	var params []json.RawMessage
+	if len(req.Params) > 0 {
+		if err := json.Unmarshal(req.Params, &params); err != nil {
+			return nil, &rpcError{Code: CodeInvalidParams, Message: "invalid params"}
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	switch req.Method {
+	case "eth_blockNumber":
+		head := s.chain.Head()
+		if head == nil {
+			return "0x0", nil
+		}
+		return toHex(head.Number), nil
+	case "eth_getBlockByNumber":
+		if len(params) < 1 {
+			return nil, &rpcError{Code: CodeInvalidParams, Message: "missing block number"}
+		}
+		block, err := s.blockParam(params[0])
+		if err != nil {
+			return nil, err
+		}
+		full := false
+		if len(params) > 1 {
+			json.Unmarshal(params[1], &full)
+		}
+		if block == nil {
+			return nil, nil
+		}
+		return encodeBlock(block, full), nil
+	case "eth_getTransactionReceipt":
+		var txHash string
+		if len(params) < 1 || json.Unmarshal(params[0], &txHash) != nil {
+			return nil, &rpcError{Code: CodeInvalidParams, Message: "missing transaction hash"}
+		}
+		for _, b := range s.chain.Blocks {
+			for i, tx := range b.Transactions {
+				if strings.EqualFold(tx.Hash, txHash) {
+					return encodeReceipt(b, i, tx), nil
+				}
+			}
+		}
+		return nil, nil
+	case "eth_getLogs":
+		var filter struct {
+			FromBlock json.RawMessage `json:"fromBlock"`
+			ToBlock   json.RawMessage `json:"toBlock"`
+			Address   string          `json:"address"`
+		}
+		if len(params) < 1 || json.Unmarshal(params[0], &filter) != nil {
+			return nil, &rpcError{Code: CodeInvalidParams, Message: "invalid filter"}
+		}
+		return s.logs(filter.FromBlock, filter.ToBlock, filter.Address)
+	}
+
+	return nil, &rpcError{Code: CodeMethodNotFound, Message: fmt.Sprintf("the method %s does not exist/is not available", req.Method)}
+}
+
+func (s *Server) logs(fromParam, toParam json.RawMessage, address string) (interface{}, *rpcError) {
+	from, to := s.chain.Head(), s.chain.Head()
+	if len(fromParam) > 0 {
+		b, err := s.blockParam(fromParam)
+		if err != nil {
+			return nil, err
+		}
+		from = b
+	}
+	if len(toParam) > 0 {
+		b, err := s.blockParam(toParam)
+		if err != nil {
+			return nil, err
+		}
+		to = b
+	}
+
+	logs := []map[string]interface{}{}
+	if from == nil || to == nil {
+		return logs, nil
+	}
+	for n := from.Number; n <= to.Number; n++ {
+		b := s.chain.Block(n)
+		for i, tx := range b.Transactions {
+			for _, l := range encodeReceipt(b, i, tx)["logs"].([]map[string]interface{}) {
+				if address == "" || strings.EqualFold(address, l["address"].(string)) {
+					logs = append(logs, l)
+				}
+			}
+		}
+	}
+	return logs, nil
+}
+
+// blockParam resolves a block tag or hex number. Unknown numbers resolve
+// to nil, like a real node.
+func (s *Server) blockParam(raw json.RawMessage) (*Block, *rpcError) {
+	var tag string
+	if err := json.Unmarshal(raw, &tag); err != nil {
+		return nil, &rpcError{Code: CodeInvalidParams, Message: "invalid block number"}
+	}
+	switch tag {
+	case "latest", "safe", "finalized", "pending":
+		return s.chain.Head(), nil
+	case "earliest":
+		if len(s.chain.Blocks) == 0 {
+			return nil, nil
+		}
+		return s.chain.Blocks[0], nil
+	}
+	n, err := strconv.ParseInt(strings.TrimPrefix(tag, "0x"), 16, 64)
+	if err != nil {
+		return nil, &rpcError{Code: CodeInvalidParams, Message: "invalid block number"}
+	}
+	return s.chain.Block(int(n)), nil
+}
+
+func encodeBlock(b *Block, full bool) map[string]interface{} {
+	txs := make([]interface{}, 0, len(b.Transactions))
+	for i, tx := range b.Transactions {
+		if !full {
+			txs = append(txs, tx.Hash)
+			continue
+		}
+		txs = append(txs, map[string]interface{}{
+			"hash":             tx.Hash,
+			"from":             tx.From,
+			"to":               nullable(tx.To),
+			"value":            tx.Value,
+			"blockNumber":      toHex(b.Number),
+			"blockHash":        b.Hash,
+			"transactionIndex": toHex(i),
+		})
+	}
+	return map[string]interface{}{
+		"number":       toHex(b.Number),
+		"hash":         b.Hash,
+		"parentHash":   b.ParentHash,
+		"timestamp":    toHex(int(b.Timestamp)),
+		"transactions": txs,
+	}
+}
+
+func encodeReceipt(b *Block, i int, tx *Transaction) map[string]interface{} {
+	logs := make([]map[string]interface{}, 0, len(tx.Logs))
+	for j, l := range tx.Logs {
+		logs = append(logs, map[string]interface{}{
+			"address":          l.Address,
+			"topics":           l.Topics,
+			"data":             l.Data,
+			"blockNumber":      toHex(b.Number),
+			"blockHash":        b.Hash,
+			"transactionHash":  tx.Hash,
+			"transactionIndex": toHex(i),
+			"logIndex":         toHex(j),
+			"removed":          false,
+		})
+	}
+	status := tx.Status
+	if status == "" {
+		status = "0x1"
+	}
+	return map[string]interface{}{
+		"transactionHash":  tx.Hash,
+		"transactionIndex": toHex(i),
+		"blockNumber":      toHex(b.Number),
+		"blockHash":        b.Hash,
+		"from":             tx.From,
+		"to":               nullable(tx.To),
+		"status":           status,
+		"gasUsed":          tx.GasUsed,
+		"logs":             logs,
+	}
+}
+
+func nullable(v string) interface{} {
+	if v == "" {
+		return nil
+	}
+	return v
+}
+
+func toHex(n int) string {
+	return fmt.Sprintf("0x%x", n)
+}
+
+func writeResponse(w http.ResponseWriter, resp response) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
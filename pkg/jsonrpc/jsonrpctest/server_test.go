@@ -0,0 +1,99 @@
+package jsonrpctest
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func call(t *testing.T, url, method, params string) (*http.Response, response) {
+	body := `{"jsonrpc":"2.0","id":1,"method":"` + method + `","params":` + params + `}`
+	resp, err := http.Post(url, "application/json", strings.NewReader(body))
+	require.NoError(t, err)
+	defer resp.Body.Close()
+
+	var out response
+	if resp.StatusCode == http.StatusOK {
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&out))
+	}
+	return resp, out
+}
+
+func TestMethods(t *testing.T) {
+	node := NewServer(DefaultChain())
+	defer node.Close()
+
+	_, out := call(t, node.URL, "eth_blockNumber", `[]`)
+	assert.Equal(t, `"0x68"`, string(out.Result))
+
+	_, out = call(t, node.URL, "eth_getBlockByNumber", `["0x65", true]`)
+	var block struct {
+		Hash         string                   `json:"hash"`
+		Transactions []map[string]interface{} `json:"transactions"`
+	}
+	require.NoError(t, json.Unmarshal(out.Result, &block))
+	assert.Len(t, block.Transactions, 2)
+	assert.Nil(t, block.Transactions[1]["to"])
+
+	_, out = call(t, node.URL, "eth_getBlockByNumber", `["0x1", true]`)
+	assert.Equal(t, "null", string(out.Result))
+
+	_, out = call(t, node.URL, "eth_getLogs", `[{"fromBlock":"0x64","toBlock":"latest"}]`)
+	var logs []map[string]interface{}
+	require.NoError(t, json.Unmarshal(out.Result, &logs))
+	assert.Len(t, logs, 1)
+
+	_, out = call(t, node.URL, "eth_getTransactionReceipt", `["`+logs[0]["transactionHash"].(string)+`"]`)
+	assert.Contains(t, string(out.Result), `"status":"0x1"`)
+
+	_, out = call(t, node.URL, "eth_unknown", `[]`)
+	assert.Equal(t, CodeMethodNotFound, out.Error.Code)
+	assert.Equal(t, 1, node.Calls("eth_blockNumber"))
+}
+
+func TestReorg(t *testing.T) {
+	node := NewServer(DefaultChain())
+	defer node.Close()
+
+	before := DefaultChain().Head().Hash
+	node.Reorg(2, true)
+	b := node.Mine()
+
+	assert.Equal(t, 105, b.Number)
+	_, out := call(t, node.URL, "eth_getBlockByNumber", `["0x68", true]`)
+	assert.NotContains(t, string(out.Result), before)
+	assert.Contains(t, string(out.Result), `"transactions":[]`)
+}
+
+func TestFailuresAndRateLimit(t *testing.T) {
+	node := NewServer(DefaultChain())
+	defer node.Close()
+
+	node.FailHTTP(1, http.StatusBadGateway)
+	resp, _ := call(t, node.URL, "eth_blockNumber", `[]`)
+	assert.Equal(t, http.StatusBadGateway, resp.StatusCode)
+
+	node.FailRPC(1, CodeInternalError, "boom")
+	_, out := call(t, node.URL, "eth_blockNumber", `[]`)
+	assert.Equal(t, "boom", out.Error.Message)
+
+	node.SetRateLimit(0.001, 1)
+	resp, _ = call(t, node.URL, "eth_blockNumber", `[]`)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	resp, _ = call(t, node.URL, "eth_blockNumber", `[]`)
+	assert.Equal(t, http.StatusTooManyRequests, resp.StatusCode)
+	assert.Equal(t, "1", resp.Header.Get("Retry-After"))
+}
+
+func TestLoadChain(t *testing.T) {
+	chain, err := LoadChain("fixtures/chain.json")
+	require.NoError(t, err)
+	assert.Len(t, chain.Blocks, 5)
+
+	_, err = LoadChain("fixtures/missing.json")
+	assert.Error(t, err)
+}
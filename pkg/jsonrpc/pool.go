@@ -0,0 +1,195 @@
+package jsonrpc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/jmsilvadev/tx-parser/pkg/logger"
+	"github.com/jmsilvadev/tx-parser/pkg/parser"
+	"go.uber.org/zap"
+)
+
+// Default Values
+var (
+	ewmaWeight    = 0.3
+	errorHalfLife = 30 * time.Second
+	errorPenalty  = 10.0
+)
+
+var ErrNoProviders = errors.New("no json-rpc providers configured")
+
+// Pool spreads calls over several providers. Every call is routed to the
+// healthiest provider first and fails over to the next one on error.
+// Health is a mix of latency and recent error rate, with errors fading
+// out over time so a recovered provider gets traffic back.
+type Pool struct {
+	log       logger.Logger
+	providers []*provider
+	mu        sync.Mutex
+	head      int
+}
+
+type provider struct {
+	name        string
+	client      JsonRpcClient
+	latency     float64
+	errorRate   float64
+	lastFailure time.Time
+	head        int
+}
+
+var _ JsonRpcClient = &Pool{}
+var _ UsageReporter = &Pool{}
+
+func NewPool(l logger.Logger, clients ...JsonRpcClient) *Pool {
+	p := &Pool{log: l}
+	for i, c := range clients {
+		name := fmt.Sprintf("provider-%d", i)
+		if e, ok := c.(interface{ Endpoint() string }); ok {
+			name = e.Endpoint()
+		}
+		p.providers = append(p.providers, &provider{name: name, client: c})
+	}
+	return p
+}
+
+func (p *Pool) Usage() []Usage {
+	var usage []Usage
+	for _, pr := range p.providers {
+		if r, ok := pr.client.(UsageReporter); ok {
+			usage = append(usage, r.Usage()...)
+		}
+	}
+	return usage
+}
+
+// GetCurrentBlockNumber never returns a head lower than one already
+// returned: a provider reporting an older head is considered lagging,
+// gets penalized and the next provider is asked instead. When none of them
+// caught up, the best head seen is returned as long as one of them
+// answered.
+func (p *Pool) GetCurrentBlockNumber(ctx context.Context) (int, error) {
+	var lastErr error = ErrNoProviders
+	answered := false
+	for _, pr := range p.ranked(0) {
+		start := time.Now()
+		blockNumber, err := pr.client.GetCurrentBlockNumber(ctx)
+		if err != nil {
+			p.record(pr, time.Since(start), err)
+			lastErr = err
+			if ctx.Err() != nil {
+				return 0, ctx.Err()
+			}
+			p.log.Warn("provider failed to return its head", zap.String("provider", pr.name), logger.Err(err))
+			continue
+		}
+
+		p.mu.Lock()
+		pr.head = blockNumber
+		head := p.head
+		if blockNumber >= head {
+			p.head = blockNumber
+		}
+		p.mu.Unlock()
+
+		if blockNumber < head {
+			p.log.Warn("provider is behind", zap.String("provider", pr.name), logger.BlockNumber(blockNumber), zap.Int("expected_block", head))
+			p.record(pr, time.Since(start), errors.New("provider behind"))
+			answered = true
+			continue
+		}
+
+		p.record(pr, time.Since(start), nil)
+		return blockNumber, nil
+	}
+
+	if answered {
+		p.mu.Lock()
+		defer p.mu.Unlock()
+		return p.head, nil
+	}
+	return 0, lastErr
+}
+
+func (p *Pool) GetBlock(ctx context.Context, blockNumber int) (*Block, error) {
+	var lastErr error = ErrNoProviders
+	for _, pr := range p.ranked(blockNumber) {
+		start := time.Now()
+		block, err := pr.client.GetBlock(ctx, blockNumber)
+		p.record(pr, time.Since(start), err)
+		if err == nil {
+			return block, nil
+		}
+		lastErr = err
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		p.log.Warn("provider failed, trying the next one", zap.String("provider", pr.name), logger.Err(err))
+	}
+	return nil, lastErr
+}
+
+func (p *Pool) GetBlockTransactions(ctx context.Context, blockNumber int) ([]parser.Transaction, error) {
+	block, err := p.GetBlock(ctx, blockNumber)
+	if err != nil {
+		return nil, err
+	}
+	return block.Transactions, nil
+}
+
+// ranked returns the providers ordered by health. When blockNumber is set,
+// providers known to have reached that block come first.
+func (p *Pool) ranked(blockNumber int) []*provider {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	now := time.Now()
+	scores := make(map[*provider]float64, len(p.providers))
+	for _, pr := range p.providers {
+		scores[pr] = pr.score(now)
+	}
+
+	ranked := make([]*provider, len(p.providers))
+	copy(ranked, p.providers)
+	sort.SliceStable(ranked, func(i, j int) bool {
+		iHas, jHas := ranked[i].head >= blockNumber, ranked[j].head >= blockNumber
+		if iHas != jHas {
+			return iHas
+		}
+		return scores[ranked[i]] < scores[ranked[j]]
+	})
+	return ranked
+}
+
+func (p *Pool) record(pr *provider, elapsed time.Duration, err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	ms := float64(elapsed.Milliseconds())
+	if pr.latency == 0 {
+		pr.latency = ms
+	} else {
+		pr.latency = ewmaWeight*ms + (1-ewmaWeight)*pr.latency
+	}
+
+	failure := 0.0
+	if err != nil {
+		failure = 1
+		pr.lastFailure = time.Now()
+	}
+	pr.errorRate = ewmaWeight*failure + (1-ewmaWeight)*pr.errorRate
+}
+
+// score is lower for healthier providers.
+func (pr *provider) score(now time.Time) float64 {
+	errorRate := pr.errorRate
+	if !pr.lastFailure.IsZero() {
+		errorRate *= math.Exp2(-float64(now.Sub(pr.lastFailure)) / float64(errorHalfLife))
+	}
+	return (pr.latency + 1) * (1 + errorPenalty*errorRate)
+}
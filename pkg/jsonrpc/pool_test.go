@@ -0,0 +1,121 @@
+package jsonrpc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/jmsilvadev/tx-parser/pkg/logger"
+	"github.com/jmsilvadev/tx-parser/pkg/parser"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap/zapcore"
+)
+
+type fakeClient struct {
+	head  int
+	err   error
+	block *Block
+	calls int
+}
+
+func (f *fakeClient) GetCurrentBlockNumber(ctx context.Context) (int, error) {
+	f.calls++
+	return f.head, f.err
+}
+
+func (f *fakeClient) GetBlock(ctx context.Context, blockNumber int) (*Block, error) {
+	f.calls++
+	if f.err != nil {
+		return nil, f.err
+	}
+	if blockNumber > f.head {
+		return nil, ErrBlockNotFound
+	}
+	if f.block != nil {
+		return f.block, nil
+	}
+	return &Block{
+		Number:       blockNumber,
+		Hash:         fmt.Sprintf("0x%x", blockNumber),
+		Transactions: []parser.Transaction{{Hash: "0xabc", BlockNumber: blockNumber}},
+	}, nil
+}
+
+func (f *fakeClient) GetBlockTransactions(ctx context.Context, blockNumber int) ([]parser.Transaction, error) {
+	block, err := f.GetBlock(ctx, blockNumber)
+	if err != nil {
+		return nil, err
+	}
+	return block.Transactions, nil
+}
+
+func TestPoolFailover(t *testing.T) {
+	l := logger.New(zapcore.DebugLevel)
+	broken := &fakeClient{err: errors.New("boom")}
+	healthy := &fakeClient{head: 10}
+	p := NewPool(l, broken, healthy)
+
+	blockNumber, err := p.GetCurrentBlockNumber(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, 10, blockNumber)
+
+	// the broken provider is ranked last after failing
+	_, err = p.GetCurrentBlockNumber(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, 1, broken.calls)
+
+	txs, err := p.GetBlockTransactions(context.Background(), 10)
+	assert.NoError(t, err)
+	assert.Len(t, txs, 1)
+}
+
+func TestPoolNeverGoesBackwards(t *testing.T) {
+	l := logger.New(zapcore.DebugLevel)
+	ahead := &fakeClient{head: 20}
+	p := NewPool(l, ahead)
+
+	blockNumber, err := p.GetCurrentBlockNumber(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, 20, blockNumber)
+
+	ahead.head = 18
+	behind := &fakeClient{head: 19}
+	p.providers = append(p.providers, &provider{name: "behind", client: behind})
+
+	blockNumber, err = p.GetCurrentBlockNumber(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, 20, blockNumber)
+
+	// a provider failing after one behind does not hide the best head
+	p = NewPool(l, &fakeClient{head: 19}, &fakeClient{err: errors.New("boom")})
+	p.head = 20
+	blockNumber, err = p.GetCurrentBlockNumber(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, 20, blockNumber)
+}
+
+func TestPoolPrefersProvidersWithTheBlock(t *testing.T) {
+	l := logger.New(zapcore.DebugLevel)
+	lagging := &fakeClient{head: 5}
+	synced := &fakeClient{head: 10}
+	p := NewPool(l, lagging, synced)
+	p.providers[0].head = 5
+	p.providers[1].head = 10
+
+	txs, err := p.GetBlockTransactions(context.Background(), 10)
+	assert.NoError(t, err)
+	assert.Len(t, txs, 1)
+	assert.Equal(t, 0, lagging.calls)
+}
+
+func TestPoolAllFailing(t *testing.T) {
+	l := logger.New(zapcore.DebugLevel)
+	p := NewPool(l, &fakeClient{err: errors.New("a")}, &fakeClient{err: errors.New("b")})
+
+	_, err := p.GetCurrentBlockNumber(context.Background())
+	assert.Error(t, err)
+
+	_, err = NewPool(l).GetBlockTransactions(context.Background(), 1)
+	assert.ErrorIs(t, err, ErrNoProviders)
+}
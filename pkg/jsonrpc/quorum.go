@@ -0,0 +1,158 @@
+package jsonrpc
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/jmsilvadev/tx-parser/pkg/logger"
+	"github.com/jmsilvadev/tx-parser/pkg/parser"
+	"go.uber.org/zap"
+)
+
+var ErrNoQuorum = errors.New("providers did not reach quorum")
+
+// Quorum asks every provider for each block and only returns it when at
+// least threshold of them, a majority, agree on the block hash and
+// transaction set.
+type Quorum struct {
+	log           logger.Logger
+	clients       []JsonRpcClient
+	threshold     int
+	disagreements atomic.Uint64
+}
+
+var _ JsonRpcClient = &Quorum{}
+var _ UsageReporter = &Quorum{}
+
+// NewQuorum raises a threshold lower than a majority of the clients to a
+// majority, so a single provider cannot outvote the others, and lowers one
+// higher than the number of clients to that number.
+func NewQuorum(l logger.Logger, threshold int, clients ...JsonRpcClient) *Quorum {
+	if threshold > len(clients) {
+		l.Warn("quorum higher than the number of providers", zap.Int("quorum", threshold), zap.Int("providers", len(clients)), zap.Int("using", len(clients)))
+		threshold = len(clients)
+	}
+	if majority := len(clients)/2 + 1; threshold < majority {
+		l.Warn("quorum not a majority of the providers", zap.Int("quorum", threshold), zap.Int("providers", len(clients)), zap.Int("using", majority))
+		threshold = majority
+	}
+	return &Quorum{
+		log:       l,
+		clients:   clients,
+		threshold: threshold,
+	}
+}
+
+// Disagreements returns how many blocks were served differently by at
+// least two providers since the client was created.
+func (q *Quorum) Disagreements() uint64 {
+	return q.disagreements.Load()
+}
+
+func (q *Quorum) Usage() []Usage {
+	var usage []Usage
+	for _, c := range q.clients {
+		if r, ok := c.(UsageReporter); ok {
+			usage = append(usage, r.Usage()...)
+		}
+	}
+	return usage
+}
+
+// GetCurrentBlockNumber returns the highest block reached by at least
+// threshold providers, so that block can actually be cross-checked.
+func (q *Quorum) GetCurrentBlockNumber(ctx context.Context) (int, error) {
+	heads := make([]int, len(q.clients))
+	errs := make([]error, len(q.clients))
+	q.each(func(i int, c JsonRpcClient) {
+		heads[i], errs[i] = c.GetCurrentBlockNumber(ctx)
+	})
+
+	var reached []int
+	for i, head := range heads {
+		if errs[i] == nil {
+			reached = append(reached, head)
+		}
+	}
+	if len(reached) < q.threshold {
+		return 0, fmt.Errorf("%w: %d of %d providers answered: %w", ErrNoQuorum, len(reached), q.threshold, errors.Join(errs...))
+	}
+
+	sort.Sort(sort.Reverse(sort.IntSlice(reached)))
+	return reached[q.threshold-1], nil
+}
+
+func (q *Quorum) GetBlock(ctx context.Context, blockNumber int) (*Block, error) {
+	blocks := make([]*Block, len(q.clients))
+	errs := make([]error, len(q.clients))
+	q.each(func(i int, c JsonRpcClient) {
+		blocks[i], errs[i] = c.GetBlock(ctx, blockNumber)
+	})
+
+	votes := make(map[string]int)
+	var winner *Block
+	for i, block := range blocks {
+		if errs[i] != nil {
+			continue
+		}
+		key := fingerprint(block)
+		votes[key]++
+		if votes[key] >= q.threshold && winner == nil {
+			winner = block
+		}
+	}
+
+	if len(votes) > 1 {
+		q.disagreements.Add(1)
+		q.log.Warn("providers disagree on the block", logger.BlockNumber(blockNumber), zap.Int("versions", len(votes)))
+	}
+
+	if winner == nil {
+		return nil, fmt.Errorf("%w for block %d: %w", ErrNoQuorum, blockNumber, errors.Join(errs...))
+	}
+	return winner, nil
+}
+
+func (q *Quorum) GetBlockTransactions(ctx context.Context, blockNumber int) ([]parser.Transaction, error) {
+	block, err := q.GetBlock(ctx, blockNumber)
+	if err != nil {
+		return nil, err
+	}
+	return block.Transactions, nil
+}
+
+func (q *Quorum) each(fn func(int, JsonRpcClient)) {
+	wg := sync.WaitGroup{}
+	for i, c := range q.clients {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			fn(i, c)
+		}()
+	}
+	wg.Wait()
+}
+
+// fingerprint identifies a block by its hash and the content of its
+// transactions, independently of the order they were returned in.
+func fingerprint(b *Block) string {
+	txs := make([]string, 0, len(b.Transactions))
+	for _, tx := range b.Transactions {
+		txs = append(txs, strings.ToLower(strings.Join([]string{tx.Hash, tx.From, tx.To, tx.Value}, "|")))
+	}
+	sort.Strings(txs)
+
+	h := sha256.New()
+	h.Write([]byte(strings.ToLower(b.Hash)))
+	for _, tx := range txs {
+		h.Write([]byte("\n" + tx))
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
@@ -0,0 +1,81 @@
+package jsonrpc
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/jmsilvadev/tx-parser/pkg/logger"
+	"github.com/jmsilvadev/tx-parser/pkg/parser"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap/zapcore"
+)
+
+func TestQuorumCurrentBlockNumber(t *testing.T) {
+	l := logger.New(zapcore.DebugLevel)
+	q := NewQuorum(l, 2, &fakeClient{head: 12}, &fakeClient{head: 10}, &fakeClient{head: 11})
+
+	blockNumber, err := q.GetCurrentBlockNumber(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, 11, blockNumber)
+
+	q = NewQuorum(l, 2, &fakeClient{head: 12}, &fakeClient{err: errors.New("down")})
+	_, err = q.GetCurrentBlockNumber(context.Background())
+	assert.ErrorIs(t, err, ErrNoQuorum)
+}
+
+func TestQuorumAgreement(t *testing.T) {
+	l := logger.New(zapcore.DebugLevel)
+	q := NewQuorum(l, 2, &fakeClient{head: 10}, &fakeClient{head: 10}, &fakeClient{err: errors.New("down")})
+
+	block, err := q.GetBlock(context.Background(), 10)
+	assert.NoError(t, err)
+	assert.Equal(t, "0xa", block.Hash)
+	assert.Equal(t, uint64(0), q.Disagreements())
+}
+
+func TestQuorumDisagreement(t *testing.T) {
+	l := logger.New(zapcore.DebugLevel)
+	forked := &Block{
+		Number:       10,
+		Hash:         "0xa",
+		Transactions: []parser.Transaction{{Hash: "0xdef", BlockNumber: 10}},
+	}
+
+	q := NewQuorum(l, 2, &fakeClient{head: 10}, &fakeClient{head: 10}, &fakeClient{head: 10, block: forked})
+	txs, err := q.GetBlockTransactions(context.Background(), 10)
+	assert.NoError(t, err)
+	assert.Equal(t, "0xabc", txs[0].Hash)
+	assert.Equal(t, uint64(1), q.Disagreements())
+
+	q = NewQuorum(l, 2, &fakeClient{head: 10}, &fakeClient{head: 10, block: forked})
+	_, err = q.GetBlock(context.Background(), 10)
+	assert.ErrorIs(t, err, ErrNoQuorum)
+	assert.Equal(t, uint64(1), q.Disagreements())
+}
+
+func TestQuorumMajority(t *testing.T) {
+	l := logger.New(zapcore.DebugLevel)
+	forked := &Block{
+		Number:       10,
+		Hash:         "0xb",
+		Transactions: []parser.Transaction{{Hash: "0xdef", BlockNumber: 10}},
+	}
+
+	// 1 of 2 would let either provider decide alone
+	q := NewQuorum(l, 1, &fakeClient{head: 10}, &fakeClient{head: 10, block: forked})
+	_, err := q.GetBlock(context.Background(), 10)
+	assert.ErrorIs(t, err, ErrNoQuorum)
+	assert.Equal(t, uint64(1), q.Disagreements())
+
+	q = NewQuorum(l, 1, &fakeClient{head: 10}, &fakeClient{head: 10})
+	block, err := q.GetBlock(context.Background(), 10)
+	assert.NoError(t, err)
+	assert.Equal(t, "0xa", block.Hash)
+
+	// 3 of 2 could never be reached
+	q = NewQuorum(l, 3, &fakeClient{head: 10}, &fakeClient{head: 10})
+	block, err = q.GetBlock(context.Background(), 10)
+	assert.NoError(t, err)
+	assert.Equal(t, "0xa", block.Hash)
+}
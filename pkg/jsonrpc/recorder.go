@@ -0,0 +1,102 @@
+package jsonrpc
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/jmsilvadev/tx-parser/pkg/logger"
+	"github.com/jmsilvadev/tx-parser/pkg/parser"
+	"go.uber.org/zap"
+)
+
+// Record is one call captured by a Recorder, stored as a JSON file named
+// after its sequence number and method, e.g. 000042-eth_getBlockByNumber.json.
+type Record struct {
+	Seq    int             `json:"seq"`
+	Method string          `json:"method"`
+	Block  int             `json:"block,omitempty"`
+	Result json.RawMessage `json:"result,omitempty"`
+	Error  string          `json:"error,omitempty"`
+}
+
+// Recorder decorates a client and writes every call it serves, results and
+// errors alike, to a fixture directory that a Replay client can serve back.
+type Recorder struct {
+	log    logger.Logger
+	client JsonRpcClient
+	dir    string
+	mu     sync.Mutex
+	seq    int
+}
+
+var _ JsonRpcClient = &Recorder{}
+
+func NewRecorder(l logger.Logger, cli JsonRpcClient, dir string) (*Recorder, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	records, err := readRecords(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	r := &Recorder{log: l, client: cli, dir: dir}
+	if len(records) > 0 {
+		// keep appending to an existing capture
+		r.seq = records[len(records)-1].Seq
+	}
+	return r, nil
+}
+
+func (r *Recorder) GetCurrentBlockNumber(ctx context.Context) (int, error) {
+	blockNumber, err := r.client.GetCurrentBlockNumber(ctx)
+	r.write(Record{Method: "eth_blockNumber"}, blockNumber, err)
+	return blockNumber, err
+}
+
+func (r *Recorder) GetBlock(ctx context.Context, blockNumber int) (*Block, error) {
+	block, err := r.client.GetBlock(ctx, blockNumber)
+	r.write(Record{Method: "eth_getBlockByNumber", Block: blockNumber}, block, err)
+	return block, err
+}
+
+func (r *Recorder) GetBlockTransactions(ctx context.Context, blockNumber int) ([]parser.Transaction, error) {
+	block, err := r.GetBlock(ctx, blockNumber)
+	if err != nil {
+		return nil, err
+	}
+	return block.Transactions, nil
+}
+
+// write never fails the call being recorded, a lost record is only logged.
+func (r *Recorder) write(rec Record, result interface{}, callErr error) {
+	if callErr != nil {
+		rec.Error = callErr.Error()
+	} else {
+		data, err := json.Marshal(result)
+		if err != nil {
+			r.log.Error("failed to encode the result", zap.String("method", rec.Method), logger.Err(err))
+			return
+		}
+		rec.Result = data
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.seq++
+	rec.Seq = r.seq
+	data, err := json.MarshalIndent(rec, "", "  ")
+	if err != nil {
+		r.log.Error("failed to encode the record", zap.Int("seq", rec.Seq), logger.Err(err))
+		return
+	}
+	name := filepath.Join(r.dir, fmt.Sprintf("%06d-%s.json", rec.Seq, rec.Method))
+	if err := os.WriteFile(name, data, 0644); err != nil {
+		r.log.Error("failed to write the record", zap.String("file", name), logger.Err(err))
+	}
+}
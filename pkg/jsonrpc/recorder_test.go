@@ -0,0 +1,92 @@
+package jsonrpc
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+
+	"github.com/jmsilvadev/tx-parser/pkg/jsonrpc/jsonrpctest"
+	"github.com/jmsilvadev/tx-parser/pkg/logger"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap/zapcore"
+)
+
+func TestRecordAndReplay(t *testing.T) {
+	l := logger.New(zapcore.DebugLevel)
+	dir := t.TempDir()
+	node := jsonrpctest.NewServer(jsonrpctest.DefaultChain())
+	defer node.Close()
+
+	rec, err := NewRecorder(l, NewEthereum(l, node.URL, WithRetries(0)), dir)
+	require.NoError(t, err)
+
+	ctx := context.Background()
+	head, err := rec.GetCurrentBlockNumber(ctx)
+	require.NoError(t, err)
+	block, err := rec.GetBlock(ctx, head)
+	require.NoError(t, err)
+
+	node.FailHTTP(1, http.StatusBadRequest)
+	_, err = rec.GetCurrentBlockNumber(ctx)
+	require.Error(t, err)
+
+	node.Mine()
+	next, err := rec.GetCurrentBlockNumber(ctx)
+	require.NoError(t, err)
+	_, err = rec.GetBlockTransactions(ctx, next)
+	require.NoError(t, err)
+
+	replay, err := NewReplay(l, dir)
+	require.NoError(t, err)
+
+	got, err := replay.GetCurrentBlockNumber(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, head, got)
+
+	replayed, err := replay.GetBlock(ctx, head)
+	assert.NoError(t, err)
+	assert.Equal(t, block, replayed)
+
+	_, err = replay.GetCurrentBlockNumber(ctx)
+	assert.Error(t, err)
+
+	for i := 0; i < 2; i++ {
+		got, err = replay.GetCurrentBlockNumber(ctx)
+		assert.NoError(t, err)
+		assert.Equal(t, next, got)
+	}
+
+	txs, err := replay.GetBlockTransactions(ctx, next)
+	assert.NoError(t, err)
+	assert.Empty(t, txs)
+
+	_, err = replay.GetBlock(ctx, 1)
+	assert.ErrorIs(t, err, ErrBlockNotFound)
+}
+
+func TestRecorderAppends(t *testing.T) {
+	l := logger.New(zapcore.DebugLevel)
+	dir := t.TempDir()
+
+	rec, err := NewRecorder(l, &fakeClient{head: 1}, dir)
+	require.NoError(t, err)
+	rec.GetCurrentBlockNumber(context.Background())
+
+	rec, err = NewRecorder(l, &fakeClient{head: 2, err: errors.New("down")}, dir)
+	require.NoError(t, err)
+	rec.GetCurrentBlockNumber(context.Background())
+
+	records, err := readRecords(dir)
+	require.NoError(t, err)
+	assert.Len(t, records, 2)
+	assert.Equal(t, 2, records[1].Seq)
+	assert.Equal(t, "down", records[1].Error)
+}
+
+func TestReplayWithoutRecords(t *testing.T) {
+	l := logger.New(zapcore.DebugLevel)
+	_, err := NewReplay(l, t.TempDir())
+	assert.Error(t, err)
+}
@@ -0,0 +1,129 @@
+package jsonrpc
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/jmsilvadev/tx-parser/pkg/logger"
+	"github.com/jmsilvadev/tx-parser/pkg/parser"
+	"go.uber.org/zap"
+)
+
+// Replay serves the calls captured by a Recorder, without any network.
+// Heads are served in the order they were recorded, so the parser sees
+// the chain advance exactly as it did; once the capture is exhausted the
+// last head is repeated. Blocks are served by number, replaying every
+// recorded answer for that block in order.
+type Replay struct {
+	log    logger.Logger
+	mu     sync.Mutex
+	heads  []Record
+	blocks map[int][]Record
+}
+
+var _ JsonRpcClient = &Replay{}
+
+func NewReplay(l logger.Logger, dir string) (*Replay, error) {
+	records, err := readRecords(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	r := &Replay{log: l, blocks: make(map[int][]Record)}
+	for _, rec := range records {
+		switch rec.Method {
+		case "eth_blockNumber":
+			r.heads = append(r.heads, rec)
+		case "eth_getBlockByNumber":
+			r.blocks[rec.Block] = append(r.blocks[rec.Block], rec)
+		}
+	}
+	if len(r.heads) == 0 {
+		return nil, fmt.Errorf("no eth_blockNumber records in %s", dir)
+	}
+	return r, nil
+}
+
+func (r *Replay) GetCurrentBlockNumber(ctx context.Context) (int, error) {
+	r.mu.Lock()
+	rec := r.heads[0]
+	if len(r.heads) > 1 {
+		r.heads = r.heads[1:]
+	}
+	r.mu.Unlock()
+
+	r.log.Debug("replaying record", zap.Int("seq", rec.Seq), zap.String("method", rec.Method))
+	var blockNumber int
+	err := rec.decode(&blockNumber)
+	return blockNumber, err
+}
+
+func (r *Replay) GetBlock(ctx context.Context, blockNumber int) (*Block, error) {
+	r.mu.Lock()
+	recs, ok := r.blocks[blockNumber]
+	if !ok {
+		r.mu.Unlock()
+		return nil, ErrBlockNotFound
+	}
+	rec := recs[0]
+	if len(recs) > 1 {
+		r.blocks[blockNumber] = recs[1:]
+	}
+	r.mu.Unlock()
+
+	r.log.Debug("replaying record", zap.Int("seq", rec.Seq), zap.String("method", rec.Method), logger.BlockNumber(blockNumber))
+	var block *Block
+	if err := rec.decode(&block); err != nil {
+		return nil, err
+	}
+	return block, nil
+}
+
+func (r *Replay) GetBlockTransactions(ctx context.Context, blockNumber int) ([]parser.Transaction, error) {
+	block, err := r.GetBlock(ctx, blockNumber)
+	if err != nil {
+		return nil, err
+	}
+	return block.Transactions, nil
+}
+
+func (rec Record) decode(v interface{}) error {
+	if rec.Error != "" {
+		return errors.New(rec.Error)
+	}
+	return json.Unmarshal(rec.Result, v)
+}
+
+// readRecords loads the records of a fixture directory ordered by sequence.
+func readRecords(dir string) ([]Record, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var records []Record
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), ".json") {
+			continue
+		}
+		data, err := os.ReadFile(filepath.Join(dir, entry.Name()))
+		if err != nil {
+			return nil, err
+		}
+		var rec Record
+		if err := json.Unmarshal(data, &rec); err != nil {
+			return nil, fmt.Errorf("%s: %w", entry.Name(), err)
+		}
+		records = append(records, rec)
+	}
+
+	sort.Slice(records, func(i, j int) bool { return records[i].Seq < records[j].Seq })
+	return records, nil
+}
@@ -0,0 +1,24 @@
+package logger
+
+import "go.uber.org/zap/zapcore"
+
+// WithFormat selects the encoder, FormatJSON or FormatConsole.
+func WithFormat(v string) Option {
+	return func(o *options) {
+		o.format = v
+	}
+}
+
+func WithOutput(v zapcore.WriteSyncer) Option {
+	return func(o *options) {
+		o.output = v
+	}
+}
+
+// WithLevel makes the logger follow a shared level instead of the one it
+// is created with.
+func WithLevel(v Level) Option {
+	return func(o *options) {
+		o.level = &v
+	}
+}
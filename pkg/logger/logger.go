@@ -1,6 +1,8 @@
 package logger
 
 import (
+	"context"
+	"fmt"
 	"os"
 
 	"go.uber.org/zap"
@@ -16,6 +18,9 @@ type Logger interface {
 	Error(msg string, fields ...Field)
 }
 
+// Level is the level of a logger, which can be changed while it runs.
+type Level = zap.AtomicLevel
+
 const (
 	LEVEL_DEBUG = zapcore.DebugLevel
 	LEVEL_INFO  = zapcore.InfoLevel
@@ -23,8 +28,27 @@ const (
 	LEVEL_ERROR = zapcore.ErrorLevel
 )
 
-func New(level zapcore.Level) Logger {
-	consoleErrors := zapcore.Lock(os.Stdout)
+const (
+	FormatJSON    = "json"
+	FormatConsole = "console"
+)
+
+type options struct {
+	format string
+	output zapcore.WriteSyncer
+	level  *Level
+}
+
+type Option func(*options)
+
+func New(level zapcore.Level, opts ...Option) Logger {
+	o := &options{
+		format: FormatJSON,
+		output: os.Stdout,
+	}
+	for _, opt := range opts {
+		opt(o)
+	}
 
 	config := zap.NewProductionEncoderConfig()
 	config.EncodeTime = zapcore.ISO8601TimeEncoder
@@ -32,12 +56,20 @@ func New(level zapcore.Level) Logger {
 	config.EncodeName = zapcore.FullNameEncoder
 
 	logLevel := zap.NewAtomicLevel()
-	logLevel.SetLevel(level)
+	if o.level != nil {
+		logLevel = *o.level
+	} else {
+		logLevel.SetLevel(level)
+	}
 
-	consoleEncoder := zapcore.NewJSONEncoder(config)
+	encoder := zapcore.NewJSONEncoder(config)
+	if o.format == FormatConsole {
+		config.EncodeLevel = zapcore.CapitalLevelEncoder
+		encoder = zapcore.NewConsoleEncoder(config)
+	}
 
 	core := zapcore.NewTee(
-		zapcore.NewCore(consoleEncoder, consoleErrors, logLevel),
+		zapcore.NewCore(encoder, zapcore.Lock(o.output), logLevel),
 	)
 
 	caller := zap.AddCaller()
@@ -47,3 +79,93 @@ func New(level zapcore.Level) Logger {
 
 	return log
 }
+
+// NewLevel returns a level to share between a logger, through WithLevel,
+// and whatever changes it at runtime.
+func NewLevel(level zapcore.Level) Level {
+	return zap.NewAtomicLevelAt(level)
+}
+
+// ParseFormat checks an encoder name, json or console.
+func ParseFormat(v string) (string, error) {
+	switch v {
+	case FormatJSON, FormatConsole:
+		return v, nil
+	}
+	return "", fmt.Errorf("invalid log format: %s", v)
+}
+
+// With returns a logger adding fields to every entry.
+func With(l Logger, fields ...Field) Logger {
+	if len(fields) == 0 {
+		return l
+	}
+	if z, ok := l.(*zap.Logger); ok {
+		return z.With(fields...)
+	}
+	return &withFields{Logger: l, fields: fields}
+}
+
+type ctxKey struct{}
+
+// NewContext returns a copy of ctx carrying the logger.
+func NewContext(ctx context.Context, l Logger) context.Context {
+	return context.WithValue(ctx, ctxKey{}, l)
+}
+
+// FromContext returns the logger of ctx, along with the fields added by
+// the callers, or fallback when ctx has none.
+func FromContext(ctx context.Context, fallback Logger) Logger {
+	if l, ok := ctx.Value(ctxKey{}).(Logger); ok {
+		return l
+	}
+	if fallback == nil {
+		return zap.NewNop()
+	}
+	return fallback
+}
+
+// Fields shared by the packages, so entries can be searched by them.
+func RequestID(v string) Field {
+	return zap.String("request_id", v)
+}
+
+func BlockNumber(v int) Field {
+	return zap.Int("block_number", v)
+}
+
+func Address(v string) Field {
+	return zap.String("address", v)
+}
+
+func Err(err error) Field {
+	return zap.Error(err)
+}
+
+// withFields adds fields to the loggers that are not zap loggers.
+type withFields struct {
+	Logger
+	fields []Field
+}
+
+func (l *withFields) Debug(msg string, fields ...Field) {
+	l.Logger.Debug(msg, l.merge(fields)...)
+}
+
+func (l *withFields) Info(msg string, fields ...Field) {
+	l.Logger.Info(msg, l.merge(fields)...)
+}
+
+func (l *withFields) Warn(msg string, fields ...Field) {
+	l.Logger.Warn(msg, l.merge(fields)...)
+}
+
+func (l *withFields) Error(msg string, fields ...Field) {
+	l.Logger.Error(msg, l.merge(fields)...)
+}
+
+// merge copies, l.fields being shared by concurrent calls.
+func (l *withFields) merge(fields []Field) []Field {
+	merged := make([]Field, 0, len(l.fields)+len(fields))
+	return append(append(merged, l.fields...), fields...)
+}
@@ -1,6 +1,10 @@
 package logger
 
 import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
 	"testing"
 
 	"github.com/stretchr/testify/require"
@@ -11,3 +15,68 @@ func TestHappyPath(t *testing.T) {
 	l := New(zapcore.DebugLevel)
 	require.NotEmpty(t, l)
 }
+
+type buffer struct {
+	bytes.Buffer
+}
+
+func (b *buffer) Sync() error {
+	return nil
+}
+
+func TestFormat(t *testing.T) {
+	out := &buffer{}
+	l := New(zapcore.InfoLevel, WithOutput(out))
+	l.Info("json", BlockNumber(7))
+
+	var entry map[string]any
+	require.NoError(t, json.Unmarshal(out.Bytes(), &entry))
+	require.Equal(t, "json", entry["msg"])
+	require.Equal(t, float64(7), entry["block_number"])
+
+	out.Reset()
+	l = New(zapcore.InfoLevel, WithOutput(out), WithFormat(FormatConsole))
+	l.Info("console", Address("0xabc"))
+	require.Contains(t, out.String(), "INFO")
+	require.Contains(t, out.String(), `{"address": "0xabc"}`)
+
+	_, err := ParseFormat("xml")
+	require.Error(t, err)
+	f, err := ParseFormat(FormatConsole)
+	require.NoError(t, err)
+	require.Equal(t, FormatConsole, f)
+}
+
+func TestLevel(t *testing.T) {
+	out := &buffer{}
+	level := NewLevel(zapcore.WarnLevel)
+	l := New(zapcore.DebugLevel, WithOutput(out), WithLevel(level))
+
+	l.Info("dropped")
+	require.Empty(t, out.String())
+
+	level.SetLevel(zapcore.DebugLevel)
+	l.Debug("kept")
+	require.Contains(t, out.String(), "kept")
+}
+
+type entries struct {
+	Logger
+	fields []Field
+}
+
+func (e *entries) Info(msg string, fields ...Field) {
+	e.fields = fields
+}
+
+func TestContext(t *testing.T) {
+	fallback := &entries{}
+	require.Equal(t, fallback, FromContext(context.Background(), fallback))
+	require.NotNil(t, FromContext(context.Background(), nil))
+
+	ctx := NewContext(context.Background(), With(fallback, RequestID("abc")))
+	FromContext(ctx, nil).Info("request", Err(errors.New("failed")))
+	require.Equal(t, []Field{RequestID("abc"), Err(errors.New("failed"))}, fallback.fields)
+
+	require.Equal(t, Logger(fallback), With(fallback))
+}
@@ -0,0 +1,210 @@
+package metrics
+
+import (
+	"net/http"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/jmsilvadev/tx-parser/pkg/response"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// Default Values
+var (
+	namespace = "txparser"
+)
+
+// Metrics holds the collectors of the parser and the API. A nil *Metrics
+// records nothing, so components work the same without metrics.
+type Metrics struct {
+	registry        *prometheus.Registry
+	head            atomic.Int64
+	current         atomic.Int64
+	blocksProcessed prometheus.Counter
+	txsMatched      prometheus.Counter
+	subscriptions   prometheus.Gauge
+	rpcDuration     *prometheus.HistogramVec
+	rpcErrors       *prometheus.CounterVec
+	storeDuration   *prometheus.HistogramVec
+	httpDuration    *prometheus.HistogramVec
+}
+
+func New() *Metrics {
+	m := &Metrics{
+		registry: prometheus.NewRegistry(),
+		blocksProcessed: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "blocks_processed_total",
+			Help:      "Blocks ingested by the parser.",
+		}),
+		txsMatched: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "transactions_matched_total",
+			Help:      "Transactions involving a subscribed address.",
+		}),
+		subscriptions: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "subscriptions",
+			Help:      "Addresses subscribed.",
+		}),
+		rpcDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "jsonrpc_request_duration_seconds",
+			Help:      "Latency of the calls to the JSON-RPC providers, per attempt.",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"endpoint", "method"}),
+		rpcErrors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "jsonrpc_errors_total",
+			Help:      "Failed calls to the JSON-RPC providers, per attempt.",
+		}, []string{"endpoint", "method"}),
+		storeDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "store_operation_duration_seconds",
+			Help:      "Latency of the LevelDB operations.",
+			Buckets:   []float64{.00001, .00005, .0001, .0005, .001, .005, .01, .05, .1, .5},
+		}, []string{"op"}),
+		httpDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "http_request_duration_seconds",
+			Help:      "Latency of the HTTP requests, per route and status.",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"route", "method", "status"}),
+	}
+
+	m.registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "chain_head_block",
+			Help:      "Latest block reported by the JSON-RPC provider.",
+		}, func() float64 { return float64(m.head.Load()) }),
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "current_block",
+			Help:      "Last block ingested by the parser.",
+		}, func() float64 { return float64(m.current.Load()) }),
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "block_lag",
+			Help:      "Blocks the parser is behind the chain head.",
+		}, func() float64 { return float64(m.Lag()) }),
+		m.blocksProcessed,
+		m.txsMatched,
+		m.subscriptions,
+		m.rpcDuration,
+		m.rpcErrors,
+		m.storeDuration,
+		m.httpDuration,
+	)
+	return m
+}
+
+// Handler serves the metrics in the Prometheus text format.
+func (m *Metrics) Handler() http.Handler {
+	return promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{Registry: m.registry})
+}
+
+func (m *Metrics) SetChainHead(block int) {
+	if m == nil {
+		return
+	}
+	m.head.Store(int64(block))
+}
+
+func (m *Metrics) SetCurrentBlock(block int) {
+	if m == nil {
+		return
+	}
+	m.current.Store(int64(block))
+}
+
+// Lag is the distance between the chain head and the last ingested block,
+// zero until both are known.
+func (m *Metrics) Lag() int {
+	if m == nil {
+		return 0
+	}
+	head, current := m.head.Load(), m.current.Load()
+	if head == 0 || head < current {
+		return 0
+	}
+	return int(head - current)
+}
+
+// BlockProcessed records an ingested block and the transactions matched
+// in it.
+func (m *Metrics) BlockProcessed(block, matched int) {
+	if m == nil {
+		return
+	}
+	m.SetCurrentBlock(block)
+	m.blocksProcessed.Inc()
+	m.txsMatched.Add(float64(matched))
+}
+
+func (m *Metrics) SetSubscriptions(n int) {
+	if m == nil {
+		return
+	}
+	m.subscriptions.Set(float64(n))
+}
+
+func (m *Metrics) AddSubscriptions(delta int) {
+	if m == nil {
+		return
+	}
+	m.subscriptions.Add(float64(delta))
+}
+
+// ObserveRPC records an attempt of a JSON-RPC call, err being its outcome.
+func (m *Metrics) ObserveRPC(endpoint, method string, d time.Duration, err error) {
+	if m == nil {
+		return
+	}
+	m.rpcDuration.WithLabelValues(endpoint, method).Observe(d.Seconds())
+	if err != nil {
+		m.rpcErrors.WithLabelValues(endpoint, method).Inc()
+	}
+}
+
+// RegisterQuorum exposes the blocks the providers of a quorum client
+// disagreed on, as counted by disagreements.
+func (m *Metrics) RegisterQuorum(disagreements func() uint64) {
+	if m == nil {
+		return
+	}
+	m.registry.MustRegister(prometheus.NewCounterFunc(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "rpc_quorum_disagreements_total",
+		Help:      "Blocks served differently by at least two JSON-RPC providers.",
+	}, func() float64 { return float64(disagreements()) }))
+}
+
+func (m *Metrics) ObserveStore(op string, d time.Duration) {
+	if m == nil {
+		return
+	}
+	m.storeDuration.WithLabelValues(op).Observe(d.Seconds())
+}
+
+// InstrumentHandler records the latency of the requests served by next.
+// Requests are labelled by the mux pattern they match rather than their
+// path, which would let clients create series at will.
+func (m *Metrics) InstrumentHandler(mux *http.ServeMux, next http.Handler) http.Handler {
+	if m == nil {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		route := response.Route(mux, r)
+
+		start := time.Now()
+		rec := response.NewRecorder(w)
+		next.ServeHTTP(rec, r)
+		m.httpDuration.WithLabelValues(route, r.Method, strconv.Itoa(rec.Status())).Observe(time.Since(start).Seconds())
+	})
+}
@@ -0,0 +1,95 @@
+package metrics
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func scrape(t *testing.T, m *Metrics) string {
+	rr := httptest.NewRecorder()
+	m.Handler().ServeHTTP(rr, httptest.NewRequest("GET", "/metrics", nil))
+	require.Equal(t, http.StatusOK, rr.Code)
+	return rr.Body.String()
+}
+
+func TestNilMetrics(t *testing.T) {
+	var m *Metrics
+	assert.NotPanics(t, func() {
+		m.SetChainHead(10)
+		m.BlockProcessed(10, 1)
+		m.AddSubscriptions(1)
+		m.ObserveRPC("http://node", "eth_blockNumber", time.Millisecond, nil)
+		m.ObserveStore("get", time.Millisecond)
+	})
+	assert.Equal(t, 0, m.Lag())
+
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	assert.NotNil(t, m.InstrumentHandler(http.NewServeMux(), next))
+}
+
+func TestLag(t *testing.T) {
+	m := New()
+	m.SetCurrentBlock(100)
+	assert.Equal(t, 0, m.Lag(), "head unknown")
+
+	m.SetChainHead(105)
+	assert.Equal(t, 5, m.Lag())
+
+	m.BlockProcessed(105, 2)
+	assert.Equal(t, 0, m.Lag())
+	assert.Equal(t, float64(1), testutil.ToFloat64(m.blocksProcessed))
+	assert.Equal(t, float64(2), testutil.ToFloat64(m.txsMatched))
+
+	m.SetChainHead(107)
+	body := scrape(t, m)
+	assert.Contains(t, body, "txparser_chain_head_block 107")
+	assert.Contains(t, body, "txparser_current_block 105")
+	assert.Contains(t, body, "txparser_block_lag 2")
+}
+
+func TestObserve(t *testing.T) {
+	m := New()
+	m.SetSubscriptions(3)
+	m.AddSubscriptions(-1)
+	m.ObserveRPC("http://node", "eth_blockNumber", time.Millisecond, nil)
+	m.ObserveRPC("http://node", "eth_blockNumber", time.Millisecond, errors.New("boom"))
+	m.ObserveStore("get", time.Millisecond)
+
+	assert.Equal(t, float64(2), testutil.ToFloat64(m.subscriptions))
+	assert.Equal(t, float64(1), testutil.ToFloat64(m.rpcErrors.WithLabelValues("http://node", "eth_blockNumber")))
+	body := scrape(t, m)
+	assert.Contains(t, body, `txparser_jsonrpc_request_duration_seconds_count{endpoint="http://node",method="eth_blockNumber"} 2`)
+	assert.Contains(t, body, `txparser_store_operation_duration_seconds_count{op="get"} 1`)
+}
+
+func TestInstrumentHandler(t *testing.T) {
+	m := New()
+	mux := http.NewServeMux()
+	mux.HandleFunc("/v1/get-transactions", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadRequest)
+	})
+	mux.HandleFunc("/v1/stream", func(w http.ResponseWriter, r *http.Request) {
+		_, ok := w.(http.Flusher)
+		assert.True(t, ok, "streams need to flush")
+		w.Write([]byte("data"))
+	})
+	h := m.InstrumentHandler(mux, mux)
+
+	for _, target := range []string{"/v1/get-transactions?address=0x1", "/v1/stream", "/random/path"} {
+		h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", target, nil))
+	}
+
+	body := scrape(t, m)
+	assert.Contains(t, body, `txparser_http_request_duration_seconds_count{method="GET",route="/v1/get-transactions",status="400"} 1`)
+	assert.Contains(t, body, `txparser_http_request_duration_seconds_count{method="GET",route="/v1/stream",status="200"} 1`)
+	assert.Contains(t, body, `route="unmatched",status="404"`)
+	assert.False(t, strings.Contains(body, "/random/path"))
+}
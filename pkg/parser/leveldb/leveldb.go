@@ -3,43 +3,121 @@ package leveldb
 import (
 	"context"
 	"encoding/json"
-	"fmt"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
 
+	"github.com/jmsilvadev/tx-parser/pkg/events"
 	"github.com/jmsilvadev/tx-parser/pkg/jsonrpc"
 	"github.com/jmsilvadev/tx-parser/pkg/logger"
+	"github.com/jmsilvadev/tx-parser/pkg/metrics"
 	"github.com/jmsilvadev/tx-parser/pkg/parser"
+	"github.com/jmsilvadev/tx-parser/pkg/tracing"
 	"github.com/syndtr/goleveldb/leveldb"
 	"github.com/syndtr/goleveldb/leveldb/opt"
+	"github.com/syndtr/goleveldb/leveldb/util"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.uber.org/zap"
 )
 
+var tracer = otel.Tracer("github.com/jmsilvadev/tx-parser/pkg/parser/leveldb")
+
 var _ parser.Parser = &DB{}
+var _ parser.Store = &DB{}
+var _ parser.SubscriptionChecker = &DB{}
+
+// Subscriptions are kept under subscription:<tenant>/<address>, while
+// subscribed:<address> counts the tenants subscribed to the address, its
+// transactions being stored once for all of them.
+const (
+	subscribedPrefix   = "subscribed:"
+	subscriptionPrefix = "subscription:"
+)
 
 type DB struct {
-	db      *leveldb.DB
-	jsonrpc jsonrpc.JsonRpcClient
-	logger  logger.Logger
+	db       *leveldb.DB
+	mu       sync.Mutex
+	jsonrpc  jsonrpc.JsonRpcClient
+	logger   logger.Logger
+	interval time.Duration
+	bus      *events.Bus
+	metrics  *metrics.Metrics
 }
 
-func New(path string, cli jsonrpc.JsonRpcClient, l logger.Logger) (*DB, error) {
+type Option func(*DB)
+
+func New(path string, cli jsonrpc.JsonRpcClient, l logger.Logger, options ...Option) (*DB, error) {
 	db, err := leveldb.OpenFile(path, &opt.Options{
 		ErrorIfMissing: false,
 	})
 	if err != nil {
 		return nil, err
 	}
-	return &DB{
-		db:      db,
-		jsonrpc: cli,
-		logger:  l,
-	}, nil
+	p := &DB{
+		db:       db,
+		jsonrpc:  cli,
+		logger:   l,
+		interval: 12 * time.Second,
+	}
+	for _, opt := range options {
+		opt(p)
+	}
+
+	if err := p.migrateSubscriptions(); err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	if p.metrics != nil {
+		p.metrics.SetCurrentBlock(p.GetCurrentBlock(context.Background()))
+		subscriptions := 0
+		p.Iterate(subscribedPrefix, func(key string, value []byte) bool {
+			subscriptions++
+			return true
+		})
+		p.metrics.SetSubscriptions(subscriptions)
+	}
+	return p, nil
+}
+
+// migrateSubscriptions gives the subscriptions made before the tenants
+// existed to the default tenant.
+func (p *DB) migrateSubscriptions() error {
+	batch := new(leveldb.Batch)
+	err := p.Iterate(subscribedPrefix, func(key string, value []byte) bool {
+		if string(value) == "true" {
+			address := strings.TrimPrefix(key, subscribedPrefix)
+			batch.Put([]byte(subscriptionKey(parser.DefaultTenant, address)), []byte("true"))
+			batch.Put([]byte(key), []byte("1"))
+		}
+		return true
+	})
+	if err != nil || batch.Len() == 0 {
+		return err
+	}
+	return p.db.Write(batch, nil)
+}
+
+func subscriptionKey(tenant, address string) string {
+	return subscriptionPrefix + tenant + "/" + address
+}
+
+// subscribers returns how many tenants subscribed to the address.
+func (p *DB) subscribers(address string) int {
+	data, err := p.get(subscribedPrefix + address)
+	if err != nil {
+		return 0
+	}
+	n, _ := strconv.Atoi(string(data))
+	return n
 }
 
 func (p *DB) GetCurrentBlock(ctx context.Context) int {
-	data, err := p.db.Get([]byte("currentBlock"), nil)
+	data, err := p.get("currentBlock")
 	if err != nil {
-		p.logger.Debug(err.Error())
+		logger.FromContext(ctx, p.logger).Debug("failed to read the current block", logger.Err(err))
 		if err == leveldb.ErrNotFound {
 			return 0
 		}
@@ -47,7 +125,7 @@ func (p *DB) GetCurrentBlock(ctx context.Context) int {
 	}
 	var block int
 	if err := json.Unmarshal(data, &block); err != nil {
-		p.logger.Debug(err.Error())
+		logger.FromContext(ctx, p.logger).Debug("failed to read the current block", logger.Err(err))
 		return 0
 	}
 	return block
@@ -56,52 +134,107 @@ func (p *DB) GetCurrentBlock(ctx context.Context) int {
 func (p *DB) SetCurrentBlock(ctx context.Context, block int) error {
 	data, err := json.Marshal(block)
 	if err != nil {
-		p.logger.Debug(err.Error())
+		logger.FromContext(ctx, p.logger).Debug("failed to store the current block", logger.Err(err))
 		return err
 	}
-	err = p.db.Put([]byte("currentBlock"), data, nil)
+	err = p.put("currentBlock", data)
 	if err != nil {
-		p.logger.Error(err.Error())
+		logger.FromContext(ctx, p.logger).Error("failed to store the current block", logger.Err(err))
 	}
 	return err
 }
 
+// Subscribe adds the address to the subscriptions of the tenant of ctx.
 func (p *DB) Subscribe(ctx context.Context, address string) bool {
-	_, err := p.db.Get([]byte("subscribed:"+strings.ToLower(address)), nil)
-	if err == nil {
+	address = strings.ToLower(address)
+	tenant := parser.TenantFromContext(ctx)
+	p.mu.Lock()
+	if p.Subscribed(ctx, address) {
+		p.mu.Unlock()
 		return false
 	}
 
-	if err != leveldb.ErrNotFound {
+	n := p.subscribers(address) + 1
+	batch := new(leveldb.Batch)
+	batch.Put([]byte(subscriptionKey(tenant, address)), []byte("true"))
+	batch.Put([]byte(subscribedPrefix+address), []byte(strconv.Itoa(n)))
+	err := p.write(batch)
+	p.mu.Unlock()
+	if err != nil {
+		logger.FromContext(ctx, p.logger).Error("failed to subscribe", logger.Address(address), logger.Err(err))
 		return false
 	}
+	if n == 1 {
+		p.metrics.AddSubscriptions(1)
+	}
 
-	err = p.db.Put([]byte("subscribed:"+strings.ToLower(address)), []byte("true"), nil)
-	if err != nil {
-		p.logger.Error(err.Error())
+	p.bus.Publish(ctx, events.SubscriptionAdded{Address: address})
+	return true
+}
+
+// Unsubscribe removes the address from the subscriptions of the tenant of
+// ctx. Its transactions are only no longer ingested once no tenant
+// subscribes to it.
+func (p *DB) Unsubscribe(ctx context.Context, address string) bool {
+	address = strings.ToLower(address)
+	tenant := parser.TenantFromContext(ctx)
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if !p.Subscribed(ctx, address) {
+		return false
 	}
+
+	n := p.subscribers(address) - 1
+	batch := new(leveldb.Batch)
+	batch.Delete([]byte(subscriptionKey(tenant, address)))
+	if n > 0 {
+		batch.Put([]byte(subscribedPrefix+address), []byte(strconv.Itoa(n)))
+	} else {
+		batch.Delete([]byte(subscribedPrefix + address))
+	}
+	if err := p.write(batch); err != nil {
+		logger.FromContext(ctx, p.logger).Error("failed to unsubscribe", logger.Address(address), logger.Err(err))
+		return false
+	}
+	if n <= 0 {
+		p.metrics.AddSubscriptions(-1)
+	}
+	return true
+}
+
+func (p *DB) Subscribed(ctx context.Context, address string) bool {
+	_, err := p.get(subscriptionKey(parser.TenantFromContext(ctx), strings.ToLower(address)))
 	return err == nil
 }
 
+// GetTransactions only returns the transactions of the addresses the
+// tenant of ctx subscribed.
 func (p *DB) GetTransactions(ctx context.Context, address string) []parser.Transaction {
-	data, err := p.db.Get([]byte("transactions:"+strings.ToLower(address)), nil)
+	if !p.Subscribed(ctx, address) {
+		return []parser.Transaction{}
+	}
+	return p.transactions(ctx, address)
+}
+
+func (p *DB) transactions(ctx context.Context, address string) []parser.Transaction {
+	data, err := p.get("transactions:" + strings.ToLower(address))
 	if err != nil {
 		if err == leveldb.ErrNotFound {
 			return []parser.Transaction{}
 		}
-		p.logger.Debug(err.Error())
+		logger.FromContext(ctx, p.logger).Debug("failed to read the transactions", logger.Address(address), logger.Err(err))
 		return nil
 	}
 	var transactions []parser.Transaction
 	if err := json.Unmarshal(data, &transactions); err != nil {
-		p.logger.Debug(err.Error())
+		logger.FromContext(ctx, p.logger).Debug("failed to read the transactions", logger.Address(address), logger.Err(err))
 		return nil
 	}
 	return transactions
 }
 
 func (p *DB) AddTransaction(ctx context.Context, address string, tx parser.Transaction) error {
-	transactions := p.GetTransactions(ctx, strings.ToLower(address))
+	transactions := p.transactions(ctx, strings.ToLower(address))
 	if len(transactions) == 0 {
 		transactions = []parser.Transaction{}
 	}
@@ -109,48 +242,181 @@ func (p *DB) AddTransaction(ctx context.Context, address string, tx parser.Trans
 	transactions = append(transactions, tx)
 	data, err := json.Marshal(transactions)
 	if err != nil {
-		p.logger.Debug(err.Error())
+		logger.FromContext(ctx, p.logger).Debug("failed to store the transaction", logger.Address(address), logger.Err(err))
 		return err
 	}
 
-	err = p.db.Put([]byte("transactions:"+strings.ToLower(address)), data, nil)
+	err = p.put("transactions:"+strings.ToLower(address), data)
 	if err != nil {
-		p.logger.Error(err.Error())
+		logger.FromContext(ctx, p.logger).Error("failed to store the transaction", logger.Address(address), logger.Err(err))
 	}
 	return err
 }
 
+func (p *DB) Get(key string) ([]byte, error) {
+	value, err := p.get(key)
+	if err == leveldb.ErrNotFound {
+		return nil, parser.ErrNotFound
+	}
+	return value, err
+}
+
+func (p *DB) Put(key string, value []byte) error {
+	return p.put(key, value)
+}
+
+func (p *DB) Delete(key string) error {
+	return p.delete(key)
+}
+
+// Iterate works on a snapshot, so fn may write to the store.
+func (p *DB) Iterate(prefix string, fn func(key string, value []byte) bool) error {
+	defer p.observe("iterate", time.Now())
+
+	snap, err := p.db.GetSnapshot()
+	if err != nil {
+		return err
+	}
+	defer snap.Release()
+
+	iter := snap.NewIterator(util.BytesPrefix([]byte(prefix)), nil)
+	defer iter.Release()
+	for iter.Next() {
+		value := append([]byte{}, iter.Value()...)
+		if !fn(string(iter.Key()), value) {
+			break
+		}
+	}
+	return iter.Error()
+}
+
+// get, put and delete time the operations on the underlying database.
+func (p *DB) get(key string) ([]byte, error) {
+	defer p.observe("get", time.Now())
+	return p.db.Get([]byte(key), nil)
+}
+
+func (p *DB) put(key string, value []byte) error {
+	defer p.observe("put", time.Now())
+	return p.db.Put([]byte(key), value, nil)
+}
+
+func (p *DB) delete(key string) error {
+	defer p.observe("delete", time.Now())
+	return p.db.Delete([]byte(key), nil)
+}
+
+func (p *DB) write(batch *leveldb.Batch) error {
+	defer p.observe("write", time.Now())
+	return p.db.Write(batch, nil)
+}
+
+func (p *DB) observe(op string, start time.Time) {
+	p.metrics.ObserveStore(op, time.Since(start))
+}
+
 func (p *DB) UpdateBlockNumber(ctx context.Context) {
 	for {
-		blockNumber, err := p.jsonrpc.GetCurrentBlockNumber(ctx)
-		if err != nil {
-			p.logger.Debug(err.Error())
-			continue
+		p.updateBlockNumber(ctx)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(p.interval):
 		}
+	}
+}
 
-		currentBlock := p.GetCurrentBlock(ctx)
-		if blockNumber > currentBlock {
-			err = p.SetCurrentBlock(ctx, blockNumber)
-			if err != nil {
-				p.logger.Debug(err.Error())
-				continue
-			}
-			transactions, err := p.jsonrpc.GetBlockTransactions(ctx, blockNumber)
-			if err == nil {
-				for _, tx := range transactions {
-					subscribedFrom, _ := p.db.Get([]byte("subscribed:"+strings.ToLower(tx.From)), nil)
-					subscribedTo, _ := p.db.Get([]byte("subscribed:"+strings.ToLower(tx.To)), nil)
-					if subscribedFrom != nil || subscribedTo != nil {
-						p.logger.Debug(fmt.Sprintf("AddTransaction address %s %s ", tx.From, tx.To))
-						p.AddTransaction(ctx, strings.ToLower(tx.From), tx)
-						p.AddTransaction(ctx, strings.ToLower(tx.To), tx)
-					}
-				}
-			} else {
-				p.logger.Debug(err.Error())
+func (p *DB) updateBlockNumber(ctx context.Context) {
+	ctx, span := tracer.Start(ctx, "parser.ingest")
+	defer span.End()
+
+	blockNumber, err := p.jsonrpc.GetCurrentBlockNumber(ctx)
+	if err != nil {
+		tracing.Fail(span, err)
+		p.logger.Debug("failed to get the chain head", logger.Err(err))
+		return
+	}
+	p.metrics.SetChainHead(blockNumber)
+	p.bus.Publish(ctx, events.ChainHeadObserved{BlockNumber: blockNumber})
+	span.SetAttributes(attribute.Int("chain.head", blockNumber))
+
+	currentBlock := p.GetCurrentBlock(ctx)
+	if blockNumber <= currentBlock {
+		return
+	}
+
+	span.SetAttributes(attribute.Int("block.number", blockNumber))
+	log := logger.With(p.logger, logger.BlockNumber(blockNumber))
+	ctx = logger.NewContext(ctx, log)
+	block, err := p.jsonrpc.GetBlock(ctx, blockNumber)
+	if err != nil {
+		tracing.Fail(span, err)
+		log.Debug("failed to get the block", logger.Err(err))
+		return
+	}
+
+	currentHash, _ := p.get("currentBlockHash")
+	if blockNumber == currentBlock+1 && len(currentHash) > 0 && !strings.EqualFold(block.ParentHash, string(currentHash)) {
+		log.Warn("previous block was replaced",
+			zap.Int("replaced_block", currentBlock),
+			zap.String("old_hash", string(currentHash)),
+			zap.String("new_hash", block.ParentHash),
+		)
+		p.bus.Publish(ctx, events.ReorgDetected{BlockNumber: currentBlock, OldHash: string(currentHash), NewHash: block.ParentHash})
+	}
+
+	matched, err := p.storeBlock(ctx, blockNumber, block)
+	if err != nil {
+		tracing.Fail(span, err)
+		log.Debug("failed to store the block", logger.Err(err))
+		return
+	}
+	p.metrics.BlockProcessed(blockNumber, matched)
+	span.SetAttributes(
+		attribute.Int("block.transactions", len(block.Transactions)),
+		attribute.Int("block.matched", matched),
+	)
+
+	p.bus.Publish(ctx, events.BlockIngested{
+		BlockNumber:  blockNumber,
+		Hash:         block.Hash,
+		Transactions: len(block.Transactions),
+		Matched:      matched,
+	})
+}
+
+// storeBlock saves the block as the current one along with the
+// transactions of the subscribed addresses, and returns how many matched.
+func (p *DB) storeBlock(ctx context.Context, blockNumber int, block *jsonrpc.Block) (int, error) {
+	ctx, span := tracer.Start(ctx, "leveldb.storeBlock")
+	defer span.End()
+
+	if err := p.SetCurrentBlock(ctx, blockNumber); err != nil {
+		tracing.Fail(span, err)
+		return 0, err
+	}
+	if err := p.put("currentBlockHash", []byte(block.Hash)); err != nil {
+		logger.FromContext(ctx, p.logger).Error("failed to store the block hash", logger.Err(err))
+	}
+
+	matched := 0
+	for _, tx := range block.Transactions {
+		var addresses []string
+		for _, address := range []string{strings.ToLower(tx.From), strings.ToLower(tx.To)} {
+			if p.subscribers(address) > 0 {
+				addresses = append(addresses, address)
 			}
 		}
-
-		time.Sleep(12 * time.Second)
+		if len(addresses) == 0 {
+			continue
+		}
+		logger.FromContext(ctx, p.logger).Debug("transaction matched", zap.String("hash", tx.Hash), zap.String("from", tx.From), zap.String("to", tx.To))
+		p.AddTransaction(ctx, strings.ToLower(tx.From), tx)
+		p.AddTransaction(ctx, strings.ToLower(tx.To), tx)
+		p.bus.Publish(ctx, events.TransactionMatched{Transaction: tx, Addresses: addresses})
+		matched++
 	}
+	span.SetAttributes(attribute.Int("block.matched", matched))
+	return matched, nil
 }
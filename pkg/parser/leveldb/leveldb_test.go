@@ -2,24 +2,32 @@ package leveldb
 
 import (
 	"context"
+	"net/http/httptest"
 	"os"
 	"testing"
 	"time"
 
+	"github.com/jmsilvadev/tx-parser/pkg/events"
 	"github.com/jmsilvadev/tx-parser/pkg/jsonrpc"
+	"github.com/jmsilvadev/tx-parser/pkg/jsonrpc/jsonrpctest"
 	"github.com/jmsilvadev/tx-parser/pkg/logger"
+	"github.com/jmsilvadev/tx-parser/pkg/metrics"
 	"github.com/jmsilvadev/tx-parser/pkg/parser"
 	"github.com/stretchr/testify/assert"
 	"go.uber.org/zap/zapcore"
 )
 
-var cliUrl = "https://ethereum-rpc.publicnode.com"
-
 func setupTestDB(t *testing.T) *DB {
 	l := logger.New(zapcore.DebugLevel)
-	cli := jsonrpc.NewEthereum(l, cliUrl)
+	node := jsonrpctest.NewServer(jsonrpctest.DefaultChain())
+	t.Cleanup(node.Close)
+	return setupTestDBWithNode(t, l, node)
+}
+
+func setupTestDBWithNode(t *testing.T, l logger.Logger, node *jsonrpctest.Server, options ...Option) *DB {
+	cli := jsonrpc.NewEthereum(l, node.URL)
 	path := "testdb"
-	db, err := New(path, cli, l)
+	db, err := New(path, cli, l, options...)
 	if err != nil {
 		t.Fatalf("Failed to create test database: %v", err)
 	}
@@ -32,12 +40,37 @@ func teardownTestDB(db *DB) {
 }
 
 func TestUpdateBlockNumber(t *testing.T) {
-	db := setupTestDB(t)
+	l := logger.New(zapcore.DebugLevel)
+	node := jsonrpctest.NewServer(jsonrpctest.DefaultChain())
+	defer node.Close()
+
+	db := setupTestDBWithNode(t, l, node)
 	defer teardownTestDB(db)
+	db.interval = 10 * time.Millisecond
+	db.Subscribe(context.Background(), "0xA1A1A1A1A1A1A1A1A1A1A1A1A1A1A1A1A1A1A1A1")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		db.UpdateBlockNumber(ctx)
+		close(done)
+	}()
 
-	// To cover lines
-	go db.UpdateBlockNumber(context.Background())
-	time.Sleep(time.Second)
+	assert.Eventually(t, func() bool { return db.GetCurrentBlock(ctx) == 104 }, time.Second, 10*time.Millisecond)
+	assert.Len(t, db.GetTransactions(ctx, "0xa1a1a1a1a1a1a1a1a1a1a1a1a1a1a1a1a1a1a1a1"), 1)
+
+	db.Subscribe(ctx, "0xb2b2b2b2b2b2b2b2b2b2b2b2b2b2b2b2b2b2b2b2")
+	node.Mine(&jsonrpctest.Transaction{
+		Hash:  "0xfeed",
+		From:  "0xa1a1a1a1a1a1a1a1a1a1a1a1a1a1a1a1a1a1a1a1",
+		To:    "0xb2b2b2b2b2b2b2b2b2b2b2b2b2b2b2b2b2b2b2b2",
+		Value: "0x1",
+	})
+	assert.Eventually(t, func() bool { return len(db.GetTransactions(ctx, "0xb2b2b2b2b2b2b2b2b2b2b2b2b2b2b2b2b2b2b2b2")) == 1 }, time.Second, 10*time.Millisecond)
+	assert.Len(t, db.GetTransactions(ctx, "0xa1a1a1a1a1a1a1a1a1a1a1a1a1a1a1a1a1a1a1a1"), 2)
+
+	cancel()
+	<-done
 }
 
 func TestGetSetCurrentBlock(t *testing.T) {
@@ -63,6 +96,11 @@ func TestSubscribe(t *testing.T) {
 	// Test subscribing the same address again
 	subscribed = db.Subscribe(context.Background(), "0x123")
 	assert.False(t, subscribed)
+
+	// Test unsubscribing it
+	assert.True(t, db.Unsubscribe(context.Background(), "0X123"))
+	assert.False(t, db.Unsubscribe(context.Background(), "0x123"))
+	assert.True(t, db.Subscribe(context.Background(), "0x123"))
 }
 
 func TestGetAddTransactions(t *testing.T) {
@@ -84,8 +122,160 @@ func TestGetAddTransactions(t *testing.T) {
 	err := db.AddTransaction(context.Background(), "0x123", tx)
 	assert.NoError(t, err)
 
-	// Test getting transactions for the address
+	// Test getting them once the address is subscribed
+	assert.Empty(t, db.GetTransactions(context.Background(), "0x123"))
+	db.Subscribe(context.Background(), "0x123")
 	txs = db.GetTransactions(context.Background(), "0x123")
 	assert.Len(t, txs, 1)
 	assert.Equal(t, tx, txs[0])
 }
+
+func TestStore(t *testing.T) {
+	db := setupTestDB(t)
+	defer teardownTestDB(db)
+
+	_, err := db.Get("a:1")
+	assert.ErrorIs(t, err, parser.ErrNotFound)
+
+	assert.NoError(t, db.Put("a:2", []byte("two")))
+	assert.NoError(t, db.Put("a:1", []byte("one")))
+	assert.NoError(t, db.Put("b:1", []byte("other")))
+
+	value, err := db.Get("a:1")
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("one"), value)
+
+	var keys []string
+	assert.NoError(t, db.Iterate("a:", func(key string, value []byte) bool {
+		keys = append(keys, key)
+		return true
+	}))
+	assert.Equal(t, []string{"a:1", "a:2"}, keys)
+
+	assert.NoError(t, db.Delete("a:1"))
+	_, err = db.Get("a:1")
+	assert.ErrorIs(t, err, parser.ErrNotFound)
+}
+
+func TestEvents(t *testing.T) {
+	l := logger.New(zapcore.DebugLevel)
+	node := jsonrpctest.NewServer(jsonrpctest.DefaultChain())
+	defer node.Close()
+
+	bus := events.New()
+	sub := bus.Subscribe(16, events.Drop)
+	db := setupTestDBWithNode(t, l, node, WithBus(bus))
+	defer teardownTestDB(db)
+	ctx := context.Background()
+
+	assert.True(t, db.Subscribe(ctx, "0xC3C3C3C3C3C3C3C3C3C3C3C3C3C3C3C3C3C3C3C3"))
+	assert.False(t, db.Subscribe(ctx, "0xc3c3c3c3c3c3c3c3c3c3c3c3c3c3c3c3c3c3c3c3"))
+	assert.Equal(t, events.SubscriptionAdded{Address: "0xc3c3c3c3c3c3c3c3c3c3c3c3c3c3c3c3c3c3c3c3"}, <-sub.C)
+
+	db.updateBlockNumber(ctx)
+	assert.Equal(t, events.ChainHeadObserved{BlockNumber: 104}, <-sub.C)
+	matched := (<-sub.C).(events.TransactionMatched)
+	assert.Equal(t, 104, matched.Transaction.BlockNumber)
+	ingested := (<-sub.C).(events.BlockIngested)
+	assert.Equal(t, 104, ingested.BlockNumber)
+	assert.Equal(t, 1, ingested.Matched)
+
+	oldHash := ingested.Hash
+	node.Reorg(1, false)
+	node.Mine()
+	db.updateBlockNumber(ctx)
+	assert.Equal(t, events.ChainHeadObserved{BlockNumber: 105}, <-sub.C)
+	reorg := (<-sub.C).(events.ReorgDetected)
+	assert.Equal(t, 104, reorg.BlockNumber)
+	assert.Equal(t, oldHash, reorg.OldHash)
+	assert.Equal(t, 105, (<-sub.C).(events.BlockIngested).BlockNumber)
+
+	// the head is reported even when there is nothing new to ingest
+	db.updateBlockNumber(ctx)
+	assert.Equal(t, events.ChainHeadObserved{BlockNumber: 105}, <-sub.C)
+	assert.Empty(t, sub.C)
+}
+
+func TestMetrics(t *testing.T) {
+	l := logger.New(zapcore.DebugLevel)
+	node := jsonrpctest.NewServer(jsonrpctest.DefaultChain())
+	defer node.Close()
+
+	db := setupTestDB(t)
+	db.Subscribe(context.Background(), "0xa1a1a1a1a1a1a1a1a1a1a1a1a1a1a1a1a1a1a1a1")
+	db.Subscribe(context.Background(), "0xb2b2b2b2b2b2b2b2b2b2b2b2b2b2b2b2b2b2b2b2")
+	db.db.Close()
+
+	// the subscriptions already stored are counted on open
+	m := metrics.New()
+	db = setupTestDBWithNode(t, l, node, WithMetrics(m))
+	defer teardownTestDB(db)
+
+	assert.True(t, db.Unsubscribe(context.Background(), "0xb2b2b2b2b2b2b2b2b2b2b2b2b2b2b2b2b2b2b2b2"))
+	db.updateBlockNumber(context.Background())
+
+	rr := httptest.NewRecorder()
+	m.Handler().ServeHTTP(rr, httptest.NewRequest("GET", "/metrics", nil))
+	body := rr.Body.String()
+	assert.Contains(t, body, "txparser_subscriptions 1")
+	assert.Contains(t, body, "txparser_chain_head_block 104")
+	assert.Contains(t, body, "txparser_block_lag 0")
+	assert.Contains(t, body, "txparser_blocks_processed_total 1")
+	assert.Contains(t, body, "txparser_transactions_matched_total 1")
+	assert.Contains(t, body, `txparser_store_operation_duration_seconds_count{op="write"} 1`)
+}
+
+func TestTenants(t *testing.T) {
+	l := logger.New(zapcore.DebugLevel)
+	node := jsonrpctest.NewServer(jsonrpctest.DefaultChain())
+	defer node.Close()
+
+	m := metrics.New()
+	db := setupTestDBWithNode(t, l, node, WithMetrics(m))
+	defer teardownTestDB(db)
+	acme := parser.NewTenantContext(context.Background(), "acme")
+	globex := parser.NewTenantContext(context.Background(), "globex")
+	address := "0xc3c3c3c3c3c3c3c3c3c3c3c3c3c3c3c3c3c3c3c3"
+
+	assert.True(t, db.Subscribe(acme, address))
+	assert.True(t, db.Subscribe(globex, address))
+	assert.False(t, db.Subscribe(globex, address))
+	assert.True(t, db.Subscribe(acme, "0xa1a1a1a1a1a1a1a1a1a1a1a1a1a1a1a1a1a1a1a1"))
+	assert.True(t, db.Subscribed(acme, address))
+	assert.False(t, db.Subscribed(context.Background(), address))
+	assert.Equal(t, 2, db.subscribers(address))
+
+	db.updateBlockNumber(acme)
+
+	// the shared address is stored once
+	assert.Len(t, db.transactions(acme, address), 1)
+	assert.Len(t, db.GetTransactions(acme, address), 1)
+	assert.Len(t, db.GetTransactions(globex, address), 1)
+	assert.Empty(t, db.GetTransactions(globex, "0xa1a1a1a1a1a1a1a1a1a1a1a1a1a1a1a1a1a1a1a1"))
+	assert.Empty(t, db.GetTransactions(context.Background(), address))
+
+	// the address stays ingested while another tenant subscribes to it
+	assert.True(t, db.Unsubscribe(acme, address))
+	assert.Empty(t, db.GetTransactions(acme, address))
+	assert.Equal(t, 1, db.subscribers(address))
+	assert.True(t, db.Unsubscribe(globex, address))
+	assert.Equal(t, 0, db.subscribers(address))
+
+	rr := httptest.NewRecorder()
+	m.Handler().ServeHTTP(rr, httptest.NewRequest("GET", "/metrics", nil))
+	assert.Contains(t, rr.Body.String(), "txparser_subscriptions 1")
+}
+
+func TestMigrateSubscriptions(t *testing.T) {
+	db := setupTestDB(t)
+	assert.NoError(t, db.Put("subscribed:0x123", []byte("true")))
+	db.db.Close()
+
+	// the subscriptions stored before the tenants go to the default one
+	db = setupTestDB(t)
+	defer teardownTestDB(db)
+	assert.True(t, db.Subscribed(context.Background(), "0x123"))
+	assert.False(t, db.Subscribed(parser.NewTenantContext(context.Background(), "acme"), "0x123"))
+	assert.True(t, db.Subscribe(parser.NewTenantContext(context.Background(), "acme"), "0x123"))
+	assert.Equal(t, 2, db.subscribers("0x123"))
+}
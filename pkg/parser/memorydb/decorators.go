@@ -0,0 +1,21 @@
+package memorydb
+
+import (
+	"github.com/jmsilvadev/tx-parser/pkg/events"
+	"github.com/jmsilvadev/tx-parser/pkg/metrics"
+)
+
+// WithBus publishes the ingestion events on the bus.
+func WithBus(v *events.Bus) Option {
+	return func(p *DB) {
+		p.bus = v
+	}
+}
+
+// WithMetrics records the chain head, the ingested blocks and the
+// subscriptions.
+func WithMetrics(v *metrics.Metrics) Option {
+	return func(p *DB) {
+		p.metrics = v
+	}
+}
@@ -2,34 +2,61 @@ package memorydb
 
 import (
 	"context"
-	"fmt"
+	"sort"
 	"strings"
 	"sync"
 	"time"
 
+	"github.com/jmsilvadev/tx-parser/pkg/events"
 	"github.com/jmsilvadev/tx-parser/pkg/jsonrpc"
 	"github.com/jmsilvadev/tx-parser/pkg/logger"
+	"github.com/jmsilvadev/tx-parser/pkg/metrics"
 	"github.com/jmsilvadev/tx-parser/pkg/parser"
+	"github.com/jmsilvadev/tx-parser/pkg/tracing"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.uber.org/zap"
 )
 
+var tracer = otel.Tracer("github.com/jmsilvadev/tx-parser/pkg/parser/memorydb")
+
 var _ parser.Parser = &DB{}
+var _ parser.Store = &DB{}
+var _ parser.SubscriptionChecker = &DB{}
 
 type DB struct {
-	currentBlock  int
-	subscriptions map[string]bool
-	transactions  map[string][]parser.Transaction
-	jsonrpc       jsonrpc.JsonRpcClient
-	logger        logger.Logger
-	mu            sync.Mutex
+	currentBlock int
+	currentHash  string
+	// subscribers counts the tenants subscribed to each address, the
+	// transactions of an address being stored once for all of them
+	subscribers  map[string]int
+	tenants      map[string]map[string]bool
+	transactions map[string][]parser.Transaction
+	jsonrpc      jsonrpc.JsonRpcClient
+	logger       logger.Logger
+	mu           sync.Mutex
+	interval     time.Duration
+	kv           map[string][]byte
+	bus          *events.Bus
+	metrics      *metrics.Metrics
 }
 
-func New(cli jsonrpc.JsonRpcClient, l logger.Logger) *DB {
-	return &DB{
-		subscriptions: make(map[string]bool),
-		transactions:  make(map[string][]parser.Transaction),
-		jsonrpc:       cli,
-		logger:        l,
+type Option func(*DB)
+
+func New(cli jsonrpc.JsonRpcClient, l logger.Logger, options ...Option) *DB {
+	db := &DB{
+		subscribers:  make(map[string]int),
+		tenants:      make(map[string]map[string]bool),
+		transactions: make(map[string][]parser.Transaction),
+		kv:           make(map[string][]byte),
+		jsonrpc:      cli,
+		logger:       l,
+		interval:     12 * time.Second,
 	}
+	for _, opt := range options {
+		opt(db)
+	}
+	return db
 }
 
 func (p *DB) GetCurrentBlock(ctx context.Context) int {
@@ -38,52 +65,210 @@ func (p *DB) GetCurrentBlock(ctx context.Context) int {
 	return p.currentBlock
 }
 
+// Subscribe adds the address to the subscriptions of the tenant of ctx.
 func (p *DB) Subscribe(ctx context.Context, address string) bool {
+	address = strings.ToLower(address)
+	tenant := parser.TenantFromContext(ctx)
 	p.mu.Lock()
-	defer p.mu.Unlock()
+	logger.FromContext(ctx, p.logger).Debug("subscribe", logger.Address(address), zap.String("tenant", tenant))
+
+	if p.tenants[tenant][address] {
+		p.mu.Unlock()
+		return false
+	}
 
-	p.logger.Debug(strings.ToLower(address))
+	if p.tenants[tenant] == nil {
+		p.tenants[tenant] = make(map[string]bool)
+	}
+	p.tenants[tenant][address] = true
+	p.subscribers[address]++
+	first := p.subscribers[address] == 1
+	p.mu.Unlock()
+	if first {
+		p.metrics.AddSubscriptions(1)
+	}
+
+	p.bus.Publish(ctx, events.SubscriptionAdded{Address: address})
+	return true
+}
+
+// Unsubscribe removes the address from the subscriptions of the tenant of
+// ctx. Its transactions are only no longer ingested once no tenant
+// subscribes to it.
+func (p *DB) Unsubscribe(ctx context.Context, address string) bool {
+	address = strings.ToLower(address)
+	tenant := parser.TenantFromContext(ctx)
+	p.mu.Lock()
+	defer p.mu.Unlock()
 
-	if _, exists := p.subscriptions[strings.ToLower(address)]; exists {
+	if !p.tenants[tenant][address] {
 		return false
 	}
 
-	p.subscriptions[strings.ToLower(address)] = true
+	delete(p.tenants[tenant], address)
+	p.subscribers[address]--
+	if p.subscribers[address] == 0 {
+		delete(p.subscribers, address)
+		p.metrics.AddSubscriptions(-1)
+	}
 	return true
 }
 
+// GetTransactions only returns the transactions of the addresses the
+// tenant of ctx subscribed.
 func (p *DB) GetTransactions(ctx context.Context, address string) []parser.Transaction {
+	address = strings.ToLower(address)
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if !p.tenants[parser.TenantFromContext(ctx)][address] {
+		return nil
+	}
+	return p.transactions[address]
+}
+
+func (p *DB) Subscribed(ctx context.Context, address string) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.tenants[parser.TenantFromContext(ctx)][strings.ToLower(address)]
+}
+
+func (p *DB) Get(key string) ([]byte, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	value, ok := p.kv[key]
+	if !ok {
+		return nil, parser.ErrNotFound
+	}
+	return value, nil
+}
+
+func (p *DB) Put(key string, value []byte) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.kv[key] = append([]byte{}, value...)
+	return nil
+}
+
+func (p *DB) Delete(key string) error {
 	p.mu.Lock()
 	defer p.mu.Unlock()
-	return p.transactions[strings.ToLower(address)]
+	delete(p.kv, key)
+	return nil
+}
+
+// Iterate works on a snapshot, so fn may write to the store.
+func (p *DB) Iterate(prefix string, fn func(key string, value []byte) bool) error {
+	p.mu.Lock()
+	var keys []string
+	for key := range p.kv {
+		if strings.HasPrefix(key, prefix) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	values := make([][]byte, len(keys))
+	for i, key := range keys {
+		values[i] = p.kv[key]
+	}
+	p.mu.Unlock()
+
+	for i, key := range keys {
+		if !fn(key, values[i]) {
+			break
+		}
+	}
+	return nil
 }
 
 func (p *DB) UpdateBlockNumber(ctx context.Context) {
 	for {
-		blockNumber, err := p.jsonrpc.GetCurrentBlockNumber(ctx)
-		if err != nil {
-			p.logger.Error(err.Error())
-			continue
+		p.updateBlockNumber(ctx)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(p.interval):
 		}
+	}
+}
 
-		p.mu.Lock()
-		if blockNumber > p.currentBlock {
-			p.currentBlock = blockNumber
-			transactions, err := p.jsonrpc.GetBlockTransactions(ctx, blockNumber)
-			if err == nil {
-				for _, tx := range transactions {
-					if p.subscriptions[strings.ToLower(tx.From)] || p.subscriptions[strings.ToLower(tx.To)] {
-						p.logger.Debug(fmt.Sprintf("%s | %s", tx.From, tx.To))
-						p.transactions[strings.ToLower(tx.From)] = append(p.transactions[strings.ToLower(tx.From)], tx)
-						p.transactions[strings.ToLower(tx.To)] = append(p.transactions[strings.ToLower(tx.To)], tx)
-					}
-				}
-			} else {
-				p.logger.Error(err.Error())
+func (p *DB) updateBlockNumber(ctx context.Context) {
+	ctx, span := tracer.Start(ctx, "parser.ingest")
+	defer span.End()
+
+	blockNumber, err := p.jsonrpc.GetCurrentBlockNumber(ctx)
+	if err != nil {
+		tracing.Fail(span, err)
+		p.logger.Error("failed to get the chain head", logger.Err(err))
+		return
+	}
+	p.metrics.SetChainHead(blockNumber)
+	p.bus.Publish(ctx, events.ChainHeadObserved{BlockNumber: blockNumber})
+	span.SetAttributes(attribute.Int("chain.head", blockNumber))
+
+	p.mu.Lock()
+	currentBlock, currentHash := p.currentBlock, p.currentHash
+	p.mu.Unlock()
+	if blockNumber <= currentBlock {
+		return
+	}
+
+	span.SetAttributes(attribute.Int("block.number", blockNumber))
+	log := logger.With(p.logger, logger.BlockNumber(blockNumber))
+	ctx = logger.NewContext(ctx, log)
+	block, err := p.jsonrpc.GetBlock(ctx, blockNumber)
+	if err != nil {
+		tracing.Fail(span, err)
+		log.Error("failed to get the block", logger.Err(err))
+		return
+	}
+
+	var published []events.Event
+	if blockNumber == currentBlock+1 && currentHash != "" && !strings.EqualFold(block.ParentHash, currentHash) {
+		log.Warn("previous block was replaced",
+			zap.Int("replaced_block", currentBlock),
+			zap.String("old_hash", currentHash),
+			zap.String("new_hash", block.ParentHash),
+		)
+		published = append(published, events.ReorgDetected{BlockNumber: currentBlock, OldHash: currentHash, NewHash: block.ParentHash})
+	}
+
+	matched := 0
+	p.mu.Lock()
+	p.currentBlock = blockNumber
+	p.currentHash = block.Hash
+	for _, tx := range block.Transactions {
+		var addresses []string
+		for _, address := range []string{strings.ToLower(tx.From), strings.ToLower(tx.To)} {
+			if p.subscribers[address] > 0 {
+				addresses = append(addresses, address)
 			}
 		}
-		p.mu.Unlock()
+		if len(addresses) == 0 {
+			continue
+		}
+		log.Debug("transaction matched", zap.String("hash", tx.Hash), zap.String("from", tx.From), zap.String("to", tx.To))
+		p.transactions[strings.ToLower(tx.From)] = append(p.transactions[strings.ToLower(tx.From)], tx)
+		p.transactions[strings.ToLower(tx.To)] = append(p.transactions[strings.ToLower(tx.To)], tx)
+		published = append(published, events.TransactionMatched{Transaction: tx, Addresses: addresses})
+		matched++
+	}
+	p.mu.Unlock()
+	p.metrics.BlockProcessed(blockNumber, matched)
+	span.SetAttributes(
+		attribute.Int("block.transactions", len(block.Transactions)),
+		attribute.Int("block.matched", matched),
+	)
+
+	published = append(published, events.BlockIngested{
+		BlockNumber:  blockNumber,
+		Hash:         block.Hash,
+		Transactions: len(block.Transactions),
+		Matched:      matched,
+	})
 
-		time.Sleep(12 * time.Second)
+	// events are published unlocked, consumers may call back into the store
+	for _, e := range published {
+		p.bus.Publish(ctx, e)
 	}
 }
@@ -5,28 +5,57 @@ import (
 	"testing"
 	"time"
 
+	"github.com/jmsilvadev/tx-parser/pkg/events"
 	"github.com/jmsilvadev/tx-parser/pkg/jsonrpc"
+	"github.com/jmsilvadev/tx-parser/pkg/jsonrpc/jsonrpctest"
 	"github.com/jmsilvadev/tx-parser/pkg/logger"
 	"github.com/jmsilvadev/tx-parser/pkg/parser"
 	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace/noop"
 	"go.uber.org/zap/zapcore"
 )
 
-var cliUrl = "https://ethereum-rpc.publicnode.com"
-
 func TestUpdateBlockNumber(t *testing.T) {
 	l := logger.New(zapcore.DebugLevel)
-	cli := jsonrpc.NewEthereum(l, cliUrl)
+	node := jsonrpctest.NewServer(jsonrpctest.DefaultChain())
+	defer node.Close()
+
+	cli := jsonrpc.NewEthereum(l, node.URL)
 	db := New(cli, l)
+	db.interval = 10 * time.Millisecond
+	db.Subscribe(context.Background(), "0xA1A1A1A1A1A1A1A1A1A1A1A1A1A1A1A1A1A1A1A1")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		db.UpdateBlockNumber(ctx)
+		close(done)
+	}()
+
+	assert.Eventually(t, func() bool { return db.GetCurrentBlock(ctx) == 104 }, time.Second, 10*time.Millisecond)
+	assert.Len(t, db.GetTransactions(ctx, "0xa1a1a1a1a1a1a1a1a1a1a1a1a1a1a1a1a1a1a1a1"), 1)
 
-	// To cover lines
-	go db.UpdateBlockNumber(context.Background())
-	time.Sleep(time.Second)
+	db.Subscribe(ctx, "0xb2b2b2b2b2b2b2b2b2b2b2b2b2b2b2b2b2b2b2b2")
+	node.Mine(&jsonrpctest.Transaction{
+		Hash:  "0xfeed",
+		From:  "0xa1a1a1a1a1a1a1a1a1a1a1a1a1a1a1a1a1a1a1a1",
+		To:    "0xb2b2b2b2b2b2b2b2b2b2b2b2b2b2b2b2b2b2b2b2",
+		Value: "0x1",
+	})
+	assert.Eventually(t, func() bool { return len(db.GetTransactions(ctx, "0xb2b2b2b2b2b2b2b2b2b2b2b2b2b2b2b2b2b2b2b2")) == 1 }, time.Second, 10*time.Millisecond)
+	assert.Len(t, db.GetTransactions(ctx, "0xa1a1a1a1a1a1a1a1a1a1a1a1a1a1a1a1a1a1a1a1"), 2)
+
+	cancel()
+	<-done
 }
 
 func TestGetCurrentBlock(t *testing.T) {
 	l := logger.New(zapcore.DebugLevel)
-	cli := jsonrpc.NewEthereum(l, cliUrl)
+	cli := jsonrpc.NewEthereum(l, "http://localhost:8545")
 	db := New(cli, l)
 
 	block := db.GetCurrentBlock(context.Background())
@@ -42,7 +71,7 @@ func TestGetCurrentBlock(t *testing.T) {
 
 func TestSubscribe(t *testing.T) {
 	l := logger.New(zapcore.DebugLevel)
-	cli := jsonrpc.NewEthereum(l, cliUrl)
+	cli := jsonrpc.NewEthereum(l, "http://localhost:8545")
 	db := New(cli, l)
 
 	subscribed := db.Subscribe(context.Background(), "0x123")
@@ -50,11 +79,15 @@ func TestSubscribe(t *testing.T) {
 
 	subscribed = db.Subscribe(context.Background(), "0x123")
 	assert.False(t, subscribed)
+
+	assert.True(t, db.Unsubscribe(context.Background(), "0X123"))
+	assert.False(t, db.Unsubscribe(context.Background(), "0x123"))
+	assert.True(t, db.Subscribe(context.Background(), "0x123"))
 }
 
 func TestGetTransactions(t *testing.T) {
 	l := logger.New(zapcore.DebugLevel)
-	cli := jsonrpc.NewEthereum(l, cliUrl)
+	cli := jsonrpc.NewEthereum(l, "http://localhost:8545")
 	db := New(cli, l)
 
 	txs := db.GetTransactions(context.Background(), "0x123")
@@ -72,7 +105,193 @@ func TestGetTransactions(t *testing.T) {
 	db.transactions["0x123"] = append(db.transactions["0x123"], tx)
 	db.mu.Unlock()
 
+	// only the addresses subscribed by the caller are returned
+	assert.Empty(t, db.GetTransactions(context.Background(), "0x123"))
+
+	db.Subscribe(context.Background(), "0x123")
 	txs = db.GetTransactions(context.Background(), "0x123")
 	assert.Len(t, txs, 1)
 	assert.Equal(t, tx, txs[0])
 }
+
+func TestTenants(t *testing.T) {
+	l := logger.New(zapcore.DebugLevel)
+	node := jsonrpctest.NewServer(jsonrpctest.DefaultChain())
+	defer node.Close()
+
+	bus := events.New()
+	sub := bus.Subscribe(16, events.Drop, events.KindTransactionMatched)
+	db := New(jsonrpc.NewEthereum(l, node.URL), l, WithBus(bus))
+	acme := parser.NewTenantContext(context.Background(), "acme")
+	globex := parser.NewTenantContext(context.Background(), "globex")
+	address := "0xc3c3c3c3c3c3c3c3c3c3c3c3c3c3c3c3c3c3c3c3"
+
+	assert.True(t, db.Subscribe(acme, address))
+	assert.True(t, db.Subscribe(globex, address))
+	assert.True(t, db.Subscribe(acme, "0xa1a1a1a1a1a1a1a1a1a1a1a1a1a1a1a1a1a1a1a1"))
+	assert.True(t, db.Subscribed(acme, address))
+	assert.False(t, db.Subscribed(context.Background(), address))
+
+	db.updateBlockNumber(acme)
+
+	// the shared address is matched and stored once
+	assert.Len(t, sub.C, 1)
+	assert.Len(t, db.transactions[address], 1)
+	assert.Len(t, db.GetTransactions(acme, address), 1)
+	assert.Len(t, db.GetTransactions(globex, address), 1)
+	assert.Empty(t, db.GetTransactions(globex, "0xa1a1a1a1a1a1a1a1a1a1a1a1a1a1a1a1a1a1a1a1"))
+	assert.Empty(t, db.GetTransactions(context.Background(), address))
+
+	// the address stays ingested while another tenant subscribes to it
+	assert.True(t, db.Unsubscribe(acme, address))
+	assert.Empty(t, db.GetTransactions(acme, address))
+	assert.Equal(t, 1, db.subscribers[address])
+	assert.True(t, db.Unsubscribe(globex, address))
+	assert.NotContains(t, db.subscribers, address)
+}
+
+func TestReplayIngestion(t *testing.T) {
+	l := logger.New(zapcore.DebugLevel)
+	dir := t.TempDir()
+	node := jsonrpctest.NewServer(jsonrpctest.DefaultChain())
+	defer node.Close()
+
+	rec, err := jsonrpc.NewRecorder(l, jsonrpc.NewEthereum(l, node.URL), dir)
+	assert.NoError(t, err)
+	live := New(rec, l)
+	live.Subscribe(context.Background(), "0xa1a1a1a1a1a1a1a1a1a1a1a1a1a1a1a1a1a1a1a1")
+	live.updateBlockNumber(context.Background())
+
+	replay, err := jsonrpc.NewReplay(l, dir)
+	assert.NoError(t, err)
+	db := New(replay, l)
+	db.Subscribe(context.Background(), "0xa1a1a1a1a1a1a1a1a1a1a1a1a1a1a1a1a1a1a1a1")
+	db.updateBlockNumber(context.Background())
+
+	assert.Equal(t, live.GetCurrentBlock(context.Background()), db.GetCurrentBlock(context.Background()))
+	assert.Equal(t,
+		live.GetTransactions(context.Background(), "0xa1a1a1a1a1a1a1a1a1a1a1a1a1a1a1a1a1a1a1a1"),
+		db.GetTransactions(context.Background(), "0xa1a1a1a1a1a1a1a1a1a1a1a1a1a1a1a1a1a1a1a1"))
+}
+
+func TestStore(t *testing.T) {
+	l := logger.New(zapcore.DebugLevel)
+	db := New(jsonrpc.NewEthereum(l, "http://localhost:8545"), l)
+
+	_, err := db.Get("a:1")
+	assert.ErrorIs(t, err, parser.ErrNotFound)
+
+	assert.NoError(t, db.Put("a:2", []byte("two")))
+	assert.NoError(t, db.Put("a:1", []byte("one")))
+	assert.NoError(t, db.Put("b:1", []byte("other")))
+
+	value, err := db.Get("a:1")
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("one"), value)
+
+	var keys []string
+	assert.NoError(t, db.Iterate("a:", func(key string, value []byte) bool {
+		keys = append(keys, key)
+		return true
+	}))
+	assert.Equal(t, []string{"a:1", "a:2"}, keys)
+
+	assert.NoError(t, db.Delete("a:1"))
+	_, err = db.Get("a:1")
+	assert.ErrorIs(t, err, parser.ErrNotFound)
+}
+
+func TestEvents(t *testing.T) {
+	l := logger.New(zapcore.DebugLevel)
+	node := jsonrpctest.NewServer(jsonrpctest.DefaultChain())
+	defer node.Close()
+
+	bus := events.New()
+	sub := bus.Subscribe(16, events.Drop)
+	db := New(jsonrpc.NewEthereum(l, node.URL), l, WithBus(bus))
+	ctx := context.Background()
+
+	db.Subscribe(ctx, "0xC3C3C3C3C3C3C3C3C3C3C3C3C3C3C3C3C3C3C3C3")
+	db.Subscribe(ctx, "0xc3c3c3c3c3c3c3c3c3c3c3c3c3c3c3c3c3c3c3c3")
+	assert.Equal(t, events.SubscriptionAdded{Address: "0xc3c3c3c3c3c3c3c3c3c3c3c3c3c3c3c3c3c3c3c3"}, <-sub.C)
+
+	db.updateBlockNumber(ctx)
+	assert.Equal(t, events.ChainHeadObserved{BlockNumber: 104}, <-sub.C)
+	matched := (<-sub.C).(events.TransactionMatched)
+	assert.Equal(t, 104, matched.Transaction.BlockNumber)
+	assert.Equal(t, []string{"0xc3c3c3c3c3c3c3c3c3c3c3c3c3c3c3c3c3c3c3c3"}, matched.Addresses)
+	ingested := (<-sub.C).(events.BlockIngested)
+	assert.Equal(t, 104, ingested.BlockNumber)
+	assert.Equal(t, 1, ingested.Matched)
+
+	oldHash := ingested.Hash
+	node.Reorg(1, false)
+	node.Mine()
+	db.updateBlockNumber(ctx)
+	assert.Equal(t, events.ChainHeadObserved{BlockNumber: 105}, <-sub.C)
+	reorg := (<-sub.C).(events.ReorgDetected)
+	assert.Equal(t, 104, reorg.BlockNumber)
+	assert.Equal(t, oldHash, reorg.OldHash)
+	assert.NotEqual(t, oldHash, reorg.NewHash)
+	assert.Equal(t, 105, (<-sub.C).(events.BlockIngested).BlockNumber)
+
+	// the head is reported even when there is nothing new to ingest
+	db.updateBlockNumber(ctx)
+	assert.Equal(t, events.ChainHeadObserved{BlockNumber: 105}, <-sub.C)
+	assert.Empty(t, sub.C)
+}
+
+func TestEventConsumerUsesStore(t *testing.T) {
+	l := logger.New(zapcore.DebugLevel)
+	node := jsonrpctest.NewServer(jsonrpctest.DefaultChain())
+	defer node.Close()
+
+	bus := events.New()
+	db := New(jsonrpc.NewEthereum(l, node.URL), l, WithBus(bus))
+	db.Subscribe(context.Background(), "0xc3c3c3c3c3c3c3c3c3c3c3c3c3c3c3c3c3c3c3c3")
+
+	// a blocking subscriber without buffer runs while ingestion waits,
+	// events must be published without holding the store lock
+	sub := bus.Subscribe(0, events.Block, events.KindTransactionMatched)
+	done := make(chan struct{})
+	go func() {
+		e := (<-sub.C).(events.TransactionMatched)
+		db.Put("seen:"+e.Transaction.Hash, []byte("1"))
+		db.GetTransactions(context.Background(), e.Addresses[0])
+		close(done)
+	}()
+	db.updateBlockNumber(context.Background())
+	<-done
+
+	var seen []string
+	db.Iterate("seen:", func(key string, value []byte) bool {
+		seen = append(seen, key)
+		return true
+	})
+	assert.Len(t, seen, 1)
+}
+
+func TestTracing(t *testing.T) {
+	l := logger.New(zapcore.DebugLevel)
+	node := jsonrpctest.NewServer(jsonrpctest.DefaultChain())
+	defer node.Close()
+
+	recorder := tracetest.NewSpanRecorder()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+	defer otel.SetTracerProvider(noop.NewTracerProvider())
+
+	db := New(jsonrpc.NewEthereum(l, node.URL), l)
+	db.Subscribe(context.Background(), "0xc3c3c3c3c3c3c3c3c3c3c3c3c3c3c3c3c3c3c3c3")
+	db.updateBlockNumber(context.Background())
+
+	// the calls to the node are children of the ingestion span
+	spans := recorder.Ended()
+	assert.Len(t, spans, 3)
+	ingest := spans[len(spans)-1]
+	assert.Equal(t, "parser.ingest", ingest.Name())
+	for _, span := range spans[:len(spans)-1] {
+		assert.Equal(t, ingest.SpanContext().SpanID(), span.Parent().SpanID())
+	}
+	assert.Contains(t, ingest.Attributes(), attribute.Int("block.number", 104))
+	assert.Contains(t, ingest.Attributes(), attribute.Int("block.matched", 1))
+}
@@ -1,18 +1,36 @@
 package parser
 
-import "context"
+import (
+	"context"
+	"errors"
+)
+
+var ErrNotFound = errors.New("not found")
 
 type Parser interface {
 	// last parsed block
 	GetCurrentBlock(context.Context) int
 	// add address to observer
 	Subscribe(context.Context, string) bool
+	// remove address from observer, its transactions are kept
+	Unsubscribe(context.Context, string) bool
 	// list of inbound or outbound transactions for an address
 	GetTransactions(context.Context, string) []Transaction
 	// routine to fetch the transactions each 12 seconds
 	UpdateBlockNumber(context.Context)
 }
 
+// Store is a key/value view over the parser storage, for features that
+// need to persist their own state next to the parser data.
+type Store interface {
+	Get(key string) ([]byte, error)
+	Put(key string, value []byte) error
+	Delete(key string) error
+	// Iterate calls fn for each key with the prefix in key order, until
+	// fn returns false.
+	Iterate(prefix string, fn func(key string, value []byte) bool) error
+}
+
 type Transaction struct {
 	Hash        string `json:"hash,omitempty"`
 	From        string `json:"from,omitempty"`
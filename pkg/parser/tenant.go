@@ -0,0 +1,40 @@
+package parser
+
+import (
+	"context"
+	"regexp"
+)
+
+// DefaultTenant owns the subscriptions of the callers without a tenant,
+// all of them when API keys are disabled.
+const DefaultTenant = "default"
+
+var tenantPattern = regexp.MustCompile(`^[a-zA-Z0-9_.-]{1,64}$`)
+
+// SubscriptionChecker is implemented by the parsers telling whether the
+// tenant of the context subscribed an address.
+type SubscriptionChecker interface {
+	Subscribed(ctx context.Context, address string) bool
+}
+
+type tenantKey struct{}
+
+// NewTenantContext returns a copy of ctx for the tenant, whose
+// subscriptions the parsers then work on.
+func NewTenantContext(ctx context.Context, tenant string) context.Context {
+	return context.WithValue(ctx, tenantKey{}, tenant)
+}
+
+// TenantFromContext returns the tenant of ctx, DefaultTenant when none.
+func TenantFromContext(ctx context.Context) string {
+	if tenant, ok := ctx.Value(tenantKey{}).(string); ok && tenant != "" {
+		return tenant
+	}
+	return DefaultTenant
+}
+
+// ValidTenant tells whether a tenant can be used, store keys being built
+// from it.
+func ValidTenant(tenant string) bool {
+	return tenantPattern.MatchString(tenant)
+}
@@ -0,0 +1,23 @@
+package parser
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTenantContext(t *testing.T) {
+	assert.Equal(t, DefaultTenant, TenantFromContext(context.Background()))
+	assert.Equal(t, DefaultTenant, TenantFromContext(NewTenantContext(context.Background(), "")))
+	assert.Equal(t, "acme", TenantFromContext(NewTenantContext(context.Background(), "acme")))
+}
+
+func TestValidTenant(t *testing.T) {
+	assert.True(t, ValidTenant("acme-1.eu_west"))
+	assert.False(t, ValidTenant(""))
+	assert.False(t, ValidTenant("a/b"))
+	assert.False(t, ValidTenant("a:b"))
+	assert.False(t, ValidTenant(strings.Repeat("a", 65)))
+}
@@ -0,0 +1,40 @@
+package publisher
+
+import "time"
+
+type ForwarderOption func(*Forwarder)
+
+// WithSubject sets the subject prefix, transactions are published to
+// <subject>.transactions and reorg notices to <subject>.reorgs.
+func WithSubject(v string) ForwarderOption {
+	return func(f *Forwarder) {
+		f.subject = v
+	}
+}
+
+func WithInterval(v time.Duration) ForwarderOption {
+	return func(f *Forwarder) {
+		f.interval = v
+	}
+}
+
+// WithBackoff sets the wait before publishing again after a failure.
+func WithBackoff(v time.Duration) ForwarderOption {
+	return func(f *Forwarder) {
+		f.backoff = v
+	}
+}
+
+type NATSOption func(*NATS)
+
+func WithTimeout(v time.Duration) NATSOption {
+	return func(n *NATS) {
+		n.timeout = v
+	}
+}
+
+func WithName(v string) NATSOption {
+	return func(n *NATS) {
+		n.name = v
+	}
+}
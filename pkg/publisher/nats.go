@@ -0,0 +1,203 @@
+package publisher
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Default Values
+var (
+	defaultNATSTimeout = 5 * time.Second
+	defaultNATSName    = "tx-parser"
+)
+
+var ErrInvalidURL = errors.New("publisher url must be nats://[user:password@]host:port")
+
+// NATS publishes to a NATS server with the core text protocol. Every
+// publish is followed by a PING, the matching PONG confirms the server
+// processed the message. The connection is dialed on first use and
+// dialed again after any error.
+type NATS struct {
+	addr     string
+	user     string
+	password string
+	token    string
+	name     string
+	timeout  time.Duration
+
+	mu   sync.Mutex
+	conn net.Conn
+	r    *bufio.Reader
+}
+
+var _ Publisher = &NATS{}
+
+func NewNATS(rawURL string, options ...NATSOption) (*NATS, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil || u.Scheme != "nats" || u.Host == "" {
+		return nil, ErrInvalidURL
+	}
+	n := &NATS{
+		addr:    u.Host,
+		name:    defaultNATSName,
+		timeout: defaultNATSTimeout,
+	}
+	if u.Port() == "" {
+		n.addr = net.JoinHostPort(u.Hostname(), "4222")
+	}
+	if u.User != nil {
+		if password, ok := u.User.Password(); ok {
+			n.user, n.password = u.User.Username(), password
+		} else {
+			n.token = u.User.Username()
+		}
+	}
+	for _, opt := range options {
+		opt(n)
+	}
+	return n, nil
+}
+
+func (n *NATS) Publish(ctx context.Context, subject string, data []byte) error {
+	if subject == "" || strings.ContainsAny(subject, " \t\r\n") {
+		return fmt.Errorf("invalid subject %q", subject)
+	}
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	if n.conn == nil {
+		if err := n.connect(ctx); err != nil {
+			return err
+		}
+	}
+
+	n.setDeadline(ctx)
+	msg := fmt.Sprintf("PUB %s %d\r\n%s\r\nPING\r\n", subject, len(data), data)
+	if _, err := n.conn.Write([]byte(msg)); err != nil {
+		n.reset()
+		return err
+	}
+	if err := n.waitPong(); err != nil {
+		n.reset()
+		return err
+	}
+	return nil
+}
+
+func (n *NATS) Close() error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if n.conn == nil {
+		return nil
+	}
+	err := n.conn.Close()
+	n.conn = nil
+	return err
+}
+
+func (n *NATS) connect(ctx context.Context) error {
+	dialer := net.Dialer{Timeout: n.timeout}
+	conn, err := dialer.DialContext(ctx, "tcp", n.addr)
+	if err != nil {
+		return err
+	}
+	n.conn = conn
+	n.r = bufio.NewReader(conn)
+	n.setDeadline(ctx)
+
+	line, err := n.readLine()
+	if err != nil {
+		n.reset()
+		return err
+	}
+	if !strings.HasPrefix(line, "INFO ") {
+		n.reset()
+		return fmt.Errorf("unexpected nats greeting: %s", line)
+	}
+	var info struct {
+		TLSRequired bool `json:"tls_required"`
+	}
+	if err := json.Unmarshal([]byte(strings.TrimPrefix(line, "INFO ")), &info); err != nil {
+		n.reset()
+		return err
+	}
+	if info.TLSRequired {
+		n.reset()
+		return errors.New("nats server requires tls, which is not supported")
+	}
+
+	options, err := json.Marshal(map[string]interface{}{
+		"verbose":    false,
+		"pedantic":   false,
+		"name":       n.name,
+		"lang":       "go",
+		"user":       n.user,
+		"pass":       n.password,
+		"auth_token": n.token,
+	})
+	if err != nil {
+		n.reset()
+		return err
+	}
+	if _, err := fmt.Fprintf(n.conn, "CONNECT %s\r\nPING\r\n", options); err != nil {
+		n.reset()
+		return err
+	}
+	if err := n.waitPong(); err != nil {
+		n.reset()
+		return err
+	}
+	return nil
+}
+
+// waitPong reads until the PONG answering our PING, replying to the pings
+// of the server on the way.
+func (n *NATS) waitPong() error {
+	for {
+		line, err := n.readLine()
+		if err != nil {
+			return err
+		}
+		switch {
+		case line == "PONG":
+			return nil
+		case line == "PING":
+			if _, err := n.conn.Write([]byte("PONG\r\n")); err != nil {
+				return err
+			}
+		case strings.HasPrefix(line, "-ERR"):
+			return fmt.Errorf("nats: %s", strings.Trim(strings.TrimPrefix(line, "-ERR "), "'"))
+		}
+	}
+}
+
+func (n *NATS) readLine() (string, error) {
+	line, err := n.r.ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
+
+func (n *NATS) setDeadline(ctx context.Context) {
+	deadline := time.Now().Add(n.timeout)
+	if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+		deadline = d
+	}
+	n.conn.SetDeadline(deadline)
+}
+
+func (n *NATS) reset() {
+	n.conn.Close()
+	n.conn = nil
+	n.r = nil
+}
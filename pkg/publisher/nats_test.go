@@ -0,0 +1,76 @@
+package publisher
+
+import (
+	"context"
+	"testing"
+
+	"github.com/jmsilvadev/tx-parser/pkg/publisher/natstest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNATSPublish(t *testing.T) {
+	srv := natstest.NewServer()
+	defer srv.Close()
+
+	n, err := NewNATS(srv.URL)
+	require.NoError(t, err)
+	defer n.Close()
+
+	ctx := context.Background()
+	require.NoError(t, n.Publish(ctx, "a.b", []byte("hello")))
+	require.NoError(t, n.Publish(ctx, "a.c", []byte("")))
+
+	msgs := srv.Messages()
+	require.Len(t, msgs, 2)
+	assert.Equal(t, natstest.Msg{Subject: "a.b", Data: []byte("hello")}, msgs[0])
+	assert.Equal(t, "a.c", msgs[1].Subject)
+
+	assert.Error(t, n.Publish(ctx, "a b", []byte("x")))
+}
+
+func TestNATSReconnect(t *testing.T) {
+	srv := natstest.NewServer()
+	defer srv.Close()
+
+	n, err := NewNATS(srv.URL)
+	require.NoError(t, err)
+	defer n.Close()
+
+	srv.Fail(1)
+	ctx := context.Background()
+	assert.Error(t, n.Publish(ctx, "a", []byte("lost")))
+	assert.NoError(t, n.Publish(ctx, "a", []byte("sent")))
+
+	msgs := srv.Messages()
+	require.Len(t, msgs, 1)
+	assert.Equal(t, []byte("sent"), msgs[0].Data)
+}
+
+func TestNATSAuth(t *testing.T) {
+	srv := natstest.NewServer()
+	defer srv.Close()
+	srv.SetAuth("user", "secret")
+
+	n, err := NewNATS(srv.URL)
+	require.NoError(t, err)
+	assert.ErrorContains(t, n.Publish(context.Background(), "a", nil), "Authorization Violation")
+
+	n, err = NewNATS("nats://user:secret@" + srv.URL[len("nats://"):])
+	require.NoError(t, err)
+	defer n.Close()
+	assert.NoError(t, n.Publish(context.Background(), "a", nil))
+}
+
+func TestNewNATS(t *testing.T) {
+	n, err := NewNATS("nats://localhost")
+	require.NoError(t, err)
+	assert.Equal(t, "localhost:4222", n.addr)
+
+	n, err = NewNATS("nats://token@localhost:4000")
+	require.NoError(t, err)
+	assert.Equal(t, "token", n.token)
+
+	_, err = NewNATS("http://localhost:4222")
+	assert.ErrorIs(t, err, ErrInvalidURL)
+}
@@ -0,0 +1,164 @@
+// Package natstest provides an in-process stand-in for a NATS server,
+// speaking enough of the core protocol to test publishers offline.
+package natstest
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Msg is a message accepted by the server.
+type Msg struct {
+	Subject string
+	Data    []byte
+}
+
+type Server struct {
+	URL string
+
+	listener net.Listener
+	mu       sync.Mutex
+	msgs     []Msg
+	conns    map[net.Conn]struct{}
+	fail     int
+	user     string
+	password string
+	wg       sync.WaitGroup
+}
+
+// NewServer starts a server on a random local port.
+func NewServer() *Server {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		panic(fmt.Sprintf("natstest: failed to listen: %v", err))
+	}
+	s := &Server{
+		URL:      "nats://" + l.Addr().String(),
+		listener: l,
+		conns:    make(map[net.Conn]struct{}),
+	}
+	s.wg.Add(1)
+	go s.accept()
+	return s
+}
+
+// Close stops the server and drops every connection.
+func (s *Server) Close() {
+	s.listener.Close()
+	s.mu.Lock()
+	for c := range s.conns {
+		c.Close()
+	}
+	s.mu.Unlock()
+	s.wg.Wait()
+}
+
+// Messages returns the messages accepted so far.
+func (s *Server) Messages() []Msg {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]Msg{}, s.msgs...)
+}
+
+// Fail drops the connection instead of accepting the next n messages.
+func (s *Server) Fail(n int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.fail = n
+}
+
+// SetAuth makes the server reject clients without these credentials.
+func (s *Server) SetAuth(user, password string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.user, s.password = user, password
+}
+
+func (s *Server) accept() {
+	defer s.wg.Done()
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+		s.mu.Lock()
+		s.conns[conn] = struct{}{}
+		s.mu.Unlock()
+
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			s.serve(conn)
+			s.mu.Lock()
+			delete(s.conns, conn)
+			s.mu.Unlock()
+			conn.Close()
+		}()
+	}
+}
+
+func (s *Server) serve(conn net.Conn) {
+	fmt.Fprintf(conn, "INFO {\"server_id\":\"natstest\",\"version\":\"2.10.0\",\"max_payload\":1048576}\r\n")
+
+	r := bufio.NewReader(conn)
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		line = strings.TrimRight(line, "\r\n")
+		op, args, _ := strings.Cut(line, " ")
+
+		switch strings.ToUpper(op) {
+		case "CONNECT":
+			var opts struct {
+				User string `json:"user"`
+				Pass string `json:"pass"`
+			}
+			json.Unmarshal([]byte(args), &opts)
+			s.mu.Lock()
+			denied := s.user != "" && (opts.User != s.user || opts.Pass != s.password)
+			s.mu.Unlock()
+			if denied {
+				fmt.Fprintf(conn, "-ERR 'Authorization Violation'\r\n")
+				return
+			}
+		case "PING":
+			fmt.Fprintf(conn, "PONG\r\n")
+		case "PONG":
+		case "PUB":
+			fields := strings.Fields(args)
+			if len(fields) < 2 {
+				fmt.Fprintf(conn, "-ERR 'Unknown Protocol Operation'\r\n")
+				return
+			}
+			size, err := strconv.Atoi(fields[len(fields)-1])
+			if err != nil || size < 0 {
+				fmt.Fprintf(conn, "-ERR 'Unknown Protocol Operation'\r\n")
+				return
+			}
+			data := make([]byte, size+2)
+			if _, err := io.ReadFull(r, data); err != nil {
+				return
+			}
+
+			s.mu.Lock()
+			if s.fail > 0 {
+				s.fail--
+				s.mu.Unlock()
+				return
+			}
+			s.msgs = append(s.msgs, Msg{Subject: fields[0], Data: data[:size]})
+			s.mu.Unlock()
+		default:
+			fmt.Fprintf(conn, "-ERR 'Unknown Protocol Operation'\r\n")
+			return
+		}
+	}
+}
@@ -0,0 +1,233 @@
+package publisher
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/jmsilvadev/tx-parser/pkg/events"
+	"github.com/jmsilvadev/tx-parser/pkg/logger"
+	"github.com/jmsilvadev/tx-parser/pkg/parser"
+	"go.uber.org/zap"
+)
+
+// Default Values
+var (
+	defaultSubject  = "txparser"
+	defaultInterval = time.Second
+	defaultBackoff  = time.Second
+)
+
+const (
+	EventTransaction = "transaction"
+	EventReorg       = "reorg"
+
+	logPrefix = "publisher-log:"
+	offsetKey = "publisher-offset"
+)
+
+// Publisher sends a message to a broker subject. It returns once the
+// broker has accepted the message.
+type Publisher interface {
+	Publish(ctx context.Context, subject string, data []byte) error
+	Close() error
+}
+
+// Message is the JSON body published to the broker. ID increases with
+// every message, consumers use it to discard the duplicates an
+// at-least-once delivery may produce.
+type Message struct {
+	ID          uint64              `json:"id"`
+	Event       string              `json:"event"`
+	Transaction *parser.Transaction `json:"transaction,omitempty"`
+	Addresses   []string            `json:"addresses,omitempty"`
+	Reorg       *Reorg              `json:"reorg,omitempty"`
+}
+
+type Reorg struct {
+	BlockNumber int    `json:"block_number"`
+	OldHash     string `json:"old_hash"`
+	NewHash     string `json:"new_hash"`
+}
+
+// Forwarder forwards the matched transactions and reorg notices of the
+// bus to a broker. Events are first appended to a log in the parser store,
+// then published in order; the offset of the last published message is
+// persisted, so messages not yet acknowledged by the broker are sent again
+// after a failure or a restart.
+type Forwarder struct {
+	store     parser.Store
+	log       logger.Logger
+	publisher Publisher
+	subject   string
+	interval  time.Duration
+	backoff   time.Duration
+	wake      chan struct{}
+	mu        sync.Mutex
+	seq       uint64
+}
+
+func New(store parser.Store, l logger.Logger, p Publisher, options ...ForwarderOption) (*Forwarder, error) {
+	f := &Forwarder{
+		store:     store,
+		log:       l,
+		publisher: p,
+		subject:   defaultSubject,
+		interval:  defaultInterval,
+		backoff:   defaultBackoff,
+		wake:      make(chan struct{}, 1),
+	}
+	for _, opt := range options {
+		opt(f)
+	}
+
+	// carry on from the last message written before a restart
+	offset, err := f.Offset()
+	if err != nil {
+		return nil, err
+	}
+	f.seq = offset
+	err = store.Iterate(logPrefix, func(key string, value []byte) bool {
+		if seq, err := strconv.ParseUint(key[len(logPrefix):], 10, 64); err == nil && seq > f.seq {
+			f.seq = seq
+		}
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// Subject returns the subject the messages of an event are published to.
+func (f *Forwarder) Subject(event string) string {
+	switch event {
+	case EventReorg:
+		return f.subject + ".reorgs"
+	default:
+		return f.subject + ".transactions"
+	}
+}
+
+// Handle appends the matched transactions and reorg notices of the bus to
+// the log. It never waits for the broker.
+func (f *Forwarder) Handle(ctx context.Context, e events.Event) {
+	var msg Message
+	switch e := e.(type) {
+	case events.TransactionMatched:
+		tx := e.Transaction
+		msg = Message{Event: EventTransaction, Transaction: &tx, Addresses: e.Addresses}
+	case events.ReorgDetected:
+		msg = Message{Event: EventReorg, Reorg: &Reorg{BlockNumber: e.BlockNumber, OldHash: e.OldHash, NewHash: e.NewHash}}
+	default:
+		return
+	}
+
+	f.mu.Lock()
+	f.seq++
+	msg.ID = f.seq
+	f.mu.Unlock()
+
+	data, err := json.Marshal(msg)
+	if err != nil {
+		f.log.Error("failed to encode the message", zap.Uint64("seq", msg.ID), logger.Err(err))
+		return
+	}
+	if err := f.store.Put(logKey(msg.ID), data); err != nil {
+		f.log.Error("failed to store the message", zap.Uint64("seq", msg.ID), logger.Err(err))
+		return
+	}
+
+	select {
+	case f.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Run publishes the log until the context is done.
+func (f *Forwarder) Run(ctx context.Context) {
+	defer f.publisher.Close()
+	for {
+		wait := f.interval
+		if err := f.flush(ctx); err != nil && ctx.Err() == nil {
+			f.log.Warn("failed to publish", logger.Err(err))
+			wait = f.backoff
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-f.wake:
+		case <-time.After(wait):
+		}
+	}
+}
+
+// Offset returns the id of the last message acknowledged by the broker.
+func (f *Forwarder) Offset() (uint64, error) {
+	value, err := f.store.Get(offsetKey)
+	if err == parser.ErrNotFound {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	return strconv.ParseUint(string(value), 10, 64)
+}
+
+// Pending returns how many messages wait to be published.
+func (f *Forwarder) Pending() (int, error) {
+	pending := 0
+	err := f.store.Iterate(logPrefix, func(key string, value []byte) bool {
+		pending++
+		return true
+	})
+	return pending, err
+}
+
+// flush publishes the log in order and stops at the first failure, so
+// the offset never moves past a message the broker did not accept.
+func (f *Forwarder) flush(ctx context.Context) error {
+	type entry struct {
+		seq  uint64
+		data []byte
+	}
+	var entries []entry
+	err := f.store.Iterate(logPrefix, func(key string, value []byte) bool {
+		seq, err := strconv.ParseUint(key[len(logPrefix):], 10, 64)
+		if err != nil {
+			f.log.Error("invalid publisher log key", zap.String("key", key))
+			return true
+		}
+		entries = append(entries, entry{seq: seq, data: value})
+		return true
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, e := range entries {
+		var msg Message
+		if err := json.Unmarshal(e.data, &msg); err != nil {
+			f.log.Error("invalid publisher message", zap.Uint64("seq", e.seq), logger.Err(err))
+		} else if err := f.publisher.Publish(ctx, f.Subject(msg.Event), e.data); err != nil {
+			return err
+		}
+
+		if err := f.store.Put(offsetKey, []byte(strconv.FormatUint(e.seq, 10))); err != nil {
+			return err
+		}
+		if err := f.store.Delete(logKey(e.seq)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// logKey pads the id so the log iterates in publish order.
+func logKey(seq uint64) string {
+	return fmt.Sprintf("%s%020d", logPrefix, seq)
+}
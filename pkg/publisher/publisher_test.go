@@ -0,0 +1,107 @@
+package publisher
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/jmsilvadev/tx-parser/pkg/events"
+	"github.com/jmsilvadev/tx-parser/pkg/jsonrpc"
+	"github.com/jmsilvadev/tx-parser/pkg/logger"
+	"github.com/jmsilvadev/tx-parser/pkg/parser"
+	"github.com/jmsilvadev/tx-parser/pkg/parser/memorydb"
+	"github.com/jmsilvadev/tx-parser/pkg/publisher/natstest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap/zapcore"
+)
+
+var tx = parser.Transaction{
+	Hash:        "0xabc",
+	From:        "0x123",
+	To:          "0x456",
+	Value:       "0x1",
+	BlockNumber: 1,
+}
+
+func newStore() parser.Store {
+	l := logger.New(zapcore.DebugLevel)
+	return memorydb.New(jsonrpc.NewEthereum(l, "http://localhost:8545"), l)
+}
+
+func newForwarder(t *testing.T, store parser.Store, url string) *Forwarder {
+	l := logger.New(zapcore.DebugLevel)
+	n, err := NewNATS(url)
+	require.NoError(t, err)
+	f, err := New(store, l, n, WithInterval(10*time.Millisecond), WithBackoff(10*time.Millisecond))
+	require.NoError(t, err)
+	return f
+}
+
+func TestForward(t *testing.T) {
+	srv := natstest.NewServer()
+	defer srv.Close()
+
+	store := newStore()
+	f := newForwarder(t, store, srv.URL)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go f.Run(ctx)
+
+	f.Handle(ctx, events.TransactionMatched{Transaction: tx, Addresses: []string{tx.To}})
+	f.Handle(ctx, events.BlockIngested{BlockNumber: 1})
+	f.Handle(ctx, events.ReorgDetected{BlockNumber: 1, OldHash: "0x1", NewHash: "0x2"})
+
+	require.Eventually(t, func() bool { return len(srv.Messages()) == 2 }, time.Second, 10*time.Millisecond)
+	msgs := srv.Messages()
+	assert.Equal(t, "txparser.transactions", msgs[0].Subject)
+	assert.Equal(t, "txparser.reorgs", msgs[1].Subject)
+
+	var msg Message
+	require.NoError(t, json.Unmarshal(msgs[0].Data, &msg))
+	assert.Equal(t, uint64(1), msg.ID)
+	assert.Equal(t, tx, *msg.Transaction)
+	require.NoError(t, json.Unmarshal(msgs[1].Data, &msg))
+	assert.Equal(t, uint64(2), msg.ID)
+	assert.Equal(t, "0x2", msg.Reorg.NewHash)
+
+	offset, err := f.Offset()
+	require.NoError(t, err)
+	assert.Equal(t, uint64(2), offset)
+	pending, err := f.Pending()
+	require.NoError(t, err)
+	assert.Equal(t, 0, pending)
+}
+
+func TestForwardAtLeastOnce(t *testing.T) {
+	srv := natstest.NewServer()
+	defer srv.Close()
+	srv.Fail(1)
+
+	store := newStore()
+	f := newForwarder(t, store, srv.URL)
+	ctx := context.Background()
+	f.Handle(ctx, events.TransactionMatched{Transaction: tx})
+	f.Handle(ctx, events.TransactionMatched{Transaction: tx})
+
+	// the broker dropped the first message, nothing is acknowledged
+	assert.Error(t, f.flush(ctx))
+	offset, _ := f.Offset()
+	assert.Equal(t, uint64(0), offset)
+
+	// a restarted forwarder sends the log again and keeps numbering
+	f = newForwarder(t, store, srv.URL)
+	f.Handle(ctx, events.TransactionMatched{Transaction: tx})
+	require.NoError(t, f.flush(ctx))
+
+	var ids []uint64
+	for _, m := range srv.Messages() {
+		var msg Message
+		require.NoError(t, json.Unmarshal(m.Data, &msg))
+		ids = append(ids, msg.ID)
+	}
+	assert.Equal(t, []uint64{1, 2, 3}, ids)
+	offset, _ = f.Offset()
+	assert.Equal(t, uint64(3), offset)
+}
@@ -0,0 +1,97 @@
+package ratelimit
+
+import (
+	"sync"
+	"time"
+)
+
+// Default Values
+var (
+	sweepInterval = time.Minute
+)
+
+// Limit allows Rate requests per second with bursts of Burst. A zero Rate
+// does not limit.
+type Limit struct {
+	Rate  float64
+	Burst int
+}
+
+// Limiter gives every key, e.g. every client, a Bucket of its own. The
+// buckets idle long enough to be full again are dropped. A nil Limiter
+// never limits.
+type Limiter struct {
+	mu      sync.Mutex
+	limit   Limit
+	buckets map[string]*Bucket
+	swept   time.Time
+	now     func() time.Time
+}
+
+// NewLimiter returns nil when the limit has no rate.
+func NewLimiter(limit Limit) *Limiter {
+	if limit.Rate <= 0 {
+		return nil
+	}
+	return &Limiter{
+		limit:   limit,
+		buckets: make(map[string]*Bucket),
+		swept:   time.Now(),
+		now:     time.Now,
+	}
+}
+
+// Allow takes a token from the bucket of key, or returns how long until
+// one is available.
+func (l *Limiter) Allow(key string) (bool, time.Duration) {
+	if l == nil {
+		return true, 0
+	}
+	l.mu.Lock()
+	now := l.now()
+	if now.Sub(l.swept) >= sweepInterval {
+		l.sweep()
+		l.swept = now
+	}
+	b, ok := l.buckets[key]
+	if !ok {
+		b = New(l.limit.Rate, l.limit.Burst)
+		b.now = l.now
+		b.last = now
+		l.buckets[key] = b
+	}
+	l.mu.Unlock()
+
+	if b.Allow() {
+		return true, 0
+	}
+	return false, b.Delay()
+}
+
+// Delay returns how long until the bucket of key has a token, without
+// taking it.
+func (l *Limiter) Delay(key string) time.Duration {
+	if l == nil {
+		return 0
+	}
+	l.mu.Lock()
+	b, ok := l.buckets[key]
+	l.mu.Unlock()
+	if !ok {
+		return 0
+	}
+	return b.Delay()
+}
+
+// sweep drops the full buckets, a new one being the same.
+func (l *Limiter) sweep() {
+	for key, b := range l.buckets {
+		b.mu.Lock()
+		b.refill()
+		full := b.tokens >= b.burst
+		b.mu.Unlock()
+		if full {
+			delete(l.buckets, key)
+		}
+	}
+}
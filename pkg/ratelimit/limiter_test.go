@@ -0,0 +1,43 @@
+package ratelimit
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLimiter(t *testing.T) {
+	now := time.Now()
+	l := NewLimiter(Limit{Rate: 1, Burst: 2})
+	l.now = func() time.Time { return now }
+	l.swept = now
+
+	for i := 0; i < 2; i++ {
+		ok, _ := l.Allow("a")
+		assert.True(t, ok)
+	}
+	ok, wait := l.Allow("a")
+	assert.False(t, ok)
+	assert.Equal(t, time.Second, wait)
+	assert.Equal(t, time.Second, l.Delay("a"))
+	assert.Zero(t, l.Delay("unknown"))
+
+	// every key has its own bucket
+	ok, _ = l.Allow("b")
+	assert.True(t, ok)
+
+	// the full buckets are dropped
+	now = now.Add(sweepInterval)
+	ok, _ = l.Allow("a")
+	assert.True(t, ok)
+	assert.Len(t, l.buckets, 1)
+}
+
+func TestNilLimiter(t *testing.T) {
+	l := NewLimiter(Limit{})
+	assert.Nil(t, l)
+	ok, _ := l.Allow("a")
+	assert.True(t, ok)
+	assert.Zero(t, l.Delay("a"))
+}
@@ -0,0 +1,156 @@
+package ratelimit
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jmsilvadev/tx-parser/pkg/parser"
+)
+
+// Default Values
+var (
+	quotaFlushInterval = 10 * time.Second
+)
+
+const (
+	quotaPrefix = "quota:"
+	dayLayout   = "2006-01-02"
+)
+
+// Quota counts the requests of every key per UTC day, up to a limit. The
+// counts are kept in memory, so the requests do not wait on the store, and
+// persisted by Run under quota:<day>:<key> so they survive restarts. A nil
+// Quota never limits.
+type Quota struct {
+	mu     sync.Mutex
+	store  parser.Store
+	limit  int
+	day    string
+	counts map[string]int
+	// dirty are the counts not persisted yet, by store key
+	dirty map[string]int
+	now   func() time.Time
+}
+
+// NewQuota returns nil when the limit is not positive. Without a store the
+// counts are lost on restart.
+func NewQuota(store parser.Store, limit int) *Quota {
+	if limit <= 0 {
+		return nil
+	}
+	return &Quota{
+		store:  store,
+		limit:  limit,
+		counts: make(map[string]int),
+		dirty:  make(map[string]int),
+		now:    time.Now,
+	}
+}
+
+// Allow counts a request of key, unless the key used up its quota for the
+// day, in which case it returns how long until the quota resets.
+func (q *Quota) Allow(key string) (bool, time.Duration) {
+	if q == nil {
+		return true, 0
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	now := q.now().UTC()
+	if day := now.Format(dayLayout); day != q.day {
+		q.day = day
+		q.counts = make(map[string]int)
+	}
+
+	count, ok := q.counts[key]
+	if !ok {
+		count = q.load(key)
+	}
+	if count >= q.limit {
+		q.counts[key] = count
+		midnight := time.Date(now.Year(), now.Month(), now.Day()+1, 0, 0, 0, 0, time.UTC)
+		return false, midnight.Sub(now)
+	}
+	q.counts[key] = count + 1
+	q.dirty[q.storeKey(key)] = count + 1
+	return true, 0
+}
+
+// Run persists the counts until ctx is done, and a last time then.
+func (q *Quota) Run(ctx context.Context) {
+	if q == nil {
+		return
+	}
+	ticker := time.NewTicker(quotaFlushInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			q.Flush()
+			return
+		case <-ticker.C:
+			q.Flush()
+		}
+	}
+}
+
+// Flush persists the counts changed since the last flush and drops the
+// ones of the previous days. The counts failing to be written are written
+// again by the next flush.
+func (q *Quota) Flush() error {
+	if q == nil || q.store == nil {
+		return nil
+	}
+	q.mu.Lock()
+	dirty := q.dirty
+	q.dirty = make(map[string]int)
+	today := quotaPrefix + q.now().UTC().Format(dayLayout) + ":"
+	q.mu.Unlock()
+
+	var errs []error
+	failed := make(map[string]int)
+	for key, count := range dirty {
+		if !strings.HasPrefix(key, today) {
+			continue
+		}
+		if err := q.store.Put(key, []byte(strconv.Itoa(count))); err != nil {
+			errs = append(errs, err)
+			failed[key] = count
+		}
+	}
+	if len(failed) > 0 {
+		// kept for the next flush, unless counted further since
+		q.mu.Lock()
+		for key, count := range failed {
+			q.dirty[key] = max(q.dirty[key], count)
+		}
+		q.mu.Unlock()
+	}
+	err := q.store.Iterate(quotaPrefix, func(key string, _ []byte) bool {
+		if !strings.HasPrefix(key, today) {
+			errs = append(errs, q.store.Delete(key))
+		}
+		return true
+	})
+	return errors.Join(append(errs, err)...)
+}
+
+func (q *Quota) load(key string) int {
+	if q.store == nil {
+		return 0
+	}
+	value, err := q.store.Get(q.storeKey(key))
+	if err != nil {
+		return 0
+	}
+	count, _ := strconv.Atoi(string(value))
+	return count
+}
+
+func (q *Quota) storeKey(key string) string {
+	return quotaPrefix + q.day + ":" + key
+}
@@ -0,0 +1,144 @@
+package ratelimit
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jmsilvadev/tx-parser/pkg/parser"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// store is a parser.Store, the parsers depending on this package.
+type store map[string][]byte
+
+func (s store) Get(key string) ([]byte, error) {
+	value, ok := s[key]
+	if !ok {
+		return nil, parser.ErrNotFound
+	}
+	return value, nil
+}
+
+func (s store) Put(key string, value []byte) error {
+	s[key] = value
+	return nil
+}
+
+func (s store) Delete(key string) error {
+	delete(s, key)
+	return nil
+}
+
+func (s store) Iterate(prefix string, fn func(key string, value []byte) bool) error {
+	keys := make([]string, 0, len(s))
+	for key := range s {
+		if strings.HasPrefix(key, prefix) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		if !fn(key, s[key]) {
+			break
+		}
+	}
+	return nil
+}
+
+// failingStore fails the writes while failing is set.
+type failingStore struct {
+	store
+	failing bool
+}
+
+func (s *failingStore) Put(key string, value []byte) error {
+	if s.failing {
+		return errors.New("disk full")
+	}
+	return s.store.Put(key, value)
+}
+
+func TestQuotaFlushFailure(t *testing.T) {
+	s := &failingStore{store: store{}, failing: true}
+	q := NewQuota(s, 5)
+	q.Allow("a")
+	q.Allow("a")
+	require.Error(t, q.Flush())
+
+	q.Allow("b")
+	s.failing = false
+	require.NoError(t, q.Flush())
+	day := q.now().UTC().Format(dayLayout)
+	assert.Equal(t, "2", string(s.store["quota:"+day+":a"]))
+	assert.Equal(t, "1", string(s.store["quota:"+day+":b"]))
+}
+
+func TestQuota(t *testing.T) {
+	store := store{}
+	now := time.Date(2026, 1, 2, 23, 0, 0, 0, time.UTC)
+
+	q := NewQuota(store, 2)
+	q.now = func() time.Time { return now }
+	for i := 0; i < 2; i++ {
+		ok, _ := q.Allow("a")
+		assert.True(t, ok)
+	}
+	ok, wait := q.Allow("a")
+	assert.False(t, ok)
+	assert.Equal(t, time.Hour, wait)
+	ok, _ = q.Allow("b")
+	assert.True(t, ok)
+	require.NoError(t, q.Flush())
+
+	// the counts survive a restart
+	restarted := NewQuota(store, 2)
+	restarted.now = q.now
+	ok, _ = restarted.Allow("a")
+	assert.False(t, ok)
+	ok, _ = restarted.Allow("b")
+	assert.True(t, ok)
+
+	// and reset the next day, the previous ones being dropped
+	now = now.Add(time.Hour)
+	ok, _ = restarted.Allow("a")
+	assert.True(t, ok)
+	require.NoError(t, restarted.Flush())
+	var keys []string
+	store.Iterate(quotaPrefix, func(key string, _ []byte) bool {
+		keys = append(keys, key)
+		return true
+	})
+	assert.Equal(t, []string{"quota:2026-01-03:a"}, keys)
+}
+
+func TestQuotaRun(t *testing.T) {
+	store := store{}
+	q := NewQuota(store, 10)
+	q.Allow("a")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		q.Run(ctx)
+		close(done)
+	}()
+	cancel()
+	<-done
+
+	_, err := store.Get(q.storeKey("a"))
+	assert.NoError(t, err)
+}
+
+func TestNilQuota(t *testing.T) {
+	q := NewQuota(nil, 0)
+	assert.Nil(t, q)
+	ok, _ := q.Allow("a")
+	assert.True(t, ok)
+	assert.NoError(t, q.Flush())
+	q.Run(context.Background())
+}
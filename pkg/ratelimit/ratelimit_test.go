@@ -0,0 +1,54 @@
+package ratelimit
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAllow(t *testing.T) {
+	now := time.Now()
+	b := New(1, 2)
+	b.now = func() time.Time { return now }
+	b.last = now
+
+	assert.True(t, b.Allow())
+	assert.True(t, b.Allow())
+	assert.False(t, b.Allow())
+	assert.Equal(t, time.Second, b.Delay())
+
+	now = now.Add(time.Second)
+	assert.True(t, b.Allow())
+	assert.False(t, b.Allow())
+}
+
+func TestReserve(t *testing.T) {
+	now := time.Now()
+	b := New(2, 1)
+	b.now = func() time.Time { return now }
+	b.last = now
+
+	assert.Equal(t, time.Duration(0), b.Reserve())
+	assert.Equal(t, 500*time.Millisecond, b.Reserve())
+	assert.Equal(t, time.Second, b.Reserve())
+}
+
+func TestWait(t *testing.T) {
+	b := New(100, 1)
+	assert.NoError(t, b.Wait(context.Background()))
+	assert.NoError(t, b.Wait(context.Background()))
+
+	b = New(0.001, 1)
+	b.Allow()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	assert.ErrorIs(t, b.Wait(ctx), context.DeadlineExceeded)
+}
+
+func TestNilBucket(t *testing.T) {
+	var b *Bucket
+	assert.True(t, b.Allow())
+	assert.NoError(t, b.Wait(context.Background()))
+}
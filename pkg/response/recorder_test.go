@@ -0,0 +1,32 @@
+package response
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRecorder(t *testing.T) {
+	rr := httptest.NewRecorder()
+	rec := NewRecorder(rr)
+	assert.Equal(t, http.StatusOK, rec.Status())
+	assert.False(t, rec.Written())
+
+	rec.WriteHeader(http.StatusNotFound)
+	rec.WriteHeader(http.StatusOK)
+	assert.Equal(t, http.StatusNotFound, rec.Status())
+	assert.True(t, rec.Written())
+
+	rec.Write([]byte("not found"))
+	rec.Write([]byte("!"))
+	assert.Equal(t, 10, rec.Bytes())
+
+	rec.Flush()
+	assert.True(t, rr.Flushed)
+
+	_, _, err := rec.Hijack()
+	assert.Error(t, err, "httptest.ResponseRecorder cannot be hijacked")
+	assert.Equal(t, rr, rec.Unwrap())
+}
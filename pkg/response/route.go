@@ -0,0 +1,20 @@
+package response
+
+import (
+	"net/http"
+	"strings"
+)
+
+// Route is the path of the mux pattern r matches, without its method, or
+// "unmatched". Unlike the path of r, it cannot take values at will, so it
+// can label metrics and spans.
+func Route(mux *http.ServeMux, r *http.Request) string {
+	_, pattern := mux.Handler(r)
+	if pattern == "" {
+		return "unmatched"
+	}
+	if _, path, ok := strings.Cut(pattern, " "); ok {
+		return path
+	}
+	return pattern
+}
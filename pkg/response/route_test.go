@@ -0,0 +1,19 @@
+package response
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRoute(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /v1/get-transactions", func(w http.ResponseWriter, r *http.Request) {})
+	mux.HandleFunc("/metrics", func(w http.ResponseWriter, r *http.Request) {})
+
+	assert.Equal(t, "/v1/get-transactions", Route(mux, httptest.NewRequest("GET", "/v1/get-transactions?address=0x1", nil)))
+	assert.Equal(t, "/metrics", Route(mux, httptest.NewRequest("GET", "/metrics", nil)))
+	assert.Equal(t, "unmatched", Route(mux, httptest.NewRequest("GET", "/0xabc", nil)))
+}
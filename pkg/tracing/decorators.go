@@ -0,0 +1,15 @@
+package tracing
+
+func WithEnvironment(v string) Option {
+	return func(p *Provider) {
+		p.environment = v
+	}
+}
+
+// WithSampleRatio is the share of the traces started here that are
+// recorded. Traces started by a client follow its decision.
+func WithSampleRatio(v float64) Option {
+	return func(p *Provider) {
+		p.sampleRatio = v
+	}
+}
@@ -0,0 +1,115 @@
+package tracing
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/jmsilvadev/tx-parser/pkg/response"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Default Values
+var (
+	serviceName = "txparser"
+	sampleRatio = 1.0
+	tracerName  = "github.com/jmsilvadev/tx-parser/pkg/tracing"
+)
+
+// Provider exports the spans of the process. A nil Provider exports
+// nothing.
+type Provider struct {
+	tp          *sdktrace.TracerProvider
+	environment string
+	sampleRatio float64
+}
+
+type Option func(*Provider)
+
+// New installs the global tracer provider and the W3C trace context
+// propagator. Spans are exported to the OTLP/HTTP endpoint, e.g.
+// http://collector:4318, and dropped when the endpoint is empty, in which
+// case the returned Provider is nil.
+func New(ctx context.Context, endpoint string, options ...Option) (*Provider, error) {
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(
+		propagation.TraceContext{},
+		propagation.Baggage{},
+	))
+	if endpoint == "" {
+		return nil, nil
+	}
+
+	p := &Provider{sampleRatio: sampleRatio}
+	for _, opt := range options {
+		opt(p)
+	}
+
+	exporter, err := otlptracehttp.New(ctx, otlptracehttp.WithEndpointURL(endpoint))
+	if err != nil {
+		return nil, err
+	}
+	res, err := resource.Merge(resource.Default(), resource.NewSchemaless(
+		semconv.ServiceName(serviceName),
+		semconv.DeploymentEnvironment(p.environment),
+	))
+	if err != nil {
+		return nil, err
+	}
+
+	p.tp = sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(p.sampleRatio))),
+	)
+	otel.SetTracerProvider(p.tp)
+	return p, nil
+}
+
+// Shutdown flushes the spans not exported yet.
+func (p *Provider) Shutdown(ctx context.Context) error {
+	if p == nil {
+		return nil
+	}
+	return p.tp.Shutdown(ctx)
+}
+
+// Handler starts a server span for every request served by next, as a
+// child of the trace context sent by the client if any. Spans are named
+// after the mux pattern the request matches, like the metrics.
+func Handler(mux *http.ServeMux, next http.Handler) http.Handler {
+	tracer := otel.Tracer(tracerName)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := otel.GetTextMapPropagator().Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+
+		route := response.Route(mux, r)
+		ctx, span := tracer.Start(ctx, r.Method+" "+route,
+			trace.WithSpanKind(trace.SpanKindServer),
+			trace.WithAttributes(
+				semconv.HTTPRequestMethodKey.String(r.Method),
+				semconv.HTTPRoute(route),
+				semconv.URLPath(r.URL.Path),
+			),
+		)
+		defer span.End()
+
+		rec := response.NewRecorder(w)
+		next.ServeHTTP(rec, r.WithContext(ctx))
+
+		span.SetAttributes(semconv.HTTPResponseStatusCode(rec.Status()))
+		if rec.Status() >= http.StatusInternalServerError {
+			span.SetStatus(codes.Error, http.StatusText(rec.Status()))
+		}
+	})
+}
+
+// Fail marks the span as failed with err.
+func Fail(span trace.Span, err error) {
+	span.RecordError(err)
+	span.SetStatus(codes.Error, err.Error())
+}
@@ -0,0 +1,520 @@
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/jmsilvadev/tx-parser/pkg/events"
+	"github.com/jmsilvadev/tx-parser/pkg/logger"
+	"github.com/jmsilvadev/tx-parser/pkg/parser"
+	"go.uber.org/zap"
+)
+
+// Default Values
+var (
+	defaultMaxAttempts = 8
+	defaultBackoff     = time.Second
+	defaultMaxBackoff  = 10 * time.Minute
+	defaultInterval    = time.Second
+	defaultTimeout     = 10 * time.Second
+	defaultWorkers     = 8
+	lookupIPAddr       = net.DefaultResolver.LookupIPAddr
+)
+
+const (
+	SignatureHeader = "X-Signature-256"
+	DeliveryHeader  = "X-Webhook-Delivery"
+
+	webhookPrefix = "webhook:"
+	outboxPrefix  = "webhook-outbox:"
+	deadPrefix    = "webhook-dead:"
+)
+
+var (
+	ErrInvalidURL       = errors.New("webhook url must be an absolute http or https url")
+	ErrForbiddenAddress = errors.New("webhook url must not resolve to a loopback, private, link-local or unspecified address")
+)
+
+// Payload is the JSON body posted to webhooks.
+type Payload struct {
+	Event       string             `json:"event"`
+	Address     string             `json:"address"`
+	Transaction parser.Transaction `json:"transaction"`
+}
+
+// Delivery is a payload waiting in the outbox, or given up on and moved
+// to the dead-letter list.
+type Delivery struct {
+	ID          string          `json:"id"`
+	Tenant      string          `json:"tenant,omitempty"`
+	URL         string          `json:"url"`
+	Payload     json.RawMessage `json:"payload"`
+	Attempts    int             `json:"attempts"`
+	NextAttempt time.Time       `json:"next_attempt"`
+	LastError   string          `json:"last_error,omitempty"`
+}
+
+// Dispatcher delivers transactions to the webhooks registered for their
+// addresses. Deliveries go through an outbox persisted in the parser
+// store, so pending ones survive restarts, and are retried with an
+// exponential backoff until they succeed or run out of attempts. Webhooks
+// resolving to internal addresses are refused, when registered and again
+// when connecting, unless their network is allowed.
+type Dispatcher struct {
+	store       parser.Store
+	log         logger.Logger
+	secret      []byte
+	client      *http.Client
+	maxAttempts int
+	backoff     time.Duration
+	maxBackoff  time.Duration
+	interval    time.Duration
+	workers     int
+	allowed     []*net.IPNet
+	wake        chan struct{}
+	mu          sync.Mutex
+	seq         uint64
+}
+
+type DispatcherOption func(*Dispatcher)
+
+// WithHTTPClient replaces the client, and with it the check of the
+// addresses it connects to.
+func WithHTTPClient(v *http.Client) DispatcherOption {
+	return func(d *Dispatcher) {
+		d.client = v
+	}
+}
+
+func WithMaxAttempts(v int) DispatcherOption {
+	return func(d *Dispatcher) {
+		d.maxAttempts = v
+	}
+}
+
+func WithBackoff(base, max time.Duration) DispatcherOption {
+	return func(d *Dispatcher) {
+		d.backoff = base
+		d.maxBackoff = max
+	}
+}
+
+func WithInterval(v time.Duration) DispatcherOption {
+	return func(d *Dispatcher) {
+		d.interval = v
+	}
+}
+
+// WithWorkers sets how many endpoints are delivered to at once.
+func WithWorkers(v int) DispatcherOption {
+	return func(d *Dispatcher) {
+		d.workers = v
+	}
+}
+
+// WithAllowedNetworks lets webhooks reach these networks even when they
+// are loopback, private or link-local ones.
+func WithAllowedNetworks(v []*net.IPNet) DispatcherOption {
+	return func(d *Dispatcher) {
+		d.allowed = v
+	}
+}
+
+func New(store parser.Store, l logger.Logger, secret []byte, options ...DispatcherOption) *Dispatcher {
+	d := &Dispatcher{
+		store:       store,
+		log:         l,
+		secret:      secret,
+		maxAttempts: defaultMaxAttempts,
+		backoff:     defaultBackoff,
+		maxBackoff:  defaultMaxBackoff,
+		interval:    defaultInterval,
+		workers:     defaultWorkers,
+		wake:        make(chan struct{}, 1),
+	}
+	for _, opt := range options {
+		opt(d)
+	}
+	if d.client == nil {
+		d.client = d.newClient()
+	}
+	if d.workers < 1 {
+		d.workers = 1
+	}
+	return d
+}
+
+// newClient checks every address it connects to, so a host resolving to
+// a public address when registered cannot be rebound to an internal one.
+// It connects directly, a proxy address being the only one checked.
+func (d *Dispatcher) newClient() *http.Client {
+	dialer := &net.Dialer{
+		Timeout: defaultTimeout,
+		Control: func(_, address string, _ syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			if ip := net.ParseIP(host); ip == nil || !d.permitted(ip) {
+				return ErrForbiddenAddress
+			}
+			return nil
+		},
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = nil
+	transport.DialContext = dialer.DialContext
+	return &http.Client{Timeout: defaultTimeout, Transport: transport}
+}
+
+// permitted tells whether webhooks may be delivered to ip.
+func (d *Dispatcher) permitted(ip net.IP) bool {
+	for _, network := range d.allowed {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return !ip.IsLoopback() && !ip.IsPrivate() && !ip.IsUnspecified() &&
+		!ip.IsLinkLocalUnicast() && !ip.IsLinkLocalMulticast() && !ip.IsInterfaceLocalMulticast()
+}
+
+// checkHost resolves the host of a webhook and refuses it if any of its
+// addresses is not permitted.
+func (d *Dispatcher) checkHost(ctx context.Context, host string) error {
+	if ip := net.ParseIP(host); ip != nil {
+		if !d.permitted(ip) {
+			return ErrForbiddenAddress
+		}
+		return nil
+	}
+	addrs, err := lookupIPAddr(ctx, host)
+	if err != nil {
+		return fmt.Errorf("cannot resolve the webhook host: %w", err)
+	}
+	for _, addr := range addrs {
+		if !d.permitted(addr.IP) {
+			return ErrForbiddenAddress
+		}
+	}
+	return nil
+}
+
+// Register sets the webhook of an address for the tenant of ctx,
+// replacing any previous one.
+func (d *Dispatcher) Register(ctx context.Context, address, rawURL string) error {
+	u, err := url.Parse(rawURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return ErrInvalidURL
+	}
+	if err := d.checkHost(ctx, u.Hostname()); err != nil {
+		return err
+	}
+	return d.store.Put(webhookKey(parser.TenantFromContext(ctx), address), []byte(rawURL))
+}
+
+// Webhook returns the webhook the tenant of ctx registered for an address,
+// if any.
+func (d *Dispatcher) Webhook(ctx context.Context, address string) (string, bool) {
+	value, err := d.store.Get(webhookKey(parser.TenantFromContext(ctx), address))
+	if err != nil {
+		return "", false
+	}
+	return string(value), true
+}
+
+// Unregister removes the webhook of an address for the tenant of ctx.
+func (d *Dispatcher) Unregister(ctx context.Context, address string) error {
+	return d.store.Delete(webhookKey(parser.TenantFromContext(ctx), address))
+}
+
+// webhookKey keeps the key of the default tenant as it was before the
+// tenants, the others being suffixed with their name.
+func webhookKey(tenant, address string) string {
+	key := webhookPrefix + strings.ToLower(address)
+	if tenant != parser.DefaultTenant {
+		key += "/" + tenant
+	}
+	return key
+}
+
+// registration is a webhook registered by a tenant.
+type registration struct {
+	tenant string
+	url    string
+}
+
+// webhooks returns the webhooks registered for an address by the tenants
+// still subscribed to it.
+func (d *Dispatcher) webhooks(ctx context.Context, address string) []registration {
+	checker, _ := d.store.(parser.SubscriptionChecker)
+	subscribed := func(tenant string) bool {
+		return checker == nil || checker.Subscribed(parser.NewTenantContext(ctx, tenant), address)
+	}
+
+	var hooks []registration
+	if value, err := d.store.Get(webhookPrefix + address); err == nil && subscribed(parser.DefaultTenant) {
+		hooks = append(hooks, registration{tenant: parser.DefaultTenant, url: string(value)})
+	}
+	prefix := webhookPrefix + address + "/"
+	err := d.store.Iterate(prefix, func(key string, value []byte) bool {
+		if tenant := strings.TrimPrefix(key, prefix); subscribed(tenant) {
+			hooks = append(hooks, registration{tenant: tenant, url: string(value)})
+		}
+		return true
+	})
+	if err != nil {
+		d.log.Error("failed to read the webhooks", logger.Address(address), logger.Err(err))
+	}
+	return hooks
+}
+
+// Notify queues the transaction for the webhooks of its sender and
+// receiver, whichever subscribed tenant registered them. It only writes to the
+// outbox and never blocks on delivery.
+func (d *Dispatcher) Notify(ctx context.Context, tx parser.Transaction) {
+	queued := false
+	seen := map[string]bool{}
+	for _, address := range []string{strings.ToLower(tx.From), strings.ToLower(tx.To)} {
+		if address == "" || seen[address] {
+			continue
+		}
+		seen[address] = true
+
+		hooks := d.webhooks(ctx, address)
+		if len(hooks) == 0 {
+			continue
+		}
+
+		payload, err := json.Marshal(Payload{Event: "transaction", Address: address, Transaction: tx})
+		if err != nil {
+			d.log.Error("failed to encode the payload", logger.Address(address), logger.Err(err))
+			continue
+		}
+		for _, hook := range hooks {
+			delivery := Delivery{
+				ID:          d.nextID(),
+				Tenant:      hook.tenant,
+				URL:         hook.url,
+				Payload:     payload,
+				NextAttempt: time.Now(),
+			}
+			if err := d.save(outboxPrefix, delivery); err != nil {
+				d.log.Error("failed to store the delivery", zap.String("delivery", delivery.ID), logger.Err(err))
+				continue
+			}
+			queued = true
+		}
+	}
+
+	if queued {
+		select {
+		case d.wake <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// Handle queues the matched transactions of the bus.
+func (d *Dispatcher) Handle(ctx context.Context, e events.Event) {
+	if e, ok := e.(events.TransactionMatched); ok {
+		d.Notify(ctx, e.Transaction)
+	}
+}
+
+// Run delivers the outbox until the context is done.
+func (d *Dispatcher) Run(ctx context.Context) {
+	for {
+		d.flush(ctx)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-d.wake:
+		case <-time.After(d.interval):
+		}
+	}
+}
+
+// DeadLetters lists the deliveries that ran out of attempts, whatever
+// their tenant, those queued before the tenants having none.
+func (d *Dispatcher) DeadLetters() ([]Delivery, error) {
+	return d.list(deadPrefix)
+}
+
+// Pending lists the deliveries still waiting in the outbox.
+func (d *Dispatcher) Pending() ([]Delivery, error) {
+	return d.list(outboxPrefix)
+}
+
+// flush delivers the due deliveries, those of an endpoint in order and
+// up to workers endpoints at once, so a slow endpoint only delays its own.
+// An endpoint's deliveries wait behind the first one that is backing off
+// or that fails.
+func (d *Dispatcher) flush(ctx context.Context) {
+	pending, err := d.Pending()
+	if err != nil {
+		d.log.Error("failed to read the outbox", logger.Err(err))
+		return
+	}
+
+	now := time.Now()
+	var endpoints []string
+	due := map[string][]Delivery{}
+	waiting := map[string]bool{}
+	for _, delivery := range pending {
+		if waiting[delivery.URL] {
+			continue
+		}
+		if delivery.NextAttempt.After(now) {
+			waiting[delivery.URL] = true
+			continue
+		}
+		if _, ok := due[delivery.URL]; !ok {
+			endpoints = append(endpoints, delivery.URL)
+		}
+		due[delivery.URL] = append(due[delivery.URL], delivery)
+	}
+
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, d.workers)
+	for _, endpoint := range endpoints {
+		select {
+		case <-ctx.Done():
+		case sem <- struct{}{}:
+		}
+		if ctx.Err() != nil {
+			break
+		}
+		wg.Add(1)
+		go func(deliveries []Delivery) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			for _, delivery := range deliveries {
+				if ctx.Err() != nil || !d.attempt(ctx, delivery) {
+					return
+				}
+			}
+		}(due[endpoint])
+	}
+	wg.Wait()
+}
+
+// attempt posts a delivery and tells whether it succeeded, scheduling
+// its retry or moving it to the dead letters otherwise.
+func (d *Dispatcher) attempt(ctx context.Context, delivery Delivery) bool {
+	err := d.post(ctx, delivery)
+	if err == nil {
+		d.log.Debug("webhook delivered", zap.String("delivery", delivery.ID))
+		if err := d.store.Delete(outboxPrefix + delivery.ID); err != nil {
+			d.log.Error("failed to remove the delivery from the outbox", zap.String("delivery", delivery.ID), logger.Err(err))
+		}
+		return true
+	}
+	if ctx.Err() != nil {
+		return false
+	}
+
+	delivery.Attempts++
+	delivery.LastError = err.Error()
+	if delivery.Attempts >= d.maxAttempts {
+		d.log.Warn("webhook delivery failed too many times, moving it to the dead letters", zap.String("delivery", delivery.ID), zap.Int("attempts", delivery.Attempts), logger.Err(err))
+		if err := d.save(deadPrefix, delivery); err != nil {
+			d.log.Error("failed to store the dead letter", zap.String("delivery", delivery.ID), logger.Err(err))
+			return false
+		}
+		if err := d.store.Delete(outboxPrefix + delivery.ID); err != nil {
+			d.log.Error("failed to remove the delivery from the outbox", zap.String("delivery", delivery.ID), logger.Err(err))
+		}
+		return false
+	}
+
+	delivery.NextAttempt = time.Now().Add(d.backoffFor(delivery.Attempts))
+	d.log.Debug("webhook delivery failed", zap.String("delivery", delivery.ID), zap.Time("next_attempt", delivery.NextAttempt), logger.Err(err))
+	if err := d.save(outboxPrefix, delivery); err != nil {
+		d.log.Error("failed to store the delivery", zap.String("delivery", delivery.ID), logger.Err(err))
+	}
+	return false
+}
+
+func (d *Dispatcher) post(ctx context.Context, delivery Delivery) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, delivery.URL, bytes.NewReader(delivery.Payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(DeliveryHeader, delivery.ID)
+	req.Header.Set(SignatureHeader, "sha256="+Sign(d.secret, delivery.Payload))
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("unexpected status code: %d", resp.StatusCode)
+	}
+	return nil
+}
+
+func (d *Dispatcher) backoffFor(attempts int) time.Duration {
+	wait := d.backoff << (attempts - 1)
+	if wait <= 0 || wait > d.maxBackoff {
+		wait = d.maxBackoff
+	}
+	return wait
+}
+
+func (d *Dispatcher) save(prefix string, delivery Delivery) error {
+	data, err := json.Marshal(delivery)
+	if err != nil {
+		return err
+	}
+	return d.store.Put(prefix+delivery.ID, data)
+}
+
+func (d *Dispatcher) list(prefix string) ([]Delivery, error) {
+	deliveries := []Delivery{}
+	err := d.store.Iterate(prefix, func(key string, value []byte) bool {
+		var delivery Delivery
+		if err := json.Unmarshal(value, &delivery); err != nil {
+			d.log.Error("invalid delivery", zap.String("key", key), logger.Err(err))
+			return true
+		}
+		deliveries = append(deliveries, delivery)
+		return true
+	})
+	return deliveries, err
+}
+
+// nextID returns ids sorting in creation order, so the outbox is
+// delivered in order.
+func (d *Dispatcher) nextID() string {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.seq++
+	return fmt.Sprintf("%020d-%06d", time.Now().UnixNano(), d.seq%1000000)
+}
+
+// Sign returns the hex encoded HMAC-SHA256 of the payload, as sent in the
+// X-Signature-256 header.
+func Sign(secret, payload []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write(payload)
+	return hex.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,340 @@
+package webhook
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/jmsilvadev/tx-parser/pkg/events"
+	"github.com/jmsilvadev/tx-parser/pkg/jsonrpc"
+	"github.com/jmsilvadev/tx-parser/pkg/logger"
+	"github.com/jmsilvadev/tx-parser/pkg/parser"
+	"github.com/jmsilvadev/tx-parser/pkg/parser/memorydb"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap/zapcore"
+)
+
+var tx = parser.Transaction{
+	Hash:        "0xabc",
+	From:        "0x123",
+	To:          "0x456",
+	Value:       "0x1",
+	BlockNumber: 1,
+}
+
+// loopback lets the tests deliver to their httptest servers.
+var loopback = WithAllowedNetworks([]*net.IPNet{{IP: net.IPv4(127, 0, 0, 0), Mask: net.CIDRMask(8, 32)}})
+
+func TestMain(m *testing.M) {
+	// the tests do not depend on a resolver, hosts named internal resolve to
+	// a private address and the others to a public one
+	lookupIPAddr = func(_ context.Context, host string) ([]net.IPAddr, error) {
+		if strings.HasPrefix(host, "internal.") {
+			return []net.IPAddr{{IP: net.IPv4(10, 0, 0, 1)}}, nil
+		}
+		return []net.IPAddr{{IP: net.IPv4(93, 184, 215, 14)}}, nil
+	}
+	os.Exit(m.Run())
+}
+
+func newStore() parser.Store {
+	l := logger.New(zapcore.DebugLevel)
+	return memorydb.New(jsonrpc.NewEthereum(l, "http://localhost:8545"), l)
+}
+
+// subscribe subscribes the tenant of ctx to the address, its webhooks
+// being only notified while it is.
+func subscribe(ctx context.Context, d *Dispatcher, address string) {
+	d.store.(parser.Parser).Subscribe(ctx, address)
+}
+
+func TestRegister(t *testing.T) {
+	l := logger.New(zapcore.DebugLevel)
+	d := New(newStore(), l, []byte("secret"))
+
+	assert.ErrorIs(t, d.Register(context.Background(), "0x123", "ftp://example.com"), ErrInvalidURL)
+	assert.ErrorIs(t, d.Register(context.Background(), "0x123", "/relative"), ErrInvalidURL)
+	assert.NoError(t, d.Register(context.Background(), "0xABC", "https://example.com/hook"))
+
+	hook, ok := d.Webhook(context.Background(), "0xabc")
+	assert.True(t, ok)
+	assert.Equal(t, "https://example.com/hook", hook)
+
+	_, ok = d.Webhook(context.Background(), "0x123")
+	assert.False(t, ok)
+}
+
+func TestDeliverySigned(t *testing.T) {
+	l := logger.New(zapcore.DebugLevel)
+
+	received := make(chan *http.Request, 1)
+	bodies := make(chan []byte, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		received <- r
+		bodies <- body
+	}))
+	defer srv.Close()
+
+	d := New(newStore(), l, []byte("secret"), loopback)
+	require.NoError(t, d.Register(context.Background(), "0x456", srv.URL))
+	subscribe(context.Background(), d, "0x456")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go d.Run(ctx)
+	d.Notify(ctx, tx)
+
+	select {
+	case r := <-received:
+		body := <-bodies
+		assert.Equal(t, "sha256="+Sign([]byte("secret"), body), r.Header.Get(SignatureHeader))
+		assert.NotEmpty(t, r.Header.Get(DeliveryHeader))
+
+		var payload Payload
+		require.NoError(t, json.Unmarshal(body, &payload))
+		assert.Equal(t, "0x456", payload.Address)
+		assert.Equal(t, tx, payload.Transaction)
+	case <-time.After(time.Second):
+		t.Fatal("webhook not delivered")
+	}
+
+	assert.Eventually(t, func() bool {
+		pending, _ := d.Pending()
+		return len(pending) == 0
+	}, time.Second, 10*time.Millisecond)
+}
+
+func TestRetriesAndDeadLetters(t *testing.T) {
+	l := logger.New(zapcore.DebugLevel)
+
+	var calls int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer srv.Close()
+
+	store := newStore()
+	d := New(store, l, []byte("secret"), WithMaxAttempts(3), WithBackoff(time.Millisecond, 5*time.Millisecond), WithInterval(5*time.Millisecond), loopback)
+	require.NoError(t, d.Register(context.Background(), "0x123", srv.URL))
+	subscribe(context.Background(), d, "0x123")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	d.Notify(ctx, tx)
+
+	// a restarted dispatcher picks the outbox up from the store
+	restarted := New(store, l, []byte("secret"), WithMaxAttempts(3), WithBackoff(time.Millisecond, 5*time.Millisecond), WithInterval(5*time.Millisecond), loopback)
+	go restarted.Run(ctx)
+
+	assert.Eventually(t, func() bool {
+		dead, _ := restarted.DeadLetters()
+		return len(dead) == 1
+	}, 2*time.Second, 10*time.Millisecond)
+
+	dead, err := restarted.DeadLetters()
+	require.NoError(t, err)
+	assert.Equal(t, 3, dead[0].Attempts)
+	assert.Contains(t, dead[0].LastError, "500")
+	assert.Equal(t, int32(3), atomic.LoadInt32(&calls))
+
+	pending, err := restarted.Pending()
+	require.NoError(t, err)
+	assert.Empty(t, pending)
+}
+
+func TestDeliveryOrder(t *testing.T) {
+	l := logger.New(zapcore.DebugLevel)
+
+	var calls int32
+	received := make(chan string, 3)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&calls, 1) == 1 {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		var payload Payload
+		_ = json.NewDecoder(r.Body).Decode(&payload)
+		received <- payload.Transaction.Hash
+	}))
+	defer srv.Close()
+
+	d := New(newStore(), l, []byte("secret"), WithBackoff(time.Millisecond, 5*time.Millisecond), loopback)
+	require.NoError(t, d.Register(context.Background(), tx.To, srv.URL))
+	subscribe(context.Background(), d, tx.To)
+
+	ctx := context.Background()
+	first, second := tx, tx
+	second.Hash = "0xdef"
+	d.Notify(ctx, first)
+	d.Notify(ctx, second)
+
+	// the second delivery waits for the first one to succeed
+	d.flush(ctx)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&calls))
+	pending, err := d.Pending()
+	require.NoError(t, err)
+	assert.Len(t, pending, 2)
+
+	assert.Eventually(t, func() bool {
+		d.flush(ctx)
+		pending, _ := d.Pending()
+		return len(pending) == 0
+	}, time.Second, 10*time.Millisecond)
+	assert.Equal(t, first.Hash, <-received)
+	assert.Equal(t, second.Hash, <-received)
+}
+
+func TestNotifyWithoutWebhook(t *testing.T) {
+	l := logger.New(zapcore.DebugLevel)
+	d := New(newStore(), l, []byte("secret"))
+	d.Notify(context.Background(), tx)
+
+	pending, err := d.Pending()
+	require.NoError(t, err)
+	assert.Empty(t, pending)
+}
+
+func TestHandle(t *testing.T) {
+	l := logger.New(zapcore.DebugLevel)
+	d := New(newStore(), l, []byte("secret"))
+	require.NoError(t, d.Register(context.Background(), tx.To, "http://example.com/hook"))
+	subscribe(context.Background(), d, tx.To)
+
+	d.Handle(context.Background(), events.BlockIngested{BlockNumber: 1})
+	d.Handle(context.Background(), events.TransactionMatched{Transaction: tx, Addresses: []string{tx.To}})
+
+	pending, err := d.Pending()
+	require.NoError(t, err)
+	assert.Len(t, pending, 1)
+}
+
+func TestTenants(t *testing.T) {
+	l := logger.New(zapcore.DebugLevel)
+	store := newStore()
+	d := New(store, l, []byte("secret"))
+	acme := parser.NewTenantContext(context.Background(), "acme")
+
+	require.NoError(t, d.Register(context.Background(), tx.To, "http://example.com/default"))
+	require.NoError(t, d.Register(acme, tx.To, "http://example.com/acme"))
+
+	hook, ok := d.Webhook(acme, tx.To)
+	assert.True(t, ok)
+	assert.Equal(t, "http://example.com/acme", hook)
+	_, err := store.Get("webhook:" + tx.To)
+	assert.NoError(t, err)
+
+	// each subscribed tenant gets its own delivery
+	subscribe(context.Background(), d, tx.To)
+	subscribe(acme, d, tx.To)
+	d.Notify(context.Background(), tx)
+	pending, err := d.Pending()
+	require.NoError(t, err)
+	require.Len(t, pending, 2)
+	assert.ElementsMatch(t, []string{"http://example.com/default", "http://example.com/acme"}, []string{pending[0].URL, pending[1].URL})
+
+	// a tenant no longer subscribed gets nothing, whether its webhook was
+	// removed or not
+	store.(parser.Parser).Unsubscribe(acme, tx.To)
+	d.Notify(context.Background(), tx)
+	pending, err = d.Pending()
+	require.NoError(t, err)
+	require.Len(t, pending, 3)
+	assert.Equal(t, "http://example.com/default", pending[2].URL)
+
+	require.NoError(t, d.Unregister(acme, tx.To))
+	_, ok = d.Webhook(acme, tx.To)
+	assert.False(t, ok)
+	_, ok = d.Webhook(context.Background(), tx.To)
+	assert.True(t, ok)
+}
+
+func TestForbiddenAddresses(t *testing.T) {
+	l := logger.New(zapcore.DebugLevel)
+	store := newStore()
+	d := New(store, l, []byte("secret"), WithMaxAttempts(1), WithInterval(5*time.Millisecond))
+
+	for _, hook := range []string{
+		"http://127.0.0.1:8080/hook",
+		"http://[::1]/hook",
+		"http://169.254.169.254/latest/meta-data",
+		"http://10.1.2.3/hook",
+		"http://192.168.0.1/hook",
+		"http://0.0.0.0/hook",
+		"https://internal.example.com/hook",
+	} {
+		assert.ErrorIs(t, d.Register(context.Background(), "0x123", hook), ErrForbiddenAddress, hook)
+	}
+	assert.NoError(t, d.Register(context.Background(), "0x789", "https://example.com/hook"))
+
+	allowed := New(newStore(), l, []byte("secret"), WithAllowedNetworks([]*net.IPNet{{IP: net.IPv4(10, 0, 0, 0), Mask: net.CIDRMask(8, 32)}}))
+	assert.NoError(t, allowed.Register(context.Background(), "0x123", "https://internal.example.com/hook"))
+	assert.ErrorIs(t, allowed.Register(context.Background(), "0x123", "http://127.0.0.1/hook"), ErrForbiddenAddress)
+
+	// a host rebound to an internal address after its registration is
+	// refused when connecting
+	var calls int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+	}))
+	defer srv.Close()
+	require.NoError(t, store.Put(webhookKey(parser.DefaultTenant, tx.To), []byte(srv.URL)))
+	subscribe(context.Background(), d, tx.To)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go d.Run(ctx)
+	d.Notify(ctx, tx)
+
+	assert.Eventually(t, func() bool {
+		dead, _ := d.DeadLetters()
+		return len(dead) == 1
+	}, time.Second, 10*time.Millisecond)
+	dead, err := d.DeadLetters()
+	require.NoError(t, err)
+	assert.Contains(t, dead[0].LastError, ErrForbiddenAddress.Error())
+	assert.Zero(t, atomic.LoadInt32(&calls))
+}
+
+func TestSlowEndpoint(t *testing.T) {
+	l := logger.New(zapcore.DebugLevel)
+
+	release := make(chan struct{})
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer slow.Close()
+	defer close(release)
+	delivered := make(chan struct{}, 1)
+	fast := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		delivered <- struct{}{}
+	}))
+	defer fast.Close()
+
+	d := New(newStore(), l, []byte("secret"), loopback)
+	require.NoError(t, d.Register(context.Background(), tx.From, slow.URL))
+	require.NoError(t, d.Register(context.Background(), tx.To, fast.URL))
+	subscribe(context.Background(), d, tx.From)
+	subscribe(context.Background(), d, tx.To)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go d.Run(ctx)
+	d.Notify(ctx, tx)
+
+	select {
+	case <-delivered:
+	case <-time.After(time.Second):
+		t.Fatal("delivery held up by a slow endpoint")
+	}
+}